@@ -1,15 +1,20 @@
 package db
 
 import (
+	"fmt"
+	"io/fs"
+	"log"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"support/obj"
+	"support/storage"
 	"time"
 
 	"errors"
 
 	"github.com/glebarez/sqlite" // Pure go SQLite driver, checkout https://github.com/glebarez/sqlite for details
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -25,8 +30,34 @@ var UploadStoragePath = path.Join(DATA_DIR, UPLOAD_DIR)
 // 圖片訪問的URL路徑
 var UploadURLPath = "/" + UPLOAD_DIR + "/"
 
+// 圖片縮圖與不同寬度版本的快取目錄，可隨時刪除，需要時會重新產生
+var VariantStoragePath = path.Join(DATA_DIR, "variants")
+
+// 分段上傳尚未完成的暫存檔目錄，伺服器未執行時可隨時清空
+var ChunkStoragePath = path.Join(DATA_DIR, "chunks")
+
+// ImageVariantDir 單張圖片的縮圖快取目錄
+func ImageVariantDir(id uint) string {
+	return path.Join(VariantStoragePath, strconv.FormatUint(uint64(id), 10))
+}
+
 var db *gorm.DB // Global variable to hold the database connection
 
+// 預設的管理員帳號密碼，僅在未指定初始帳號時使用，登入後必須立即變更
+const (
+	DefaultAdminUsername = "admin"
+	DefaultAdminPassword = "admin"
+)
+
+// 由環境變數或命令列參數指定的初始管理員帳號
+var initialAdminUsername, initialAdminPassword string
+
+// SetInitialAdmin 指定建立新資料庫時使用的管理員帳號密碼，必須在第一次呼叫 DB() 之前設定
+func SetInitialAdmin(username, password string) {
+	initialAdminUsername = username
+	initialAdminPassword = password
+}
+
 func DB() (*gorm.DB, error) {
 	if db != nil {
 		return db, nil // Return the existing database connection if it exists
@@ -43,47 +74,129 @@ func DB() (*gorm.DB, error) {
 		return nil, err
 	}
 
+	// 加入角色之前建立的使用者都是管理員，遷移後保留原有權限
+	hadRole := db.Migrator().HasColumn(&obj.User{}, "Role")
+
 	// 自動遷移所有資料結構
-	err = db.AutoMigrate(&obj.Category{}, &obj.Doc{}, &obj.User{}, &obj.AdminSession{}, &obj.Image{})
+	err = db.AutoMigrate(&obj.Category{}, &obj.Doc{}, &obj.User{}, &obj.AdminSession{}, &obj.Image{}, &obj.Snippet{}, &obj.Variable{}, &obj.LinkIssue{}, &obj.RecoveryCode{}, &obj.Setting{}, &obj.LoginAttempt{}, &obj.AuditLog{}, &obj.APIToken{}, &obj.PasswordResetToken{}, &obj.PasswordHistory{}, &obj.ImageUsage{})
 	if err != nil {
 		return nil, err
 	}
 
+	if !hadRole {
+		if err := db.Exec("UPDATE users SET role = ?", obj.RoleAdmin).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := db.Exec("UPDATE users SET role = ? WHERE role IS NULL OR role = ''", obj.RoleAdmin).Error; err != nil {
+		return nil, err
+	}
+
 	// 檢查是否需要創建預設用戶
 	var count int64
 	db.Model(&obj.User{}).Count(&count)
 	if count == 0 {
-		// 創建一個預設使用者，預設用戶名和密碼都是 admin
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
+		// 未指定初始帳號時，使用預設帳號密碼並要求登入後立即變更
+		username, password, mustChange := initialAdminUsername, initialAdminPassword, false
+		if username == "" || password == "" {
+			username, password, mustChange = DefaultAdminUsername, DefaultAdminPassword, true
+		}
+		hashedPassword, err := HashPassword(password)
 		if err != nil {
 			return nil, err
 		}
 		defaultUser := &obj.User{
-			Username: "admin",
-			Password: string(hashedPassword), // 使用雜湊存儲密碼
+			Username:           username,
+			Password:           hashedPassword, // 使用雜湊存儲密碼
+			Role:               obj.RoleAdmin,
+			MustChangePassword: mustChange,
 		}
 		db.Create(defaultUser)
+	} else {
+		markDefaultAdmin(db)
 	}
 
 	return db, nil
 }
 
-// HashPassword 將密碼加密為雜湊值
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+// markDefaultAdmin 既有資料庫仍可用預設帳號密碼登入時，要求該帳號完成初始設定
+func markDefaultAdmin(db *gorm.DB) {
+	var user obj.User
+	err := db.Where("username = ? AND must_change_password = ?", DefaultAdminUsername, false).First(&user).Error
+	if err != nil {
+		return
+	}
+	if VerifyPassword(user.Password, DefaultAdminPassword) {
+		db.Model(&user).Update("must_change_password", true)
+	}
+}
+
+// CompleteInitialSetup 完成初始設定：變更帳號名稱與密碼，並登出該帳號所有的會話
+func CompleteInitialSetup(id uint, oldUsername, newUsername, email, hashedPassword string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user obj.User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+		if err := setPassword(tx, user, hashedPassword); err != nil {
+			return err
+		}
+		err := tx.Model(&obj.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"username":             newUsername,
+			"email":                email,
+			"must_change_password": false,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM admin_sessions WHERE username = ?", oldUsername).Error
+	})
+}
+
+// 每個用戶最多保留的舊密碼數量
+const maxPasswordHistory = 24
+
+// 更新用戶密碼，並將原本的密碼加入歷史紀錄
+func setPassword(tx *gorm.DB, user obj.User, hashedPassword string) error {
+	if user.Password != "" {
+		if err := tx.Create(&obj.PasswordHistory{UserID: user.ID, Password: user.Password}).Error; err != nil {
+			return err
+		}
+		err := tx.Exec("DELETE FROM password_histories WHERE user_id = ? AND id NOT IN (SELECT id FROM password_histories WHERE user_id = ? ORDER BY id DESC LIMIT ?)",
+			user.ID, user.ID, maxPasswordHistory).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Model(&obj.User{}).Where("id = ?", user.ID).Update("password", hashedPassword).Error
+}
+
+// GetPasswordHistory 取得用戶最近用過的舊密碼雜湊值，最新的排在前面
+func GetPasswordHistory(userID uint, limit int) ([]string, error) {
+	db, err := DB()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(bytes), nil
+	var hashes []string
+	err = db.Model(&obj.PasswordHistory{}).Where("user_id = ?", userID).Order("id DESC").Limit(limit).Pluck("password", &hashes).Error
+	return hashes, err
 }
 
-// VerifyPassword 比較密碼和雜湊值
-func VerifyPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+// UpgradePasswordHash 以新的演算法或參數重新儲存同一個密碼的雜湊值，不列入密碼歷史。
+// 僅在密碼未被同時變更時更新
+func UpgradePasswordHash(userID uint, oldHash, newHash string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.User{}).Where("id = ? AND password = ?", userID, oldHash).Update("password", newHash).Error
 }
 
-// ChangeUserPassword 修改用戶密碼
+// ChangeUserPassword 修改用戶密碼，並撤銷用戶所有的 API token
 func ChangeUserPassword(username, currentPassword, newPassword string) error {
 	user, err := GetUserByUsername(username)
 	if err != nil {
@@ -107,7 +220,12 @@ func ChangeUserPassword(username, currentPassword, newPassword string) error {
 		return err
 	}
 
-	return db.Model(&obj.User{}).Where("username = ?", username).Update("password", hashedPassword).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := setPassword(tx, user, hashedPassword); err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM api_tokens WHERE username = ?", user.Username).Error
+	})
 }
 
 // GetCategoryList 獲取所有分類
@@ -280,6 +398,8 @@ func AddDoc(doc *obj.Doc) error {
 		"content":     doc.Content,
 		"category_id": doc.CategoryID,
 		"is_draft":    doc.IsDraft,
+		"show_toc":    doc.ShowTOC,
+		"toc_depth":   doc.TOCDepth,
 	}
 
 	// 處理發布日期
@@ -326,6 +446,8 @@ func UpdateDoc(doc *obj.Doc) error {
 		"content":        doc.Content,
 		"category_id":    doc.CategoryID,
 		"is_draft":       doc.IsDraft,
+		"show_toc":       doc.ShowTOC,
+		"toc_depth":      doc.TOCDepth,
 		"last_edit_date": doc.LastEditDate,
 	}
 
@@ -339,13 +461,27 @@ func UpdateDoc(doc *obj.Doc) error {
 	return db.Model(&obj.Doc{}).Where("id = ?", doc.ID).Updates(updates).Error
 }
 
+// UpdateDocContent 只更新文件內容，不變更最後編輯時間，用於系統替換引用等非編輯的修改
+func UpdateDocContent(id uint, content string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.Doc{}).Where("id = ?", id).UpdateColumn("content", content).Error
+}
+
 // DeleteDoc 刪除文件
 func DeleteDoc(id uint) error {
 	db, err := DB()
 	if err != nil {
 		return err
 	}
-	return db.Delete(&obj.Doc{}, id).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("doc_id = ?", id).Delete(&obj.ImageUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&obj.Doc{}, id).Error
+	})
 }
 
 // GetUserByUsername 通過用戶名獲取用戶
@@ -359,38 +495,262 @@ func GetUserByUsername(username string) (obj.User, error) {
 	return user, result.Error
 }
 
+// GetUserByOIDCSubject 通過身分提供者與使用者識別碼獲取單一登入帳號
+func GetUserByOIDCSubject(issuer, subject string) (obj.User, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.User{}, err
+	}
+	var user obj.User
+	result := db.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).First(&user)
+	return user, result.Error
+}
+
+// GetUsersByEmail 通過電子郵件獲取用戶，不分大小寫
+func GetUsersByEmail(email string) ([]obj.User, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+	var users []obj.User
+	result := db.Where("LOWER(email) = LOWER(?)", email).Order("id").Find(&users)
+	return users, result.Error
+}
+
+// GetUserList 獲取所有用戶
+func GetUserList() ([]obj.User, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+	var users []obj.User
+	result := db.Order("id").Find(&users)
+	return users, result.Error
+}
+
+// GetUser 通過 ID 獲取用戶
+func GetUser(id uint) (obj.User, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.User{}, err
+	}
+	var user obj.User
+	result := db.First(&user, id)
+	return user, result.Error
+}
+
+// AddUser 新增用戶，密碼需先經過雜湊
+func AddUser(user *obj.User) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Create(user).Error
+}
+
+// UpdateUser 更新用戶的角色、電子郵件與停用狀態
+func UpdateUser(id uint, role, email string, disabled bool) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"role":     role,
+		"email":    email,
+		"disabled": disabled,
+	}).Error
+}
+
+// UpdateUserRole 更新用戶的角色，用於單一登入時依群組同步角色
+func UpdateUserRole(id uint, role string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.User{}).Where("id = ?", id).Update("role", role).Error
+}
+
+// DeleteUser 刪除用戶及其所有會話
+func DeleteUser(id uint) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user obj.User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM admin_sessions WHERE username = ?", user.Username).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM api_tokens WHERE username = ?", user.Username).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ?", user.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM password_histories WHERE user_id = ?", user.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+}
+
+// CountActiveAdmins 計算未停用的管理員數量，用於避免移除最後一位管理員
+func CountActiveAdmins() (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	result := db.Model(&obj.User{}).Where("role = ? AND disabled = ?", obj.RoleAdmin, false).Count(&count)
+	return count, result.Error
+}
+
+// SetUserTOTPSecret 保存尚未啟用的兩步驟驗證金鑰
+func SetUserTOTPSecret(id uint, secret string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.User{}).Where("id = ? AND totp_enabled = ?", id, false).
+		Update("totp_secret", secret).Error
+}
+
+// EnableUserTOTP 啟用兩步驟驗證並保存備用碼
+func EnableUserTOTP(id uint, step int64, codeHashes []string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&obj.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, id, codeHashes)
+	})
+}
+
+// DisableUserTOTP 停用兩步驟驗證，清除金鑰與備用碼，並撤銷在兩步驟驗證保護下建立的 API token
+func DisableUserTOTP(id uint) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user obj.User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM api_tokens WHERE username = ?", user.Username).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&obj.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&obj.RecoveryCode{}).Error
+	})
+}
+
+// UseTOTPStep 記錄已使用的時間步，同一時間步或更早的驗證碼無法再次使用
+// 回傳 false 表示該驗證碼已被使用過
+func UseTOTPStep(id uint, step int64) (bool, error) {
+	db, err := DB()
+	if err != nil {
+		return false, err
+	}
+	result := db.Model(&obj.User{}).Where("id = ? AND totp_last_step < ?", id, step).Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReplaceRecoveryCodes 以新的備用碼取代使用者所有備用碼
+func ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codeHashes []string) error {
+	err := tx.Where("user_id = ?", userID).Delete(&obj.RecoveryCode{}).Error
+	if err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		err = tx.Create(&obj.RecoveryCode{UserID: userID, CodeHash: hash}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode 使用一組備用碼，成功時刪除該備用碼
+func UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	db, err := DB()
+	if err != nil {
+		return false, err
+	}
+	result := db.Where("user_id = ? AND code_hash = ?", userID, codeHash).Delete(&obj.RecoveryCode{})
+	return result.RowsAffected == 1, result.Error
+}
+
+// CountRecoveryCodes 計算使用者剩餘的備用碼數量
+func CountRecoveryCodes(userID uint) (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	result := db.Model(&obj.RecoveryCode{}).Where("user_id = ?", userID).Count(&count)
+	return count, result.Error
+}
+
 // UpdateUserPassword 更新用戶密碼
 func UpdateUserPassword(userID uint, newPassword string) error {
 	// 對新密碼進行雜湊處理
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	hashedPassword, err := HashPassword(newPassword)
 	if err != nil {
 		return err
 	}
 
+	user, err := GetUser(userID)
+	if err != nil {
+		return err
+	}
 	db, err := DB()
 	if err != nil {
 		return err
 	}
-	return db.Model(&obj.User{}).Where("id = ?", userID).Update("password", string(hashedPassword)).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		return setPassword(tx, user, hashedPassword)
+	})
 }
 
 // AdminSession 相關的資料庫操作
-// 保存管理員會話到資料庫
+// 保存管理員會話到資料庫，同一用戶可同時擁有多個會話
 func SaveAdminSession(session *obj.AdminSession) error {
 	db, err := DB()
 	if err != nil {
 		return err
 	}
 
-	// 刪除該用戶之前的會話
-	err = db.Exec("DELETE FROM admin_sessions WHERE username = ?", session.Username).Error
-	if err != nil {
-		return err
-	}
-
-	// 插入新會話
-	result := db.Exec("INSERT INTO admin_sessions (session_id, username, expiry) VALUES (?, ?, ?)",
-		session.SessionID, session.Username, session.Expiry)
+	now := time.Now()
+	result := db.Exec("INSERT INTO admin_sessions (session_id, username, expiry, ip_address, user_agent, last_seen, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		session.SessionID, session.Username, session.Expiry, session.IPAddress, session.UserAgent, now, now)
 	err = result.Error
 	return err
 }
@@ -403,110 +763,931 @@ func GetAdminSession(sessionID string) (*obj.AdminSession, error) {
 	}
 
 	var session obj.AdminSession
-	err = db.Raw("SELECT session_id, username, expiry FROM admin_sessions WHERE session_id = ?", sessionID).
+	err = db.Raw("SELECT * FROM admin_sessions WHERE session_id = ?", sessionID).
 		Scan(&session).Error
 	if err != nil {
 		return nil, err
 	}
+	// Scan 查無資料時不會回傳錯誤
+	if session.SessionID == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
 
 	return &session, nil
 }
 
-// 刪除管理員會話
-func DeleteAdminSession(sessionID string) error {
+// 獲取用戶所有未過期的會話，最近使用的排在前面
+func GetUserAdminSessions(username string) ([]obj.AdminSession, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []obj.AdminSession
+	err = db.Where("username = ? AND expiry > ?", username, time.Now()).
+		Order("last_seen DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// 更新會話的最後使用時間
+func TouchAdminSession(sessionID string, lastSeen time.Time) error {
 	db, err := DB()
 	if err != nil {
 		return err
 	}
 
-	err = db.Exec("DELETE FROM admin_sessions WHERE session_id = ?", sessionID).Error
-	return err
+	return db.Exec("UPDATE admin_sessions SET last_seen = ? WHERE session_id = ?", lastSeen, sessionID).Error
 }
 
-// 清理過期的管理員會話
-func CleanExpiredAdminSessions() error {
+// 刪除管理員會話
+func DeleteAdminSession(sessionID string) error {
 	db, err := DB()
 	if err != nil {
 		return err
 	}
 
-	err = db.Exec("DELETE FROM admin_sessions WHERE expiry < ?", time.Now()).Error
+	err = db.Exec("DELETE FROM admin_sessions WHERE session_id = ?", sessionID).Error
 	return err
 }
 
-// ---- 圖片相關功能 ----
-
-// 圖片上傳目錄
-var uploadDir = UploadStoragePath
+// 根據資料表ID刪除指定用戶的會話，限定用戶避免刪除他人的會話
+func DeleteUserAdminSession(username string, id uint) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
 
-// 確保上傳目錄存在
-func EnsureUploadDir() error {
-	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
-		return os.MkdirAll(uploadDir, 0755)
+	result := db.Exec("DELETE FROM admin_sessions WHERE id = ? AND username = ?", id, username)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
 	return nil
 }
 
-// 添加圖片記錄到資料庫
-func AddImage(image *obj.Image) error {
+// 刪除用戶除了指定會話以外的所有會話，keepSessionID 為空時刪除全部
+func DeleteOtherAdminSessions(username, keepSessionID string) error {
 	db, err := DB()
 	if err != nil {
 		return err
 	}
-	return db.Create(image).Error
+
+	return db.Exec("DELETE FROM admin_sessions WHERE username = ? AND session_id <> ?", username, keepSessionID).Error
 }
 
-// 獲取圖片記錄
-func GetImage(id uint) (obj.Image, error) {
+// 清理過期的管理員會話
+func CleanExpiredAdminSessions() error {
 	db, err := DB()
 	if err != nil {
-		return obj.Image{}, err
+		return err
 	}
-	var image obj.Image
-	result := db.First(&image, id)
-	return image, result.Error
+
+	err = db.Exec("DELETE FROM admin_sessions WHERE expiry < ?", time.Now()).Error
+	return err
 }
 
-// 獲取所有圖片
-func GetAllImages() ([]obj.Image, error) {
+// ---- API token ----
+
+// 新增 API token
+func AddAPIToken(token *obj.APIToken) error {
 	db, err := DB()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var images []obj.Image
-	result := db.Order("upload_time DESC").Find(&images)
-	return images, result.Error
+
+	return db.Create(token).Error
 }
 
-// 刪除圖片記錄和檔案
-func DeleteImage(id uint) error {
-	// 先獲取圖片記錄
-	image, err := GetImage(id)
+// 根據雜湊值獲取 API token
+func GetAPITokenByHash(tokenHash string) (obj.APIToken, error) {
+	db, err := DB()
 	if err != nil {
-		return err
+		return obj.APIToken{}, err
 	}
 
-	// 刪除實體檔案
-	err = os.Remove(image.Path)
-	if err != nil && !os.IsNotExist(err) {
-		return err
+	var token obj.APIToken
+	err = db.Where("token_hash = ?", tokenHash).First(&token).Error
+	return token, err
+}
+
+// 獲取用戶所有的 API token，最新建立的排在前面
+func GetUserAPITokens(username string) ([]obj.APIToken, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
 	}
 
-	// 從資料庫刪除記錄
+	var tokens []obj.APIToken
+	err = db.Where("username = ?", username).Order("id DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// 更新 API token 的最後使用時間與來源 IP
+func TouchAPIToken(id uint, lastUsed time.Time, ip string) error {
 	db, err := DB()
 	if err != nil {
 		return err
 	}
-	return db.Delete(&obj.Image{}, id).Error
+
+	return db.Exec("UPDATE api_tokens SET last_used_at = ?, last_used_ip = ? WHERE id = ?", lastUsed, ip, id).Error
 }
 
-// 根據文件名搜尋圖片
-func SearchImagesByFilename(keyword string) ([]obj.Image, error) {
+// 刪除指定用戶的 API token 並回傳被刪除的 token，限定用戶避免撤銷他人的 token
+func DeleteUserAPIToken(username string, id uint) (obj.APIToken, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.APIToken{}, err
+	}
+
+	var token obj.APIToken
+	if err := db.Where("id = ? AND username = ?", id, username).First(&token).Error; err != nil {
+		return obj.APIToken{}, err
+	}
+	return token, db.Delete(&token).Error
+}
+
+// ---- 重設密碼 ----
+
+// 新增重設密碼 token，同一用戶先前尚未使用的 token 一併作廢
+func AddPasswordResetToken(token *obj.PasswordResetToken) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL", token.UserID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// 獲取用戶最近一次申請的重設密碼 token
+func GetLatestPasswordResetToken(userID uint) (obj.PasswordResetToken, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.PasswordResetToken{}, err
+	}
+
+	var token obj.PasswordResetToken
+	err = db.Where("user_id = ?", userID).Order("id DESC").First(&token).Error
+	return token, err
+}
+
+// 根據雜湊值獲取尚未使用且未過期的重設密碼 token
+func GetPasswordResetToken(tokenHash string) (obj.PasswordResetToken, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.PasswordResetToken{}, err
+	}
+
+	var token obj.PasswordResetToken
+	err = db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(&token).Error
+	return token, err
+}
+
+// 使用重設密碼 token 更新密碼，並登出該用戶的所有裝置。
+// 以條件更新標記 token 已使用，同一個 token 同時送出多次也只有一次會成功
+func ResetUserPassword(tokenID, userID uint, hashedPassword string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("UPDATE password_reset_tokens SET used_at = ? WHERE id = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?",
+			time.Now(), tokenID, userID, time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var user obj.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if err := setPassword(tx, user, hashedPassword); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM api_tokens WHERE username = ?", user.Username).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM admin_sessions WHERE username = ?", user.Username).Error
+	})
+}
+
+// ---- 登入嘗試紀錄 ----
+
+// AddLoginAttempt 新增登入嘗試紀錄
+func AddLoginAttempt(attempt *obj.LoginAttempt) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Create(attempt).Error
+}
+
+// GetRecentUserLoginAttempts 取得帳號在指定時間後的登入嘗試，不含因鎖定而擋下的嘗試，最新的排在前面
+func GetRecentUserLoginAttempts(username string, since time.Time, limit int) ([]obj.LoginAttempt, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+
+	var attempts []obj.LoginAttempt
+	err = db.Where("username = ? AND blocked = ? AND create_time > ?", username, false, since).
+		Order("id DESC").Limit(limit).Find(&attempts).Error
+	return attempts, err
+}
+
+// GetRecentIPLoginFailures 取得 IP 在指定時間後的登入失敗紀錄，不含因鎖定而擋下的嘗試，最新的排在前面。
+// 成功的登入不會重置 IP 的失敗次數，避免攻擊者以自己的帳號登入來清除紀錄
+func GetRecentIPLoginFailures(ip string, since time.Time, limit int) ([]obj.LoginAttempt, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+
+	var attempts []obj.LoginAttempt
+	err = db.Where("ip_address = ? AND success = ? AND blocked = ? AND create_time > ?", ip, false, false, since).
+		Order("id DESC").Limit(limit).Find(&attempts).Error
+	return attempts, err
+}
+
+// GetLoginFailures 取得登入失敗紀錄，keyword 可過濾帳號或 IP
+func GetLoginFailures(keyword string, limit int) ([]obj.LoginAttempt, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.Where("success = ?", false)
+	if keyword != "" {
+		query = query.Where("username = ? OR ip_address = ?", keyword, keyword)
+	}
+	var attempts []obj.LoginAttempt
+	err = query.Order("id DESC").Limit(limit).Find(&attempts).Error
+	return attempts, err
+}
+
+// ---- 操作紀錄 ----
+// 操作紀錄只提供新增與查詢，不提供修改或刪除
+
+// AddAuditLog 新增操作紀錄
+func AddAuditLog(entry *obj.AuditLog) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Create(entry).Error
+}
+
+// auditQuery 依條件建立操作紀錄的查詢
+func auditQuery(db *gorm.DB, filter obj.AuditFilter) *gorm.DB {
+	query := db.Model(&obj.AuditLog{})
+	if filter.Username != "" {
+		query = query.Where("username = ?", filter.Username)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("create_time >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("create_time < ?", filter.To)
+	}
+	return query
+}
+
+// GetAuditLogs 依條件取得操作紀錄，最新的排在前面，limit 為 0 時不限制筆數
+func GetAuditLogs(filter obj.AuditFilter, limit int) ([]obj.AuditLog, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+
+	query := auditQuery(db, filter).Order("id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var logs []obj.AuditLog
+	err = query.Find(&logs).Error
+	return logs, err
+}
+
+// CountAuditLogs 依條件計算操作紀錄的筆數
+func CountAuditLogs(filter obj.AuditFilter) (int64, error) {
+	db, err := DB()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = auditQuery(db, filter).Count(&count).Error
+	return count, err
+}
+
+// GetAuditUsernames 取得曾出現在操作紀錄中的使用者名稱
+func GetAuditUsernames() ([]string, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	err = db.Model(&obj.AuditLog{}).Distinct("username").Order("username").Pluck("username", &names).Error
+	return names, err
+}
+
+// ---- 圖片相關功能 ----
+
+// 圖片上傳目錄
+var uploadDir = UploadStoragePath
+
+// Uploads 保存上傳檔案的後端，預設為本機的上傳目錄
+var Uploads storage.Storage = storage.NewLocal(UploadStoragePath)
+
+// SetUploadStorage 設定保存上傳檔案的後端，應在伺服器啟動時呼叫
+func SetUploadStorage(s storage.Storage) {
+	Uploads = s
+}
+
+// UploadKey 圖片在儲存後端中的 key，即網址中 /uploads/ 之後的部分
+func UploadKey(image obj.Image) string {
+	return strings.TrimPrefix(image.URL, UploadURLPath)
+}
+
+// 確保上傳目錄存在
+func EnsureUploadDir() error {
+	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
+		return os.MkdirAll(uploadDir, 0755)
+	}
+	return nil
+}
+
+// 添加圖片記錄到資料庫
+func AddImage(image *obj.Image) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Create(image).Error
+}
+
+// 獲取圖片記錄
+func GetImage(id uint) (obj.Image, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.Image{}, err
+	}
+	var image obj.Image
+	result := db.First(&image, id)
+	return image, result.Error
+}
+
+// 獲取所有圖片
+func GetAllImages() ([]obj.Image, error) {
 	db, err := DB()
 	if err != nil {
 		return nil, err
 	}
 	var images []obj.Image
-	result := db.Where("filename LIKE ?", "%"+keyword+"%").Order("upload_time DESC").Find(&images)
+	result := db.Order("upload_time DESC").Find(&images)
 	return images, result.Error
 }
+
+// 刪除圖片記錄和檔案
+func DeleteImage(id uint) error {
+	// 先獲取圖片記錄
+	image, err := GetImage(id)
+	if err != nil {
+		return err
+	}
+
+	// 刪除儲存後端中的檔案
+	err = Uploads.Delete(UploadKey(image))
+	if err != nil {
+		return err
+	}
+
+	// 刪除快取的縮圖
+	err = os.RemoveAll(ImageVariantDir(id))
+	if err != nil {
+		return err
+	}
+
+	// 從資料庫刪除記錄與引用索引
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("image_id = ?", id).Delete(&obj.ImageUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&obj.Image{}, id).Error
+	})
+}
+
+// MigrateUploads 將所有圖片檔案從 src 搬移到 dst，並更新圖片記錄中的位置。
+// 已搬移過的檔案會略過，可在中斷後重新執行。回傳搬移的檔案數量
+func MigrateUploads(src, dst storage.Storage) (int, error) {
+	images, err := GetAllImages()
+	if err != nil {
+		return 0, err
+	}
+
+	moved, failed := 0, 0
+	for _, image := range images {
+		key := UploadKey(image)
+		srcInfo, srcErr := src.Stat(key)
+		if errors.Is(srcErr, fs.ErrNotExist) {
+			// 來源已沒有這個檔案，目標中有的話表示之前已搬移過
+			if _, err := dst.Stat(key); err == nil {
+				if image.Path != dst.Location(key) {
+					if err := UpdateImagePath(image.ID, dst.Location(key)); err != nil {
+						return moved, err
+					}
+				}
+				continue
+			}
+			log.Printf("Image %d (%s) is missing from %s", image.ID, key, src.Name())
+			failed++
+			continue
+		}
+		if srcErr != nil {
+			log.Printf("Error reading image %d (%s): %v", image.ID, key, srcErr)
+			failed++
+			continue
+		}
+
+		// 目標中已有相同大小的檔案時不重新上傳
+		if dstInfo, err := dst.Stat(key); err != nil || dstInfo.Size != srcInfo.Size {
+			if err := storage.Copy(dst, src, key, image.ContentType); err != nil {
+				log.Printf("Error copying image %d (%s): %v", image.ID, key, err)
+				failed++
+				continue
+			}
+		}
+		// 先更新記錄再刪除來源，中斷時檔案仍可從其中一個後端取得
+		if err := UpdateImagePath(image.ID, dst.Location(key)); err != nil {
+			return moved, err
+		}
+		if err := src.Delete(key); err != nil {
+			log.Printf("Error deleting image %d (%s) from %s: %v", image.ID, key, src.Name(), err)
+		}
+		moved++
+		log.Printf("Moved image %d (%s) to %s", image.ID, key, dst.Location(key))
+	}
+	if failed > 0 {
+		return moved, fmt.Errorf("%d 個檔案搬移失敗", failed)
+	}
+	return moved, nil
+}
+
+// UpdateImagePath 更新圖片在儲存後端中的位置
+func UpdateImagePath(id uint, location string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.Image{}).Where("id = ?", id).Update("path", location).Error
+}
+
+// 更新圖片的像素尺寸
+func UpdateImageSize(id uint, width, height int) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.Image{}).Where("id = ?", id).Updates(map[string]interface{}{"width": width, "height": height}).Error
+}
+
+// 根據內容雜湊獲取圖片記錄，有多筆時回傳最早上傳的
+func GetImageByHash(hash string) (obj.Image, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.Image{}, err
+	}
+	var image obj.Image
+	result := db.Where("content_hash = ?", hash).Order("id").First(&image)
+	return image, result.Error
+}
+
+// 更新圖片的內容雜湊
+func UpdateImageHash(id uint, hash string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.Image{}).Where("id = ?", id).Update("content_hash", hash).Error
+}
+
+// ---- 圖片引用索引 ----
+
+// 以文章、內容片段或內容變數目前引用的圖片取代原本的索引，column 為 doc_id、snippet_id 或 variable_id
+func setImageUsage(column string, id uint, imageIDs []uint) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(column+" = ?", id).Delete(&obj.ImageUsage{}).Error; err != nil {
+			return err
+		}
+		for _, imageID := range imageIDs {
+			usage := obj.ImageUsage{ImageID: imageID}
+			switch column {
+			case "doc_id":
+				usage.DocID = id
+			case "snippet_id":
+				usage.SnippetID = id
+			default:
+				usage.VariableID = id
+			}
+			if err := tx.Create(&usage).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetDocImageUsage 更新文章引用的圖片
+func SetDocImageUsage(docID uint, imageIDs []uint) error {
+	return setImageUsage("doc_id", docID, imageIDs)
+}
+
+// SetSnippetImageUsage 更新內容片段引用的圖片
+func SetSnippetImageUsage(snippetID uint, imageIDs []uint) error {
+	return setImageUsage("snippet_id", snippetID, imageIDs)
+}
+
+// SetVariableImageUsage 更新內容變數的值引用的圖片
+func SetVariableImageUsage(variableID uint, imageIDs []uint) error {
+	return setImageUsage("variable_id", variableID, imageIDs)
+}
+
+// ReplaceAllImageUsage 以重新計算的結果取代整個圖片引用索引
+func ReplaceAllImageUsage(usages []obj.ImageUsage) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&obj.ImageUsage{}).Error; err != nil {
+			return err
+		}
+		if len(usages) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(usages, 100).Error
+	})
+}
+
+// GetImageUsageCounts 取得每張圖片被多少篇文章、內容片段與內容變數引用，未被引用的圖片不在結果中
+func GetImageUsageCounts() (map[uint]obj.ImageUsageCount, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ImageID   uint
+		Docs      int
+		Snippets  int
+		Variables int
+	}
+	err = db.Model(&obj.ImageUsage{}).
+		Select("image_id, COUNT(DISTINCT NULLIF(doc_id, 0)) AS docs, COUNT(DISTINCT NULLIF(snippet_id, 0)) AS snippets, " +
+			"COUNT(DISTINCT NULLIF(variable_id, 0)) AS variables").
+		Group("image_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]obj.ImageUsageCount, len(rows))
+	for _, row := range rows {
+		counts[row.ImageID] = obj.ImageUsageCount{Docs: row.Docs, Snippets: row.Snippets, Variables: row.Variables}
+	}
+	return counts, nil
+}
+
+// GetImageUsers 取得引用圖片的文章、內容片段與內容變數
+func GetImageUsers(imageID uint) ([]obj.Doc, []obj.Snippet, []obj.Variable, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var docs []obj.Doc
+	err = db.Where("id IN (SELECT doc_id FROM image_usages WHERE image_id = ?)", imageID).Order("id").Find(&docs).Error
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var snippets []obj.Snippet
+	err = db.Where("id IN (SELECT snippet_id FROM image_usages WHERE image_id = ?)", imageID).Order("name").Find(&snippets).Error
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var variables []obj.Variable
+	err = db.Where("id IN (SELECT variable_id FROM image_usages WHERE image_id = ?)", imageID).Order("key").Find(&variables).Error
+	return docs, snippets, variables, err
+}
+
+// GetUnusedImages 取得沒有被任何文章、內容片段或內容變數引用、且在指定時間前上傳的圖片
+func GetUnusedImages(uploadedBefore time.Time) ([]obj.Image, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+	var images []obj.Image
+	result := db.Where("id NOT IN (SELECT image_id FROM image_usages) AND upload_time < ?", uploadedBefore).
+		Order("upload_time DESC").Find(&images)
+	return images, result.Error
+}
+
+// 根據 URL 獲取圖片記錄
+func GetImageByURL(url string) (obj.Image, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.Image{}, err
+	}
+	var image obj.Image
+	result := db.Where("url = ?", url).First(&image)
+	return image, result.Error
+}
+
+// 依條件建立圖片查詢，關鍵字比對檔名、替代文字、說明與標籤
+func imageQuery(db *gorm.DB, filter obj.ImageFilter) *gorm.DB {
+	query := db.Model(&obj.Image{})
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.Keyword != "" {
+		like := "%" + escapeLike(filter.Keyword) + "%"
+		query = query.Where("(filename LIKE ? ESCAPE '\\' OR alt LIKE ? ESCAPE '\\' OR caption LIKE ? ESCAPE '\\' OR tags LIKE ? ESCAPE '\\')",
+			like, like, like, like)
+	}
+	return query
+}
+
+// escapeLike 跳脫 LIKE 的萬用字元，讓關鍵字中的 % 與 _ 以字面比對
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
+// SearchImages 依條件取得圖片與符合條件的總數
+func SearchImages(filter obj.ImageFilter) ([]obj.Image, int64, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if err := imageQuery(db, filter).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query := imageQuery(db, filter)
+	switch filter.Sort {
+	case obj.ImageSortOldest:
+		query = query.Order("upload_time ASC, id ASC")
+	case obj.ImageSortLargest:
+		query = query.Order("size DESC, id DESC")
+	case obj.ImageSortSmallest:
+		query = query.Order("size ASC, id ASC")
+	default:
+		query = query.Order("upload_time DESC, id DESC")
+	}
+	if filter.Limit > 0 {
+		query = query.Offset(filter.Offset).Limit(filter.Limit)
+	}
+	var images []obj.Image
+	err = query.Find(&images).Error
+	return images, total, err
+}
+
+// UpdateImageMeta 更新圖片的替代文字、說明與標籤
+func UpdateImageMeta(id uint, alt, caption, tags string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.Image{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"alt":     alt,
+		"caption": caption,
+		"tags":    tags,
+	}).Error
+}
+
+// ---- 連結檢查相關功能 ----
+
+// ReplaceLinkIssues 以最新一次檢查的結果取代所有連結問題記錄
+func ReplaceLinkIssues(issues []obj.LinkIssue) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+
+	// 開始事務
+	tx := db.Begin()
+
+	// 清除舊的檢查結果
+	if err := tx.Where("1 = 1").Delete(&obj.LinkIssue{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// 寫入新的檢查結果
+	if len(issues) > 0 {
+		if err := tx.Create(&issues).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// 提交事務
+	return tx.Commit().Error
+}
+
+// GetLinkIssues 獲取所有連結問題，依文章排序
+func GetLinkIssues() ([]obj.LinkIssue, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+	var issues []obj.LinkIssue
+	result := db.Order("doc_id, id").Find(&issues)
+	return issues, result.Error
+}
+
+// ---- 內容片段相關功能 ----
+
+// GetSnippetList 獲取所有內容片段
+func GetSnippetList() ([]obj.Snippet, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+	var snippets []obj.Snippet
+	result := db.Order("name").Find(&snippets)
+	return snippets, result.Error
+}
+
+// GetSnippet 獲取特定內容片段
+func GetSnippet(id uint) (obj.Snippet, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.Snippet{}, err
+	}
+	var snippet obj.Snippet
+	result := db.First(&snippet, id)
+	return snippet, result.Error
+}
+
+// GetSnippetByName 通過名稱獲取內容片段
+func GetSnippetByName(name string) (obj.Snippet, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.Snippet{}, err
+	}
+	var snippet obj.Snippet
+	result := db.Where("name = ?", name).First(&snippet)
+	return snippet, result.Error
+}
+
+// AddSnippet 添加新內容片段
+func AddSnippet(snippet *obj.Snippet) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Create(snippet).Error
+}
+
+// UpdateSnippet 更新內容片段
+func UpdateSnippet(snippet *obj.Snippet) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.Snippet{}).Where("id = ?", snippet.ID).Updates(map[string]interface{}{
+		"name":        snippet.Name,
+		"title":       snippet.Title,
+		"content":     snippet.Content,
+		"update_time": time.Now(),
+	}).Error
+}
+
+// UpdateSnippetContent 只更新內容片段的內容，不變更更新時間
+func UpdateSnippetContent(id uint, content string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.Snippet{}).Where("id = ?", id).UpdateColumn("content", content).Error
+}
+
+// DeleteSnippet 刪除內容片段
+func DeleteSnippet(id uint) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("snippet_id = ?", id).Delete(&obj.ImageUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&obj.Snippet{}, id).Error
+	})
+}
+
+// ---- 內容變數相關功能 ----
+
+// GetVariableList 獲取所有內容變數
+func GetVariableList() ([]obj.Variable, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+	var variables []obj.Variable
+	result := db.Order("key").Find(&variables)
+	return variables, result.Error
+}
+
+// AddVariable 添加新內容變數
+func AddVariable(variable *obj.Variable) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Create(variable).Error
+}
+
+// GetVariable 根據 ID 獲取內容變數
+func GetVariable(id uint) (obj.Variable, error) {
+	db, err := DB()
+	if err != nil {
+		return obj.Variable{}, err
+	}
+	var variable obj.Variable
+	err = db.First(&variable, id).Error
+	return variable, err
+}
+
+// UpdateVariable 更新內容變數
+func UpdateVariable(id uint, key, value, description string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Model(&obj.Variable{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"key":         key,
+		"value":       value,
+		"description": description,
+		"update_time": time.Now(),
+	}).Error
+}
+
+// DeleteVariable 刪除內容變數
+func DeleteVariable(id uint) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("variable_id = ?", id).Delete(&obj.ImageUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&obj.Variable{}, id).Error
+	})
+}
+
+// ---- 全站設定 ----
+
+// GetSetting 取得設定值，尚未設定時回傳預設值
+func GetSetting(key, defaultValue string) string {
+	db, err := DB()
+	if err != nil {
+		return defaultValue
+	}
+	var setting obj.Setting
+	if err := db.Where("key = ?", key).First(&setting).Error; err != nil {
+		return defaultValue
+	}
+	return setting.Value
+}
+
+// SetSetting 保存設定值
+func SetSetting(key, value string) error {
+	db, err := DB()
+	if err != nil {
+		return err
+	}
+	return db.Save(&obj.Setting{Key: key, Value: value}).Error
+}
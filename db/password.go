@@ -0,0 +1,196 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher 密碼雜湊演算法
+type PasswordHasher interface {
+	// Name 演算法名稱，用於設定
+	Name() string
+	// Hash 將密碼加密為雜湊值
+	Hash(password string) (string, error)
+	// Match 雜湊值是否由此演算法產生
+	Match(hash string) bool
+	// Verify 比較密碼和雜湊值
+	Verify(hash, password string) bool
+	// Outdated 雜湊值的參數是否比目前的設定弱，需要重新計算
+	Outdated(hash string) bool
+}
+
+// argon2idHasher 使用 argon2id，雜湊值以 PHC 格式儲存：
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type argon2idHasher struct {
+	Memory  uint32 // 記憶體用量（KiB）
+	Time    uint32 // 迭代次數
+	Threads uint8  // 平行度
+	SaltLen int
+	KeyLen  uint32
+}
+
+func (h argon2idHasher) Name() string { return "argon2id" }
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h argon2idHasher) Match(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// decode 解析 PHC 格式的雜湊值，回傳參數、鹽與雜湊
+func (h argon2idHasher) decode(hash string) (argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return h, nil, nil, fmt.Errorf("無效的 argon2id 雜湊值")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, nil, nil, fmt.Errorf("不支援的 argon2 版本: %s", parts[2])
+	}
+	var params argon2idHasher
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return h, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return h, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return h, nil, nil, err
+	}
+	params.SaltLen = len(salt)
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func (h argon2idHasher) Verify(hash, password string) bool {
+	params, salt, key, err := h.decode(hash)
+	if err != nil || params.Time == 0 || params.Threads == 0 || len(key) == 0 {
+		return false
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	return subtle.ConstantTimeCompare(actual, key) == 1
+}
+
+func (h argon2idHasher) Outdated(hash string) bool {
+	params, _, _, err := h.decode(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < h.Memory || params.Time < h.Time || params.KeyLen < h.KeyLen || params.SaltLen < h.SaltLen
+}
+
+// bcryptMaxPasswordBytes bcrypt 能處理的密碼長度上限，超過時 bcrypt.GenerateFromPassword 會回傳錯誤
+const bcryptMaxPasswordBytes = 72
+
+// bcryptHasher 使用 bcrypt，密碼不能超過 72 個位元組
+type bcryptHasher struct {
+	Cost int
+}
+
+func (h bcryptHasher) Name() string { return "bcrypt" }
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+func (h bcryptHasher) Match(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h bcryptHasher) Verify(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (h bcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
+
+// passwordHashers 支援的雜湊演算法，驗證時依雜湊值的格式選用
+var passwordHashers = []PasswordHasher{
+	// RFC 9106 建議在記憶體有限時使用的參數
+	argon2idHasher{Memory: 64 * 1024, Time: 3, Threads: 2, SaltLen: 16, KeyLen: 32},
+	bcryptHasher{Cost: 12},
+}
+
+// passwordHasher 新密碼使用的雜湊演算法
+var passwordHasher = passwordHashers[0]
+
+// SetPasswordHasher 設定新密碼使用的雜湊演算法，應在伺服器啟動時呼叫。
+// 既有的密碼仍可驗證，並會在使用者下次登入成功時改用新的演算法
+func SetPasswordHasher(name string) error {
+	for _, hasher := range passwordHashers {
+		if hasher.Name() == name {
+			passwordHasher = hasher
+			return nil
+		}
+	}
+	return fmt.Errorf("不支援的密碼雜湊演算法: %s", name)
+}
+
+// hasherFor 依雜湊值的格式找出對應的演算法
+func hasherFor(hash string) PasswordHasher {
+	for _, hasher := range passwordHashers {
+		if hasher.Match(hash) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+// MaxPasswordBytes 目前的雜湊演算法能處理的密碼位元組上限，0 表示沒有限制
+func MaxPasswordBytes() int {
+	if _, ok := passwordHasher.(bcryptHasher); ok {
+		return bcryptMaxPasswordBytes
+	}
+	return 0
+}
+
+// hashSlots 限制同時進行的密碼雜湊計算數量。argon2id 每次使用 64 MiB 記憶體，
+// 大量同時送出的登入請求（包含不存在的帳號）會在失敗次數限制生效前耗盡記憶體，超過時排隊等待
+var hashSlots = make(chan struct{}, runtime.NumCPU())
+
+// HashPassword 將密碼加密為雜湊值
+func HashPassword(password string) (string, error) {
+	hashSlots <- struct{}{}
+	defer func() { <-hashSlots }()
+	return passwordHasher.Hash(password)
+}
+
+// VerifyPassword 比較密碼和雜湊值
+func VerifyPassword(hashedPassword, password string) bool {
+	hasher := hasherFor(hashedPassword)
+	if hasher == nil {
+		return false
+	}
+	hashSlots <- struct{}{}
+	defer func() { <-hashSlots }()
+	return hasher.Verify(hashedPassword, password)
+}
+
+// NeedsRehash 雜湊值不是使用目前的演算法或參數產生，應在驗證成功後重新計算
+func NeedsRehash(hashedPassword string) bool {
+	hasher := hasherFor(hashedPassword)
+	return hasher == nil || hasher.Name() != passwordHasher.Name() || passwordHasher.Outdated(hashedPassword)
+}
@@ -6,10 +6,13 @@ toolchain go1.24.1
 
 require (
 	github.com/HazelnutParadise/Go-Utils v0.7.10
+	github.com/HugoSmits86/nativewebp v0.9.3
 	github.com/glebarez/sqlite v1.11.0
 	github.com/yuin/goldmark v1.7.8
 )
 
+require golang.org/x/image v0.25.0
+
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/glebarez/go-sqlite v1.21.2 // indirect
@@ -20,7 +23,7 @@ require (
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/crypto v0.37.0
 	golang.org/x/sys v0.32.0 // indirect
-	gorm.io/gorm v1.25.7 // indirect
+	gorm.io/gorm v1.25.7
 	modernc.org/libc v1.22.5 // indirect
 	modernc.org/mathutil v1.5.0 // indirect
 	modernc.org/memory v1.5.0 // indirect
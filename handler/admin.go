@@ -1,15 +1,12 @@
 package handler
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
-	"os"
-	"path"
 	"strconv"
 	"strings"
 	"support/db"
@@ -45,7 +42,7 @@ func AdminLoginHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 渲染登入頁面
-		err = tmpl.Execute(w, nil)
+		err = tmpl.Execute(w, loginPageData(""))
 		if err != nil {
 			log.Println("Login template execute error:", err)
 			http.Error(w, "模板執行錯誤", http.StatusInternalServerError)
@@ -63,6 +60,11 @@ func AdminLoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !passwordLoginEnabled {
+			showLoginError(w, r, "已停用帳號密碼登入，請使用單一登入")
+			return
+		}
+
 		// 獲取表單數據
 		username := r.FormValue("username")
 		password := r.FormValue("password")
@@ -73,48 +75,34 @@ func AdminLoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// 從資料庫獲取用戶
-		user, err := db.GetUserByUsername(username)
+		// 驗證帳號密碼，連續失敗過多時會暫時鎖定
+		user, err := authenticatePassword(r, username, password)
 		if err != nil {
-			log.Println("User query error:", err)
-			showLoginError(w, r, "用戶名或密碼錯誤")
+			showLoginFailure(w, r, err)
 			return
 		}
 
-		// 驗證密碼 - 使用雜湊密碼驗證
-		if !db.VerifyPassword(user.Password, password) {
-			showLoginError(w, r, "用戶名或密碼錯誤")
+		// 已啟用兩步驟驗證的使用者需再輸入驗證碼
+		if user.TOTPEnabled {
+			err = startPendingLogin(w, user.Username)
+			if err != nil {
+				log.Println("Pending login error:", err)
+				showLoginError(w, r, "創建會話失敗")
+				return
+			}
+			http.Redirect(w, r, "/admin/login/2fa", http.StatusSeeOther)
 			return
 		}
 
-		// 驗證成功，創建會話
-		sessionID := generateSessionID()
-		expiry := time.Now().Add(AdminSessionTimeout)
-
-		// 設置會話Cookie
-		cookie := &http.Cookie{
-			Name:     AdminSessionCookieName,
-			Value:    sessionID,
-			Expires:  expiry,
-			Path:     "/",
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-		}
-		http.SetCookie(w, cookie)
-
-		// 保存會話到資料庫 (這個功能需要在db包中實現)
-		session := &obj.AdminSession{
-			SessionID: sessionID,
-			Username:  username,
-			Expiry:    expiry,
-		}
-
-		err = db.SaveAdminSession(session)
+		// 驗證成功，創建新的會話
+		_, err = startAdminSession(w, r, username)
 		if err != nil {
 			log.Println("Session save error:", err)
 			showLoginError(w, r, "創建會話失敗")
 			return
 		}
+		recordLoginAttempt(r, username, true, false, "")
+		recordAuditAs(r, username, auditEntry{Action: obj.AuditLogin})
 
 		// 重定向到儀表板
 		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
@@ -130,8 +118,11 @@ func AdminLogoutHandler(w http.ResponseWriter, r *http.Request) {
 	// 從Cookie中獲取會話ID
 	cookie, err := r.Cookie(AdminSessionCookieName)
 	if err == nil {
+		if session, err := getAdminSession(r); err == nil {
+			recordAuditAs(r, session.Username, auditEntry{Action: obj.AuditLogout})
+		}
 		// 從資料庫刪除會話
-		db.DeleteAdminSession(cookie.Value)
+		db.DeleteAdminSession(hashSessionID(cookie.Value))
 	}
 
 	// 清除Cookie
@@ -154,10 +145,18 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		// 檢查是否存在會話
 		session, err := getAdminSession(r)
 		if err != nil {
-			// 無有效會話，重定向到登入頁面
+			// 以 API token 存取的請求回應 401，瀏覽器則重定向到登入頁面
+			if bearerToken(r) != "" {
+				unauthorized(w)
+				return
+			}
 			http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
 			return
 		}
+		if session.TokenID != 0 {
+			serveAPITokenRequest(w, r, session, next)
+			return
+		}
 
 		// 檢查會話是否過期
 		if time.Now().After(session.Expiry) {
@@ -178,33 +177,89 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		// 會話有效，調用下一個處理器
+		// 會修改資料的請求必須帶有正確的 CSRF token
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !validCSRF(r) {
+			csrfFailed(w, r)
+			return
+		}
+
+		// 仍在使用預設帳號密碼時，必須先完成初始設定
+		if session.NeedSetup && r.URL.Path != "/admin/setup" && r.URL.Path != "/admin/logout" {
+			http.Redirect(w, r, "/admin/setup", http.StatusSeeOther)
+			return
+		}
+
+		// 檢查角色是否有權限存取此路由
+		if !hasPermission(session.Role, requiredPermission(r)) {
+			log.Printf("Permission denied: %s (%s) %s %s", session.Username, session.Role, r.Method, r.URL.Path)
+			forbidden(w)
+			return
+		}
+
+		// 網站要求兩步驟驗證時，尚未啟用的使用者只能前往設定頁面或登出
+		if !session.TwoFactor && require2FA() &&
+			!strings.HasPrefix(r.URL.Path, "/admin/2fa") && r.URL.Path != "/admin/logout" {
+			redirectWithMessage(w, r, "/admin/2fa", "網站要求啟用兩步驟驗證後才能使用後台", "warning")
+			return
+		}
+
+		// 會話有效，記錄最後使用時間後調用下一個處理器
+		touchAdminSession(session)
 		next(w, r)
 	}
 }
 
 // 從請求中獲取管理員會話
 func getAdminSession(r *http.Request) (*obj.AdminSession, error) {
-	// 從Cookie中獲取會話ID
-	cookie, err := r.Cookie(AdminSessionCookieName)
-	if err != nil {
-		return nil, err
+	var session *obj.AdminSession
+	if token := bearerToken(r); token != "" {
+		// 帶有 API token 的請求一律以 token 驗證，不再檢查 Cookie
+		var err error
+		session, err = apiTokenSession(token)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// 從Cookie中獲取會話ID
+		cookie, err := r.Cookie(AdminSessionCookieName)
+		if err != nil {
+			return nil, err
+		}
+
+		// 資料庫中僅保存會話ID的雜湊值
+		session, err = db.GetAdminSession(hashSessionID(cookie.Value))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// 從資料庫獲取會話
-	sessionID := cookie.Value
-	session, err := db.GetAdminSession(sessionID)
+	// 帶入使用者角色，已停用或已刪除的使用者視為未登入
+	user, err := db.GetUserByUsername(session.Username)
 	if err != nil {
 		return nil, err
 	}
+	if user.Disabled {
+		return nil, errors.New("user disabled")
+	}
+	session.Role = user.Role
+	session.TwoFactor = user.TOTPEnabled
+	session.NeedSetup = user.MustChangePassword
+	session.SSO = user.IsSSO()
 
 	return session, nil
 }
 
-// 生成唯一的會話ID
-func generateSessionID() string {
-	// 生成當前時間的納秒級時間戳，轉換為字符串
-	return strconv.FormatInt(time.Now().UnixNano(), 36)
+// loginPageData 登入頁面的模板資料
+func loginPageData(errorMessage string) map[string]interface{} {
+	data := map[string]interface{}{
+		"ErrorMessage":   errorMessage,
+		"PasswordLogin":  passwordLoginEnabled,
+		"ForgotPassword": passwordResetAvailable(),
+	}
+	if oidcEnabled() {
+		data["SSOName"] = oidcConfig.DisplayName
+	}
+	return data
 }
 
 // 顯示登入錯誤
@@ -217,11 +272,7 @@ func showLoginError(w http.ResponseWriter, r *http.Request, errorMessage string)
 	}
 
 	// 渲染登入頁面，並顯示錯誤訊息
-	data := map[string]interface{}{
-		"ErrorMessage": errorMessage,
-	}
-
-	err = tmpl.Execute(w, data)
+	err = tmpl.Execute(w, loginPageData(errorMessage))
 	if err != nil {
 		log.Println("Login template execute error:", err)
 		http.Error(w, "模板執行錯誤", http.StatusInternalServerError)
@@ -280,6 +331,7 @@ func AdminDashboardHandler(w http.ResponseWriter, r *http.Request) {
 		"CategoryCount": len(categories),
 		"DocCount":      len(docs),
 		"Username":      session.Username,
+		"CSRFToken":     csrfToken(r),
 	}
 
 	// 解析模板
@@ -332,6 +384,7 @@ func AdminCategoriesHandler(w http.ResponseWriter, r *http.Request) {
 		"Message":     message,
 		"MessageType": messageType,
 		"Username":    session.Username,
+		"CSRFToken":   csrfToken(r),
 	}
 
 	// 解析模板
@@ -390,6 +443,13 @@ func AdminCategoryAddHandler(w http.ResponseWriter, r *http.Request) {
 		redirectWithMessage(w, r, "/admin/categories", "新增分類失敗: "+err.Error(), "danger")
 		return
 	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditCategoryCreate,
+		TargetType: "category",
+		TargetID:   category.ID,
+		TargetName: name,
+		After:      "名稱：" + name,
+	})
 
 	// 重定向回分類列表，並帶上成功訊息
 	redirectWithMessage(w, r, "/admin/categories", "成功新增分類: "+name, "success")
@@ -426,6 +486,9 @@ func AdminCategoryEditHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 記錄變更前的名稱
+	oldName := categoryName(uint(id))
+
 	// 更新分類
 	err = db.UpdateCategory(uint(id), name)
 	if err != nil {
@@ -433,6 +496,14 @@ func AdminCategoryEditHandler(w http.ResponseWriter, r *http.Request) {
 		redirectWithMessage(w, r, "/admin/categories", "更新分類失敗: "+err.Error(), "danger")
 		return
 	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditCategoryUpdate,
+		TargetType: "category",
+		TargetID:   uint(id),
+		TargetName: name,
+		Before:     "名稱：" + oldName,
+		After:      "名稱：" + name,
+	})
 
 	// 重定向回分類列表，並帶上成功訊息
 	redirectWithMessage(w, r, "/admin/categories", "成功更新分類", "success")
@@ -462,6 +533,14 @@ func AdminCategoryDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 記錄刪除前的分類與文件
+	oldName := categoryName(uint(id))
+	docs, _ := db.GetDocsByCategory(uint(id))
+	titles := make([]string, len(docs))
+	for i, doc := range docs {
+		titles[i] = doc.Title
+	}
+
 	// 刪除分類及其下的所有文檔
 	err = db.DeleteCategory(uint(id))
 	if err != nil {
@@ -469,6 +548,13 @@ func AdminCategoryDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		redirectWithMessage(w, r, "/admin/categories", "刪除分類失敗: "+err.Error(), "danger")
 		return
 	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditCategoryDelete,
+		TargetType: "category",
+		TargetID:   uint(id),
+		TargetName: oldName,
+		Before:     fmt.Sprintf("名稱：%s；文件 %d 篇 %s", oldName, len(docs), strings.Join(titles, "、")),
+	})
 
 	// 重定向回分類列表，並帶上成功訊息
 	redirectWithMessage(w, r, "/admin/categories", "成功刪除分類及其文檔", "success")
@@ -556,6 +642,7 @@ func AdminDocsHandler(w http.ResponseWriter, r *http.Request) {
 		"Message":          message,
 		"MessageType":      messageType,
 		"Username":         session.Username,
+		"CSRFToken":        csrfToken(r),
 		"Today":            today,
 		"Filter":           filter,
 		"FilterCategoryID": categoryID,
@@ -597,7 +684,11 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 	var isNewDoc bool = false
 
 	if docIDStr == "" || docIDStr == "0" {
-		// 新增文件的情況
+		// 新增文件的情況，審稿角色只能編輯既有文件
+		if !hasPermission(session.Role, permManageDocs) {
+			forbidden(w)
+			return
+		}
 		isNewDoc = true
 		doc = obj.Doc{
 			PublishDate: func() obj.DateField {
@@ -605,7 +696,9 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 				dateField.FromTime(time.Now())
 				return dateField
 			}(),
-			IsDraft: true, // 默認為草稿
+			IsDraft:  true, // 默認為草稿
+			ShowTOC:  true,
+			TOCDepth: obj.DefaultTOCDepth,
 		}
 	} else {
 		// 編輯現有文件的情況
@@ -644,6 +737,8 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 		publishDateStr := r.PostForm.Get("publish_date")
 		content := r.PostForm.Get("content")
 		isDraftStr := r.PostForm.Get("is_draft")
+		showTOCStr := r.PostForm.Get("show_toc")
+		tocDepthStr := r.PostForm.Get("toc_depth")
 
 		// 驗證必填欄位
 		if title == "" || categoryIDStr == "" || content == "" {
@@ -665,13 +760,16 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 
 			// 準備錯誤訊息
 			data := map[string]interface{}{
-				"Active":     "docs",
-				"Doc":        doc,
-				"IsNewDoc":   isNewDoc,
-				"DocContent": doc.Content,
-				"Categories": categories,
-				"Error":      "請填寫所有必填欄位",
-				"Username":   session.Username,
+				"Active":        "docs",
+				"Doc":           doc,
+				"IsNewDoc":      isNewDoc,
+				"DocContent":    doc.Content,
+				"Categories":    categories,
+				"Error":         "請填寫所有必填欄位",
+				"Username":      session.Username,
+				"CSRFToken":     csrfToken(r),
+				"UndefinedVars": undefinedVariables(doc.Content),
+				"VariableKeys":  variableKeys(),
 			}
 
 			// 渲染模板
@@ -709,6 +807,13 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 		// URL 編碼文件內容
 		encodedContent := url.QueryEscape(content)
 
+		// 記錄變更前的狀態
+		before := ""
+		wasDraft := doc.IsDraft
+		if !isNewDoc {
+			before = docAuditSummary(doc, doc.Content)
+		}
+
 		// 更新文件對象
 		doc.Title = title
 		doc.CategoryID = uint(categoryID)
@@ -720,6 +825,8 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		doc.Content = encodedContent
 		doc.IsDraft = isDraft
+		doc.ShowTOC = showTOCStr == "true"
+		doc.TOCDepth = parseTOCDepth(tocDepthStr)
 
 		// 保存到數據庫
 		if isNewDoc {
@@ -730,6 +837,14 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, "/admin/docs?message=文件創建失敗&type=danger", http.StatusSeeOther)
 				return
 			}
+			updateDocImageUsage(doc.ID, content)
+			recordAudit(r, auditEntry{
+				Action:     obj.AuditDocCreate,
+				TargetType: "doc",
+				TargetID:   doc.ID,
+				TargetName: doc.Title,
+				After:      docAuditSummary(doc, content),
+			})
 			http.Redirect(w, r, "/admin/docs?message=文件創建成功&type=success", http.StatusSeeOther)
 		} else {
 			// 更新現有文件
@@ -739,6 +854,16 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, "/admin/docs?message=文件更新失敗&type=danger", http.StatusSeeOther)
 				return
 			}
+			invalidateDocRender(doc.ID)
+			updateDocImageUsage(doc.ID, content)
+			recordAudit(r, auditEntry{
+				Action:     docUpdateAction(wasDraft, isDraft),
+				TargetType: "doc",
+				TargetID:   doc.ID,
+				TargetName: doc.Title,
+				Before:     before,
+				After:      docAuditSummary(doc, content),
+			})
 			http.Redirect(w, r, "/admin/docs?message=文件更新成功&type=success", http.StatusSeeOther)
 		}
 		return
@@ -753,12 +878,15 @@ func AdminDocEditHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 準備模板數據
 	data := map[string]interface{}{
-		"Active":     "docs",
-		"Doc":        doc,
-		"DocContent": doc.Content, // 添加 DocContent 變數以符合模板中的引用方式
-		"IsNewDoc":   isNewDoc,
-		"Categories": categories,
-		"Username":   session.Username,
+		"Active":        "docs",
+		"Doc":           doc,
+		"DocContent":    doc.Content, // 添加 DocContent 變數以符合模板中的引用方式
+		"IsNewDoc":      isNewDoc,
+		"Categories":    categories,
+		"Username":      session.Username,
+		"CSRFToken":     csrfToken(r),
+		"UndefinedVars": undefinedVariables(doc.Content), // 提示文章中未定義的內容變數
+		"VariableKeys":  variableKeys(),
 	}
 
 	// 解析模板
@@ -802,6 +930,8 @@ func AdminDocAddHandler(w http.ResponseWriter, r *http.Request) {
 	publishDateStr := r.FormValue("publish_date")
 	content := r.FormValue("content")
 	isDraftStr := r.FormValue("is_draft")
+	showTOCStr := r.FormValue("show_toc")
+	tocDepthStr := r.FormValue("toc_depth")
 
 	if title == "" || categoryIDStr == "" || content == "" {
 		redirectWithMessage(w, r, "/admin/docs", "標題、分類和內容不能為空", "danger")
@@ -848,6 +978,8 @@ func AdminDocAddHandler(w http.ResponseWriter, r *http.Request) {
 		LastEditDate: time.Now(),
 		CategoryID:   uint(categoryID),
 		IsDraft:      isDraft,
+		ShowTOC:      showTOCStr == "true",
+		TOCDepth:     parseTOCDepth(tocDepthStr),
 	}
 
 	// 保存到資料庫
@@ -857,6 +989,14 @@ func AdminDocAddHandler(w http.ResponseWriter, r *http.Request) {
 		redirectWithMessage(w, r, "/admin/docs", "新增文件失敗: "+err.Error(), "danger")
 		return
 	}
+	updateDocImageUsage(doc.ID, content)
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditDocCreate,
+		TargetType: "doc",
+		TargetID:   doc.ID,
+		TargetName: title,
+		After:      docAuditSummary(doc, content),
+	})
 
 	// 顯示成功消息，根據是否為草稿顯示不同內容
 	var successMsg string
@@ -892,6 +1032,8 @@ func AdminDocUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	publishDateStr := r.FormValue("publish_date")
 	content := r.FormValue("content")
 	isDraftStr := r.FormValue("is_draft")
+	showTOCStr := r.FormValue("show_toc")
+	tocDepthStr := r.FormValue("toc_depth")
 
 	if idStr == "" || title == "" || categoryIDStr == "" || content == "" {
 		redirectWithMessage(w, r, "/admin/docs", "必填欄位不能為空", "danger")
@@ -966,6 +1108,8 @@ func AdminDocUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		LastEditDate: time.Now(),
 		CategoryID:   uint(categoryID),
 		IsDraft:      isDraft,
+		ShowTOC:      showTOCStr == "true",
+		TOCDepth:     parseTOCDepth(tocDepthStr),
 	}
 
 	err = db.UpdateDoc(&doc)
@@ -974,6 +1118,16 @@ func AdminDocUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		redirectWithMessage(w, r, "/admin/docs/edit?id="+idStr, "更新文件失敗: "+err.Error(), "danger")
 		return
 	}
+	invalidateDocRender(doc.ID)
+	updateDocImageUsage(doc.ID, content)
+	recordAudit(r, auditEntry{
+		Action:     docUpdateAction(oldDoc.IsDraft, isDraft),
+		TargetType: "doc",
+		TargetID:   doc.ID,
+		TargetName: title,
+		Before:     docAuditSummary(oldDoc, decodedDocContent(oldDoc)),
+		After:      docAuditSummary(doc, content),
+	})
 
 	// 顯示成功消息，根據是否為草稿顯示不同內容
 	var successMsg string
@@ -996,6 +1150,15 @@ func AdminDocUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	redirectWithMessage(w, r, redirectURL, successMsg, "success")
 }
 
+// 解析目錄深度，超出 1-6 範圍時使用預設值
+func parseTOCDepth(s string) int {
+	depth, err := strconv.Atoi(s)
+	if err != nil || depth < 1 || depth > 6 {
+		return obj.DefaultTOCDepth
+	}
+	return depth
+}
+
 // AdminDocDeleteHandler 處理刪除文件
 func AdminDocDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -1020,6 +1183,14 @@ func AdminDocDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 記錄刪除前的文件
+	oldDoc, err := db.GetDoc(uint(id))
+	if err != nil {
+		log.Println("Error fetching doc:", err)
+		redirectWithMessage(w, r, "/admin/docs", "找不到文件", "danger")
+		return
+	}
+
 	// 刪除文件
 	err = db.DeleteDoc(uint(id))
 	if err != nil {
@@ -1027,6 +1198,14 @@ func AdminDocDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		redirectWithMessage(w, r, "/admin/docs", "刪除文件失敗: "+err.Error(), "danger")
 		return
 	}
+	invalidateDocRender(uint(id))
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditDocDelete,
+		TargetType: "doc",
+		TargetID:   oldDoc.ID,
+		TargetName: oldDoc.Title,
+		Before:     docAuditSummary(oldDoc, decodedDocContent(oldDoc)),
+	})
 
 	// 重定向回文件列表，並帶上成功訊息
 	redirectWithMessage(w, r, "/admin/docs", "文件已成功刪除", "success")
@@ -1041,6 +1220,12 @@ func AdminChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 單一登入帳號沒有本機密碼
+	if session.SSO {
+		redirectWithMessage(w, r, "/admin/dashboard", "單一登入帳號的密碼由身分提供者管理", "info")
+		return
+	}
+
 	// 如果是GET請求，顯示密碼修改頁面
 	if r.Method == http.MethodGet {
 		// 檢查是否有訊息要顯示
@@ -1051,9 +1236,13 @@ func AdminChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 準備模板資料
+		policy := getPasswordPolicy()
 		data := map[string]interface{}{
 			"Active":      "change_password",
 			"Username":    session.Username,
+			"CSRFToken":   csrfToken(r),
+			"MinLength":   policy.MinLength,
+			"PolicyText":  policy.Description(),
 			"Message":     message,
 			"MessageType": messageType,
 		}
@@ -1106,9 +1295,15 @@ func AdminChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// 檢查新密碼長度
-		if len(newPassword) < 6 {
-			redirectWithMessage(w, r, "/admin/change-password", "新密碼長度必須至少為6個字符", "danger")
+		// 檢查新密碼是否符合密碼規則
+		user, err := db.GetUserByUsername(session.Username)
+		if err != nil {
+			log.Println("User query error:", err)
+			redirectWithMessage(w, r, "/admin/change-password", "找不到使用者", "danger")
+			return
+		}
+		if reason := checkNewPassword(user, newPassword); reason != "" {
+			redirectWithMessage(w, r, "/admin/change-password", reason, "danger")
 			return
 		}
 
@@ -1119,6 +1314,19 @@ func AdminChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 			redirectWithMessage(w, r, "/admin/change-password", "密碼修改失敗: "+err.Error(), "danger")
 			return
 		}
+		recordAudit(r, auditEntry{Action: obj.AuditPasswordChange, TargetType: "user", TargetName: session.Username})
+
+		// 密碼變更後更換會話ID，並登出其他所有裝置
+		newSession, err := startAdminSession(w, r, session.Username)
+		if err != nil {
+			log.Println("Session rotate error:", err)
+			redirectWithMessage(w, r, "/admin/login", "密碼已修改，請重新登入", "success")
+			return
+		}
+		err = db.DeleteOtherAdminSessions(session.Username, newSession.SessionID)
+		if err != nil {
+			log.Println("Error revoking sessions:", err)
+		}
 
 		// 重定向到儀表板，並顯示成功訊息
 		redirectWithMessage(w, r, "/admin/dashboard", "密碼已成功修改", "success")
@@ -1141,11 +1349,13 @@ func AdminImagesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 獲取所有圖片
-	images, err := db.GetAllImages()
+	// 依搜尋條件取得目前頁面的圖片
+	filter, page := parseImageFilter(r, imagePageSize)
+	images, total, err := db.SearchImages(filter)
 	if err != nil {
 		log.Println("Error fetching images:", err)
 	}
+	totalPages := int((total + imagePageSize - 1) / imagePageSize)
 
 	// 檢查是否有訊息要顯示
 	message := r.URL.Query().Get("message")
@@ -1154,12 +1364,34 @@ func AdminImagesHandler(w http.ResponseWriter, r *http.Request) {
 		messageType = "info" // 預設訊息類型
 	}
 
+	// 圖片被引用的次數
+	usage, err := db.GetImageUsageCounts()
+	if err != nil {
+		log.Println("Error fetching image usage:", err)
+	}
+	orphans, err := unusedImages()
+	if err != nil {
+		log.Println("Error fetching unused images:", err)
+	}
+
 	// 準備模板資料
 	data := obj.ImageListData{
-		Images:   images,
-		Message:  message,
-		MsgType:  messageType,
-		Username: session.Username,
+		Images:     images,
+		Usage:      usage,
+		Orphans:    len(orphans),
+		Query:      filter.Keyword,
+		Sort:       filter.Sort,
+		Sorts:      obj.ImageSorts,
+		Kind:       filter.Kind,
+		Kinds:      obj.ImageKinds,
+		Total:      total,
+		Page:       page,
+		TotalPages: totalPages,
+		ReturnURL:  imageListURL(filter, page),
+		Message:    message,
+		MsgType:    messageType,
+		Username:   session.Username,
+		CSRFToken:  csrfToken(r),
 	}
 
 	// 創建自定義模板函數
@@ -1167,6 +1399,13 @@ func AdminImagesHandler(w http.ResponseWriter, r *http.Request) {
 		"divideSize": func(size int64) float64 {
 			return float64(size) / 1024.0 // 轉換為KB
 		},
+		"pageURL": func(page int) string {
+			return imageListURL(filter, page)
+		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"kindLabel": imageKindLabel,
 	}
 
 	// 解析模板
@@ -1204,121 +1443,45 @@ func AdminImageUploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 限制請求大小，保留一些空間給表單的其他欄位。各格式的限制在判斷格式後檢查
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+1<<20)
+
 	// 解析表單，限制內存使用為 32MB，超過會存到臨時文件
 	err = r.ParseMultipartForm(32 << 20)
 	if err != nil {
 		log.Println("Parse form error:", err)
-		http.Error(w, "表單解析錯誤", http.StatusBadRequest)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			imageUploadError(w, r, newUploadError(uploadErrTooLarge, "檔案不能超過 "+sizeLabel(maxUploadSize)))
+			return
+		}
+		imageUploadError(w, r, newUploadError(uploadErrBadRequest, "表單解析錯誤"))
 		return
 	}
 
-	// 獲取上傳的文件
+	// 獲取上傳的文件，附件也接受 file 欄位
 	file, header, err := r.FormFile("image")
+	if errors.Is(err, http.ErrMissingFile) {
+		file, header, err = r.FormFile("file")
+	}
 	if err != nil {
 		log.Println("Getting form file error:", err)
-		http.Error(w, "獲取文件失敗", http.StatusBadRequest)
+		imageUploadError(w, r, newUploadError(uploadErrBadRequest, "獲取文件失敗"))
 		return
 	}
 	defer file.Close()
 
-	// 檢查文件類型
-	contentType := header.Header.Get("Content-Type")
-	if !strings.HasPrefix(contentType, "image/") {
-		http.Error(w, "僅支持圖片文件", http.StatusBadRequest)
-		return
-	}
-
-	// 獲取文件擴展名
-	extension := ""
-	if strings.HasSuffix(header.Filename, ".jpg") || strings.HasSuffix(header.Filename, ".jpeg") {
-		extension = ".jpg"
-	} else if strings.HasSuffix(header.Filename, ".png") {
-		extension = ".png"
-	} else if strings.HasSuffix(header.Filename, ".gif") {
-		extension = ".gif"
-	} else if strings.HasSuffix(header.Filename, ".webp") {
-		extension = ".webp"
-	} else {
-		// 根據 MIME 類型推斷擴展名
-		switch contentType {
-		case "image/jpeg":
-			extension = ".jpg"
-		case "image/png":
-			extension = ".png"
-		case "image/gif":
-			extension = ".gif"
-		case "image/webp":
-			extension = ".webp"
-		default:
-			extension = ".jpg" // 默認 jpg
-		}
-	}
-
-	// 生成唯一文件名
-	filename := generateUniqueFilename() + extension
-
-	// 確保上傳目錄存在
-	err = db.EnsureUploadDir()
-	if err != nil {
-		log.Println("Error creating upload directory:", err)
-		http.Error(w, "創建上傳目錄失敗", http.StatusInternalServerError)
-		return
-	}
-
-	// 創建文件路徑 (data/uploads/filename) - 實際存儲位置
-	filePath := path.Join(db.UploadStoragePath, filename)
-	dst, err := os.Create(filePath)
-	if err != nil {
-		log.Println("File creation error:", err)
-		http.Error(w, "創建文件失敗", http.StatusInternalServerError)
-		return
-	}
-	defer dst.Close()
-
-	// 複製上傳的文件內容到新文件
-	written, err := io.Copy(dst, file)
-	if err != nil {
-		log.Println("File copy error:", err)
-		http.Error(w, "保存文件失敗", http.StatusInternalServerError)
-		return
-	}
-
-	// 生成圖片 URL (相對路徑) - 為了與前端兼容，使用 /uploads/ 作為URL前綴
-	imageURL := db.UploadURLPath + filename
-
-	// 創建圖片記錄
-	image := obj.Image{
-		Filename:    header.Filename,
-		Path:        filePath,
-		URL:         imageURL,
-		Size:        written,
-		ContentType: contentType,
-		UploadTime:  time.Now(),
-	}
-
-	// 保存到資料庫
-	err = db.AddImage(&image)
-	if err != nil {
-		log.Println("Image DB save error:", err)
-		http.Error(w, "保存圖片記錄失敗", http.StatusInternalServerError)
+	// 編輯器上傳時可一併填寫替代文字與說明
+	alt := strings.TrimSpace(r.FormValue("alt"))
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	image, duplicate, uploadErr := storeUpload(r, file, header.Filename, header.Size, alt, caption)
+	if uploadErr != nil {
+		imageUploadError(w, r, uploadErr)
 		return
 	}
 
-	// 根據請求來源返回不同的響應
-	source := r.FormValue("source")
-	if source == "editor" {
-		// 從編輯器上傳，返回 JSON 響應供編輯器使用
-		w.Header().Set("Content-Type", "application/json")
-		jsonResponse := map[string]interface{}{
-			"success": true,
-			"url":     imageURL,
-			"id":      image.ID,
-		}
-		json.NewEncoder(w).Encode(jsonResponse)
-	} else {
-		// 從圖片管理頁面上傳，重定向回圖片列表
-		redirectWithMessage(w, r, "/admin/images", "圖片上傳成功", "success")
-	}
+	// 根據請求來源返回不同的響應：編輯器使用 JSON，圖片管理頁面重定向回圖片列表
+	writeUploadResult(w, r, image, alt, caption, duplicate)
 }
 
 // AdminImageDeleteHandler 處理圖片刪除
@@ -1336,12 +1499,35 @@ func AdminImageDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	returnURL := imagesReturnURL(r)
+
 	// 獲取圖片 ID
 	idStr := r.FormValue("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
 		log.Println("Invalid image ID:", err)
-		redirectWithMessage(w, r, "/admin/images", "無效的圖片ID", "danger")
+		redirectWithMessage(w, r, returnURL, "無效的圖片ID", "danger")
+		return
+	}
+
+	// 記錄刪除前的圖片
+	image, err := db.GetImage(uint(id))
+	if err != nil {
+		log.Println("Error fetching image:", err)
+		redirectWithMessage(w, r, returnURL, "找不到圖片", "danger")
+		return
+	}
+
+	// 仍被引用的圖片不能刪除，避免文章出現失效的圖片
+	docs, snippets, variables, err := db.GetImageUsers(image.ID)
+	if err != nil {
+		log.Println("Error fetching image usage:", err)
+		redirectWithMessage(w, r, returnURL, "無法檢查圖片使用狀況", "danger")
+		return
+	}
+	if len(docs) > 0 || len(snippets) > 0 || len(variables) > 0 {
+		redirectWithMessage(w, r, returnURL,
+			"圖片仍被"+imageUsersDescription(docs, snippets, variables)+"使用，請先移除引用再刪除", "danger")
 		return
 	}
 
@@ -1349,12 +1535,21 @@ func AdminImageDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	err = db.DeleteImage(uint(id))
 	if err != nil {
 		log.Println("Error deleting image:", err)
-		redirectWithMessage(w, r, "/admin/images", "刪除圖片失敗: "+err.Error(), "danger")
+		redirectWithMessage(w, r, returnURL, "刪除圖片失敗: "+err.Error(), "danger")
 		return
 	}
+	// 引用這張圖片的文章不再提供其他寬度的版本
+	invalidateAllRenders()
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditImageDelete,
+		TargetType: "image",
+		TargetID:   image.ID,
+		TargetName: image.Filename,
+		Before:     imageAuditSummary(image),
+	})
 
 	// 重定向回圖片列表，並帶上成功訊息
-	redirectWithMessage(w, r, "/admin/images", "圖片已成功刪除", "success")
+	redirectWithMessage(w, r, returnURL, "圖片已成功刪除", "success")
 }
 
 // 生成唯一的文件名
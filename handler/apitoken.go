@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+	"time"
+)
+
+const (
+	apiTokenPrefix     = "spt_" // token 的固定開頭，方便在程式碼與日誌中辨識外洩的 token
+	apiTokenShownChars = 12     // 列表中顯示的 token 開頭字元數
+	apiTokenMaxNameLen = 100
+)
+
+// API token 可選的有效期限（天），0 表示永不過期
+var apiTokenExpiryDays = []int{7, 30, 90, 365, 0}
+
+// 各路由允許的 API token 授權範圍，Read 用於 GET 與 HEAD 請求，Write 用於其他請求。
+// 未列出的路由（帳號、使用者、設定等）只能透過瀏覽器登入存取。
+var routeScopes = map[string]struct{ Read, Write string }{
+	"/admin/categories":             {obj.ScopeDocsRead, ""},
+	"/admin/docs":                   {obj.ScopeDocsRead, ""},
+	"/admin/docs/preview":           {obj.ScopeDocsRead, obj.ScopeDocsRead},
+	"/admin/docs/edit":              {obj.ScopeDocsRead, obj.ScopeDocsWrite},
+	"/admin/docs/add":               {"", obj.ScopeDocsWrite},
+	"/admin/docs/update":            {"", obj.ScopeDocsWrite},
+	"/admin/docs/delete":            {"", obj.ScopeDocsWrite},
+	"/admin/images":                 {obj.ScopeImagesRead, ""},
+	"/admin/images/upload":          {"", obj.ScopeImagesWrite},
+	"/admin/images/delete":          {"", obj.ScopeImagesWrite},
+	"/admin/images/cleanup":         {"", obj.ScopeImagesWrite},
+	"/admin/images/meta":            {"", obj.ScopeImagesWrite},
+	"/admin/images/library":         {obj.ScopeImagesRead, ""},
+	"/admin/images/batch":           {"", obj.ScopeImagesWrite},
+	"/admin/images/chunks":          {obj.ScopeImagesWrite, obj.ScopeImagesWrite}, // GET 查詢上傳進度，屬於上傳流程的一部分
+	"/admin/images/chunks/start":    {"", obj.ScopeImagesWrite},
+	"/admin/images/chunks/complete": {"", obj.ScopeImagesWrite},
+}
+
+var errInvalidAPIToken = errors.New("invalid api token")
+
+// bearerToken 取得 Authorization 標頭中的 Bearer token
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// apiTokenSession 以 API token 建立會話，token 不存在或已過期時回傳錯誤
+func apiTokenSession(raw string) (*obj.AdminSession, error) {
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		return nil, errInvalidAPIToken
+	}
+	token, err := db.GetAPITokenByHash(hashSessionID(raw))
+	if err != nil {
+		return nil, errInvalidAPIToken
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, errInvalidAPIToken
+	}
+
+	session := &obj.AdminSession{
+		Username:  token.Username,
+		TokenID:   token.ID,
+		Scopes:    strings.Fields(token.Scopes),
+		IPAddress: token.LastUsedIP,
+		CreatedAt: token.CreateTime,
+	}
+	if token.ExpiresAt != nil {
+		session.Expiry = *token.ExpiresAt
+	}
+	if token.LastUsedAt != nil {
+		session.LastSeen = *token.LastUsedAt
+	}
+	return session, nil
+}
+
+// tokenScopeAllowed 檢查 API token 的授權範圍是否涵蓋此請求
+func tokenScopeAllowed(session *obj.AdminSession, r *http.Request) bool {
+	rule, ok := routeScopes[r.URL.Path]
+	if !ok {
+		return false
+	}
+	scope := rule.Write
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		scope = rule.Read
+	}
+	return scope != "" && slices.Contains(session.Scopes, scope)
+}
+
+// touchAPIToken 更新 API token 的最後使用時間，與會話相同避免每個請求都寫入資料庫
+func touchAPIToken(session *obj.AdminSession, r *http.Request) {
+	now := time.Now()
+	if now.Sub(session.LastSeen) < sessionTouchInterval && session.IPAddress == clientIP(r) {
+		return
+	}
+	err := db.TouchAPIToken(session.TokenID, now, clientIP(r))
+	if err != nil {
+		log.Println("API token touch error:", err)
+	}
+}
+
+// unauthorized 回應 API token 驗證失敗
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+	http.Error(w, "API token 無效或已過期", http.StatusUnauthorized)
+}
+
+// serveAPITokenRequest 處理以 API token 驗證的請求。
+// token 不使用 Cookie，因此不需要 CSRF 檢查；網站要求兩步驟驗證時，尚未啟用的使用者的 token 一律拒絕，
+// 包含在開啟此設定前建立的 token
+func serveAPITokenRequest(w http.ResponseWriter, r *http.Request, session *obj.AdminSession, next http.HandlerFunc) {
+	if session.NeedSetup || (!session.TwoFactor && require2FA()) ||
+		!tokenScopeAllowed(session, r) || !hasPermission(session.Role, requiredPermission(r)) {
+		log.Printf("API token denied: %s (token #%d) %s %s", session.Username, session.TokenID, r.Method, r.URL.Path)
+		forbidden(w)
+		return
+	}
+	touchAPIToken(session, r)
+	next(w, r)
+}
+
+// apiTokenScopeLabel 授權範圍的說明
+func apiTokenScopeLabel(scope string) string {
+	for _, s := range obj.Scopes {
+		if s.Scope == scope {
+			return s.Description
+		}
+	}
+	return scope
+}
+
+// AdminAPITokensHandler 處理個人 API token 管理頁面
+func AdminAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	// 獲取當前的管理員會話
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	tokens, err := db.GetUserAPITokens(session.Username)
+	if err != nil {
+		log.Println("Error fetching API tokens:", err)
+	}
+
+	// 檢查是否有訊息要顯示
+	message := r.URL.Query().Get("message")
+	messageType := r.URL.Query().Get("type")
+	if messageType == "" && message != "" {
+		messageType = "success" // 預設訊息類型
+	}
+
+	renderAPITokensPage(w, r, map[string]interface{}{
+		"Tokens":      tokens,
+		"Message":     message,
+		"MessageType": messageType,
+		"Username":    session.Username,
+	})
+}
+
+// renderAPITokensPage 渲染 API token 管理頁面
+func renderAPITokensPage(w http.ResponseWriter, r *http.Request, data map[string]interface{}) {
+	data["Active"] = "tokens"
+	data["Scopes"] = obj.Scopes
+	data["ExpiryDays"] = apiTokenExpiryDays
+	data["Now"] = time.Now()
+	data["CSRFToken"] = csrfToken(r)
+
+	// 解析模板
+	tmpl, err := template.New("layout.html").Funcs(template.FuncMap{
+		"scopeLabel":  apiTokenScopeLabel,
+		"splitScopes": strings.Fields,
+	}).ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/api_tokens.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminAPITokenCreateHandler 處理建立 API token，完整的 token 只在建立後顯示一次
+func AdminAPITokenCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 解析表單
+	err = r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/tokens", "表單解析錯誤", "danger")
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len([]rune(name)) > apiTokenMaxNameLen {
+		redirectWithMessage(w, r, "/admin/tokens", "請輸入 100 字以內的名稱", "danger")
+		return
+	}
+
+	var scopes []string
+	for _, s := range obj.Scopes {
+		if slices.Contains(r.Form["scopes"], s.Scope) {
+			scopes = append(scopes, s.Scope)
+		}
+	}
+	if len(scopes) == 0 {
+		redirectWithMessage(w, r, "/admin/tokens", "請至少選擇一個授權範圍", "danger")
+		return
+	}
+
+	days, err := strconv.Atoi(r.FormValue("expiry_days"))
+	if err != nil || !slices.Contains(apiTokenExpiryDays, days) {
+		redirectWithMessage(w, r, "/admin/tokens", "無效的有效期限", "danger")
+		return
+	}
+
+	secret, err := generateSessionID()
+	if err != nil {
+		log.Println("Error generating API token:", err)
+		redirectWithMessage(w, r, "/admin/tokens", "建立 API token 失敗", "danger")
+		return
+	}
+	raw := apiTokenPrefix + secret
+
+	token := obj.APIToken{
+		Username:  session.Username,
+		Name:      name,
+		TokenHash: hashSessionID(raw),
+		Prefix:    raw[:apiTokenShownChars],
+		Scopes:    strings.Join(scopes, " "),
+	}
+	if days > 0 {
+		expiry := time.Now().AddDate(0, 0, days)
+		token.ExpiresAt = &expiry
+	}
+
+	err = db.AddAPIToken(&token)
+	if err != nil {
+		log.Println("Error adding API token:", err)
+		redirectWithMessage(w, r, "/admin/tokens", "建立 API token 失敗: "+err.Error(), "danger")
+		return
+	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditTokenCreate,
+		TargetType: "api_token",
+		TargetID:   token.ID,
+		TargetName: token.Name,
+		After:      apiTokenAuditSummary(token),
+	})
+
+	tokens, err := db.GetUserAPITokens(session.Username)
+	if err != nil {
+		log.Println("Error fetching API tokens:", err)
+	}
+	renderAPITokensPage(w, r, map[string]interface{}{
+		"Tokens":      tokens,
+		"NewToken":    raw,
+		"Message":     "已建立 API token「" + token.Name + "」，請立即複製保存，離開此頁面後將無法再次查看",
+		"MessageType": "success",
+		"Username":    session.Username,
+	})
+}
+
+// AdminAPITokenRevokeHandler 處理撤銷 API token
+func AdminAPITokenRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 獲取 token ID
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 32)
+	if err != nil {
+		log.Println("Invalid API token ID:", err)
+		redirectWithMessage(w, r, "/admin/tokens", "無效的 token ID", "danger")
+		return
+	}
+
+	// 只能撤銷自己的 token
+	token, err := db.DeleteUserAPIToken(session.Username, uint(id))
+	if err != nil {
+		log.Println("Error revoking API token:", err)
+		redirectWithMessage(w, r, "/admin/tokens", "找不到該 API token", "danger")
+		return
+	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditTokenRevoke,
+		TargetType: "api_token",
+		TargetID:   token.ID,
+		TargetName: token.Name,
+		Before:     apiTokenAuditSummary(token),
+	})
+
+	redirectWithMessage(w, r, "/admin/tokens", "已撤銷 API token「"+token.Name+"」", "success")
+}
+
+// apiTokenAuditSummary API token 的摘要，不包含 token 本身
+func apiTokenAuditSummary(token obj.APIToken) string {
+	expiry := "永不過期"
+	if token.ExpiresAt != nil {
+		expiry = token.ExpiresAt.Format("2006-01-02")
+	}
+	return "開頭：" + token.Prefix + "；授權範圍：" + token.Scopes + "；有效期限：" + expiry
+}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"support/db"
+	"support/obj"
+	"time"
+)
+
+// attachmentType 圖片以外允許上傳的檔案格式
+type attachmentType struct {
+	Kind    string // obj.ImageKind 常數之一
+	Ext     string // 儲存時使用的副檔名
+	Label   string // 顯示給使用者的格式名稱
+	MaxSize int64
+	Inline  bool // 是否在瀏覽器中直接顯示，其餘一律下載
+}
+
+// allowedAttachmentTypes 允許上傳的附件格式，以檔案內容判斷的 MIME 類型為鍵。
+// 不接受 HTML、SVG 等可以執行腳本的格式
+var allowedAttachmentTypes = map[string]attachmentType{
+	"application/pdf":          {obj.ImageKindDocument, ".pdf", "PDF 文件", 50 << 20, false},
+	"application/zip":          {obj.ImageKindArchive, ".zip", "ZIP 壓縮檔", 200 << 20, false},
+	"application/x-gzip":       {obj.ImageKindArchive, ".gz", "gzip 壓縮檔", 200 << 20, false},
+	"video/mp4":                {obj.ImageKindVideo, ".mp4", "MP4 影片", 200 << 20, true},
+	"video/webm":               {obj.ImageKindVideo, ".webm", "WebM 影片", 200 << 20, true},
+	"application/octet-stream": {obj.ImageKindFile, "", "韌體檔案", 100 << 20, false},
+}
+
+// firmwareExtensions 無法以內容判斷格式的檔案只接受這些副檔名，保存時沿用原本的副檔名
+var firmwareExtensions = []string{".bin", ".fw", ".img", ".dfu"}
+
+// maxUploadSize 所有格式中最大的上傳限制，用於限制請求大小
+var maxUploadSize = func() int64 {
+	size := int64(maxImageUploadSize)
+	for _, t := range allowedAttachmentTypes {
+		size = max(size, t.MaxSize)
+	}
+	return size
+}()
+
+// imageKindLabel 檔案種類的顯示名稱
+func imageKindLabel(kind string) string {
+	for _, k := range obj.ImageKinds {
+		if k.Value == kind {
+			return k.Label
+		}
+	}
+	return "圖片"
+}
+
+// sizeLabel 以 MB 顯示的大小限制
+func sizeLabel(size int64) string {
+	return fmt.Sprintf("%d MB", size>>20)
+}
+
+// isImageUpload 依檔頭判斷上傳的檔案是否為允許的圖片格式，完成後回到檔案開頭
+func isImageUpload(file io.ReadSeeker) bool {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	_, ok := allowedImageTypes[http.DetectContentType(head[:n])]
+	_, err := file.Seek(0, io.SeekStart)
+	return ok && err == nil
+}
+
+// validateAttachment 依檔案內容判斷附件格式，並確認檔案完整可讀，不採信用戶端送出的 Content-Type。
+// 回傳實際的 MIME 類型與格式設定
+func validateAttachment(file multipart.File, name string, size int64) (string, attachmentType, *uploadError) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", attachmentType{}, newUploadError(uploadErrInvalidFile, "無法讀取檔案")
+	}
+	contentType := http.DetectContentType(head[:n])
+	t, ok := allowedAttachmentTypes[contentType]
+	if !ok {
+		return "", attachmentType{}, newUploadError(uploadErrUnsupported, "不支援的檔案格式，僅支持圖片、PDF、ZIP、gzip、MP4、WebM 與韌體檔案（"+
+			strings.Join(firmwareExtensions, "、")+"）")
+	}
+
+	ext := strings.ToLower(path.Ext(name))
+	if t.Kind == obj.ImageKindFile {
+		if !slices.Contains(firmwareExtensions, ext) {
+			return "", attachmentType{}, newUploadError(uploadErrUnsupported, "無法辨識的檔案格式，韌體檔案的副檔名須為 "+strings.Join(firmwareExtensions, "、"))
+		}
+		t.Ext = ext
+	}
+	if size > t.MaxSize {
+		return "", attachmentType{}, newUploadError(uploadErrTooLarge, t.Label+"不能超過 "+sizeLabel(t.MaxSize))
+	}
+
+	// 確認壓縮檔的結構完整，避免保存截斷或偽裝的檔案
+	switch contentType {
+	case "application/zip":
+		if _, err := zip.NewReader(file, size); err != nil {
+			return "", attachmentType{}, newUploadError(uploadErrInvalidFile, "ZIP 檔案格式錯誤或已損毀")
+		}
+	case "application/x-gzip":
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", attachmentType{}, newUploadError(uploadErrServer, "讀取文件失敗")
+		}
+		if _, err := gzip.NewReader(file); err != nil {
+			return "", attachmentType{}, newUploadError(uploadErrInvalidFile, "gzip 檔案格式錯誤或已損毀")
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", attachmentType{}, newUploadError(uploadErrServer, "讀取文件失敗")
+	}
+	return contentType, t, nil
+}
+
+// readerHash 計算檔案內容的 SHA-256，完成後回到檔案開頭
+func readerHash(file io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeAttachment 保存圖片以外的附件。附件不重新編碼，直接以原始內容保存
+func storeAttachment(r *http.Request, file multipart.File, name string, size int64, alt, caption string) (obj.Image, bool, *uploadError) {
+	contentType, t, uploadErr := validateAttachment(file, name, size)
+	if uploadErr != nil {
+		log.Printf("Rejected upload %q: %v", name, uploadErr)
+		return obj.Image{}, false, uploadErr
+	}
+
+	// 內容相同的檔案已上傳過時，直接使用既有的檔案
+	hash, err := readerHash(file)
+	if err != nil {
+		log.Println("File read error:", err)
+		return obj.Image{}, false, newUploadError(uploadErrServer, "讀取文件失敗")
+	}
+	if existing, ok := findDuplicateImage(hash); ok {
+		log.Printf("Upload %q matches existing file %d", name, existing.ID)
+		return existing, true, nil
+	}
+
+	filename := generateUniqueFilename() + t.Ext
+	if err := db.Uploads.Put(filename, file, size, contentType); err != nil {
+		log.Println("File write error:", err)
+		return obj.Image{}, false, newUploadError(uploadErrServer, "保存文件失敗")
+	}
+
+	attachment := obj.Image{
+		Filename:    name,
+		Path:        db.Uploads.Location(filename),
+		URL:         db.UploadURLPath + filename,
+		Size:        size,
+		ContentType: contentType,
+		Kind:        t.Kind,
+		ContentHash: hash,
+		Alt:         alt,
+		Caption:     caption,
+		UploadTime:  time.Now(),
+	}
+	if err := db.AddImage(&attachment); err != nil {
+		log.Println("Image DB save error:", err)
+		db.Uploads.Delete(filename)
+		return obj.Image{}, false, newUploadError(uploadErrServer, "保存檔案記錄失敗")
+	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditImageUpload,
+		TargetType: "image",
+		TargetID:   attachment.ID,
+		TargetName: attachment.Filename,
+		After:      imageAuditSummary(attachment),
+	})
+	return attachment, false, nil
+}
+
+// uploadResult 編輯器與批次上傳收到的單一檔案上傳結果
+type uploadResult struct {
+	Success     bool   `json:"success"`
+	ID          uint   `json:"id,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Alt         string `json:"alt,omitempty"`
+	Caption     string `json:"caption,omitempty"`
+	Duplicate   bool   `json:"duplicate,omitempty"`
+	Code        string `json:"code,omitempty"`  // 失敗時的錯誤代碼
+	Error       string `json:"error,omitempty"` // 失敗時可直接顯示的訊息
+}
+
+// newUploadResult 上傳成功的結果。duplicate 表示使用既有的檔案，
+// 既有檔案沒有替代文字與說明時使用這次填寫的內容
+func newUploadResult(image obj.Image, alt, caption string, duplicate bool) uploadResult {
+	if image.Alt == "" {
+		image.Alt = alt
+	}
+	if image.Caption == "" {
+		image.Caption = caption
+	}
+	return uploadResult{
+		Success:     true,
+		ID:          image.ID,
+		URL:         image.URL,
+		Kind:        image.Kind,
+		Filename:    image.Filename,
+		ContentType: image.ContentType,
+		Size:        image.Size,
+		Alt:         image.Alt,
+		Caption:     image.Caption,
+		Duplicate:   duplicate,
+	}
+}
+
+// failedUploadResult 上傳失敗的結果
+func failedUploadResult(filename string, e *uploadError) uploadResult {
+	return uploadResult{Filename: filename, Code: e.Code, Error: e.Message}
+}
+
+// writeUploadResult 依上傳來源回應上傳結果：編輯器使用 JSON，圖片管理頁面導回並顯示訊息
+func writeUploadResult(w http.ResponseWriter, r *http.Request, image obj.Image, alt, caption string, duplicate bool) {
+	if r.FormValue("source") != "editor" {
+		switch {
+		case duplicate && image.IsImage():
+			redirectWithMessage(w, r, "/admin/images", "相同的圖片已上傳過（"+image.Filename+"），已使用既有的圖片", "info")
+		case duplicate:
+			redirectWithMessage(w, r, "/admin/images", "相同的檔案已上傳過（"+image.Filename+"），已使用既有的檔案", "info")
+		case image.IsImage():
+			redirectWithMessage(w, r, "/admin/images", "圖片上傳成功", "success")
+		default:
+			redirectWithMessage(w, r, "/admin/images", "檔案上傳成功", "success")
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUploadResult(image, alt, caption, duplicate))
+}
@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// 操作紀錄頁面最多顯示的筆數，完整紀錄可匯出 CSV
+const auditPageLimit = 500
+
+// auditEntry 一筆待寫入的操作紀錄
+type auditEntry struct {
+	Action     string
+	TargetType string
+	TargetID   uint
+	TargetName string
+	Before     string
+	After      string
+}
+
+// recordAudit 記錄後台操作，操作者取自目前的會話
+func recordAudit(r *http.Request, entry auditEntry) {
+	username := ""
+	if session, err := getAdminSession(r); err == nil {
+		username = session.Username
+	}
+	recordAuditAs(r, username, entry)
+}
+
+// recordAuditAs 以指定的使用者記錄後台操作，用於登入等尚未建立會話的情況
+func recordAuditAs(r *http.Request, username string, entry auditEntry) {
+	err := db.AddAuditLog(&obj.AuditLog{
+		Username:   username,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		TargetName: entry.TargetName,
+		Before:     entry.Before,
+		After:      entry.After,
+		IPAddress:  clientIP(r),
+	})
+	if err != nil {
+		log.Println("Error recording audit log:", err)
+	}
+}
+
+// categoryName 取得分類名稱，找不到時以 ID 表示
+func categoryName(id uint) string {
+	category, err := db.GetCategory(id)
+	if err != nil {
+		return "#" + strconv.FormatUint(uint64(id), 10)
+	}
+	return category.Name
+}
+
+// docAuditSummary 文件的摘要，content 為未編碼的文件內容
+func docAuditSummary(doc obj.Doc, content string) string {
+	status := "已發布"
+	if doc.IsDraft {
+		status = "草稿"
+	}
+	parts := []string{
+		"標題：" + doc.Title,
+		"分類：" + categoryName(doc.CategoryID),
+		"狀態：" + status,
+	}
+	if doc.PublishDate.Valid {
+		parts = append(parts, "發布日期："+doc.PublishDate.Time.Format("2006-01-02"))
+	}
+	parts = append(parts, fmt.Sprintf("內容：%d 字", utf8.RuneCountInString(content)))
+	return strings.Join(parts, "；")
+}
+
+// decodedDocContent 取得資料庫中文件的原始內容
+func decodedDocContent(doc obj.Doc) string {
+	content, err := url.QueryUnescape(doc.Content)
+	if err != nil {
+		return doc.Content
+	}
+	return content
+}
+
+// docUpdateAction 依草稿狀態的變化決定文件更新的動作
+func docUpdateAction(wasDraft, isDraft bool) string {
+	switch {
+	case wasDraft && !isDraft:
+		return obj.AuditDocPublish
+	case !wasDraft && isDraft:
+		return obj.AuditDocUnpublish
+	default:
+		return obj.AuditDocUpdate
+	}
+}
+
+// imageAuditSummary 圖片的摘要
+func imageAuditSummary(image obj.Image) string {
+	return fmt.Sprintf("檔名：%s；大小：%.1f KB；網址：%s", image.Filename, float64(image.Size)/1024, image.URL)
+}
+
+// snippetAuditSummary 內容片段的摘要，content 為未編碼的內容
+func snippetAuditSummary(snippet obj.Snippet, content string) string {
+	return fmt.Sprintf("名稱：%s；標題：%s；內容：%d 字", snippet.Name, snippet.Title, utf8.RuneCountInString(content))
+}
+
+// decodedSnippetContent 取得資料庫中內容片段的原始內容
+func decodedSnippetContent(snippet obj.Snippet) string {
+	content, err := url.QueryUnescape(snippet.Content)
+	if err != nil {
+		return snippet.Content
+	}
+	return content
+}
+
+// variableAuditSummary 內容變數的摘要
+func variableAuditSummary(variable obj.Variable) string {
+	return fmt.Sprintf("名稱：%s；值：%s；說明：%s", variable.Key, variable.Value, variable.Description)
+}
+
+// parseAuditFilter 從查詢參數取得篩選條件，日期格式為 2006-01-02
+func parseAuditFilter(r *http.Request) (obj.AuditFilter, map[string]string) {
+	query := r.URL.Query()
+	values := map[string]string{
+		"user":   strings.TrimSpace(query.Get("user")),
+		"action": query.Get("action"),
+		"from":   query.Get("from"),
+		"to":     query.Get("to"),
+	}
+
+	filter := obj.AuditFilter{Username: values["user"], Action: values["action"]}
+	if t, err := time.ParseInLocation("2006-01-02", values["from"], time.Local); err == nil {
+		filter.From = t
+	} else {
+		values["from"] = ""
+	}
+	if t, err := time.ParseInLocation("2006-01-02", values["to"], time.Local); err == nil {
+		filter.To = t.AddDate(0, 0, 1) // 包含結束日期當天
+	} else {
+		values["to"] = ""
+	}
+	return filter, values
+}
+
+// AdminAuditHandler 處理操作紀錄頁面
+func AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	filter, values := parseAuditFilter(r)
+	logs, err := db.GetAuditLogs(filter, auditPageLimit)
+	if err != nil {
+		log.Println("Error fetching audit logs:", err)
+	}
+	total, err := db.CountAuditLogs(filter)
+	if err != nil {
+		log.Println("Error counting audit logs:", err)
+	}
+	usernames, err := db.GetAuditUsernames()
+	if err != nil {
+		log.Println("Error fetching audit usernames:", err)
+	}
+
+	// 匯出連結沿用目前的篩選條件
+	exportQuery := url.Values{}
+	for k, v := range values {
+		if v != "" {
+			exportQuery.Set(k, v)
+		}
+	}
+
+	// 準備模板資料
+	data := map[string]interface{}{
+		"Active":      "audit",
+		"Logs":        logs,
+		"Total":       total,
+		"Limit":       auditPageLimit,
+		"Filter":      values,
+		"Usernames":   usernames,
+		"Actions":     obj.AuditActions,
+		"ExportQuery": exportQuery.Encode(),
+		"Username":    session.Username,
+		"CSRFToken":   csrfToken(r),
+	}
+
+	// 解析模板
+	tmpl, err := template.New("layout.html").Funcs(template.FuncMap{
+		"actionLabel": obj.AuditActionLabel,
+	}).ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/audit.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminAuditExportHandler 依篩選條件將操作紀錄匯出為 CSV
+func AdminAuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	filter, _ := parseAuditFilter(r)
+	logs, err := db.GetAuditLogs(filter, 0)
+	if err != nil {
+		log.Println("Error fetching audit logs:", err)
+		http.Error(w, "匯出失敗", http.StatusInternalServerError)
+		return
+	}
+
+	filename := "audit-" + time.Now().Format("20060102-150405") + ".csv"
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// 加上 BOM，讓 Excel 以 UTF-8 開啟
+	w.Write([]byte("\xEF\xBB\xBF"))
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"時間", "使用者", "動作", "對象類型", "對象ID", "對象名稱", "變更前", "變更後", "IP 位址"})
+	for _, entry := range logs {
+		targetID := ""
+		if entry.TargetID != 0 {
+			targetID = strconv.FormatUint(uint64(entry.TargetID), 10)
+		}
+		writer.Write([]string{
+			entry.CreateTime.Format("2006-01-02 15:04:05"),
+			entry.Username,
+			obj.AuditActionLabel(entry.Action),
+			entry.TargetType,
+			targetID,
+			csvSafe(entry.TargetName),
+			csvSafe(entry.Before),
+			csvSafe(entry.After),
+			entry.IPAddress,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println("CSV write error:", err)
+	}
+}
+
+// csvSafe 避免以 = + - @ 開頭的內容在試算表中被當成公式執行
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,682 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// ---- mermaid 圖表 ----
+// 語言為 mermaid 的程式碼區塊在伺服器端轉為 SVG，目前支援 flowchart / graph 流程圖
+// 其他圖表類型以程式碼區塊原樣顯示
+
+var kindDiagram = ast.NewNodeKind("Diagram")
+
+// diagramBlock 圖表節點
+type diagramBlock struct {
+	ast.BaseBlock
+	Source string
+}
+
+func (n *diagramBlock) Kind() ast.NodeKind { return kindDiagram }
+
+func (n *diagramBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Source": n.Source}, nil)
+}
+
+// diagramTransformer 將 mermaid 程式碼區塊替換為圖表節點
+type diagramTransformer struct{}
+
+func (t *diagramTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var blocks []*ast.FencedCodeBlock
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if code, ok := n.(*ast.FencedCodeBlock); ok && entering {
+			if string(code.Language(source)) == "mermaid" {
+				blocks = append(blocks, code)
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// 走訪結束後再替換，避免修改正在走訪的樹
+	for _, code := range blocks {
+		var sb strings.Builder
+		lines := code.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			sb.Write(segment.Value(source))
+		}
+		code.Parent().ReplaceChild(code.Parent(), code, &diagramBlock{Source: sb.String()})
+	}
+}
+
+// diagramExtension 註冊 mermaid 圖表的轉換器與渲染器
+type diagramExtension struct{}
+
+func (e diagramExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(&diagramTransformer{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&diagramRenderer{}, 500)))
+}
+
+// diagramRenderer 將圖表節點輸出為 SVG
+type diagramRenderer struct{}
+
+func (r *diagramRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindDiagram, r.renderDiagram)
+}
+
+func (r *diagramRenderer) renderDiagram(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString(renderDiagram(node.(*diagramBlock).Source))
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// renderDiagram 將 mermaid 原始碼轉為 SVG，結果依內容雜湊快取
+// 不支援或有錯誤的圖表以程式碼區塊顯示並附上說明
+func renderDiagram(src string) string {
+	return cachedFragment("diagram", src, func() string {
+		chart, err := parseFlowchart(src)
+		if err != nil {
+			return `<div class="diagram diagram-error"><pre><code class="language-mermaid">` +
+				html.EscapeString(src) + `</code></pre><p class="diagram-note">無法顯示圖表：` +
+				html.EscapeString(err.Error()) + "</p></div>\n"
+		}
+		return `<div class="diagram">` + chart.svg(diagramID(src)) + "</div>\n"
+	})
+}
+
+// diagramID 以內容雜湊產生 SVG 內部元素的 ID，避免同一頁多張圖表互相衝突
+func diagramID(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return "diagram-" + hex.EncodeToString(sum[:4])
+}
+
+// ---- 流程圖解析 ----
+
+// 節點形狀
+const (
+	shapeRect    = "rect"
+	shapeRound   = "round"
+	shapeStadium = "stadium"
+	shapeCircle  = "circle"
+	shapeDiamond = "diamond"
+)
+
+// flowNode 流程圖節點
+type flowNode struct {
+	ID    string
+	Label string
+	Shape string
+
+	rank  int     // 所在層級
+	order float64 // 層內排序依據
+	x, y  float64 // 中心座標
+	w, h  float64
+}
+
+// flowEdge 流程圖連線
+type flowEdge struct {
+	From, To *flowNode
+	Label    string
+	Style    string // solid、dotted 或 thick
+	Arrow    bool
+	back     bool // 形成循環的反向連線，不參與分層
+}
+
+// flowchart 解析後的流程圖
+type flowchart struct {
+	Direction string // TD、BT、LR 或 RL
+	Nodes     []*flowNode
+	Edges     []*flowEdge
+	byID      map[string]*flowNode
+}
+
+var (
+	flowHeaderPattern = regexp.MustCompile(`^(?:graph|flowchart)(?:\s+(TD|TB|BT|LR|RL))?\s*;?$`)
+	flowIDPattern     = regexp.MustCompile(`^[\p{L}\p{N}_]+`)
+	// 連線：-->、---、-.->、-.-、==>、===，可帶 |文字| 標籤
+	flowEdgePattern = regexp.MustCompile(`^\s*(--+>|---+|-\.+->|-\.+-|==+>|===+)\s*(?:\|([^|]*)\|)?\s*`)
+	// 文字寫在連線中間的寫法：A -- 文字 --> B
+	flowTextEdgePattern = regexp.MustCompile(`^\s*(--|-\.|==)\s+([^>|]+?)\s*(--+>|---+|\.+->|\.+-|==+>|===+)\s*`)
+)
+
+// 節點形狀的開始與結束符號，較長的符號需排在前面
+var flowShapes = []struct{ open, close, shape string }{
+	{"((", "))", shapeCircle},
+	{"([", "])", shapeStadium},
+	{"[", "]", shapeRect},
+	{"(", ")", shapeRound},
+	{"{", "}", shapeDiamond},
+}
+
+// 不影響版面的敘述，例如樣式設定與子圖
+var flowIgnoredStatements = []string{"classDef ", "class ", "style ", "linkStyle ", "click ", "subgraph ", "end ", "direction "}
+
+// parseFlowchart 解析 mermaid 流程圖語法
+func parseFlowchart(src string) (*flowchart, error) {
+	chart := &flowchart{Direction: "TD", byID: map[string]*flowNode{}}
+
+	header := false
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "%%") {
+			continue
+		}
+
+		if !header {
+			m := flowHeaderPattern.FindStringSubmatch(line)
+			if m == nil {
+				kind := strings.Fields(line)[0]
+				return nil, fmt.Errorf("不支援的圖表類型 %s，目前僅支援 flowchart", kind)
+			}
+			if m[1] != "" && m[1] != "TB" {
+				chart.Direction = m[1]
+			}
+			header = true
+			continue
+		}
+
+		for _, stmt := range strings.Split(line, ";") {
+			stmt = strings.TrimSpace(stmt)
+			if stmt == "" || isIgnoredFlowStatement(stmt) {
+				continue
+			}
+			if err := chart.parseStatement(stmt); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !header {
+		return nil, errors.New("圖表內容為空")
+	}
+	if len(chart.Nodes) == 0 {
+		return nil, errors.New("流程圖沒有任何節點")
+	}
+	return chart, nil
+}
+
+func isIgnoredFlowStatement(stmt string) bool {
+	for _, prefix := range flowIgnoredStatements {
+		if stmt == strings.TrimSpace(prefix) || strings.HasPrefix(stmt, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatement 解析一行節點定義或連線鏈，例如 A[開始] --> B{判斷} -->|是| C
+func (c *flowchart) parseStatement(stmt string) error {
+	rest := stmt
+	from, rest, err := c.parseNodeRef(rest)
+	if err != nil {
+		return err
+	}
+
+	for strings.TrimSpace(rest) != "" {
+		var arrow, label string
+		if m := flowEdgePattern.FindStringSubmatch(rest); m != nil {
+			arrow, label = m[1], m[2]
+			rest = rest[len(m[0]):]
+		} else if m := flowTextEdgePattern.FindStringSubmatch(rest); m != nil {
+			arrow, label = m[1]+m[3], m[2]
+			rest = rest[len(m[0]):]
+		} else {
+			return fmt.Errorf("無法解析連線: %s", strings.TrimSpace(rest))
+		}
+
+		to, remaining, err := c.parseNodeRef(rest)
+		if err != nil {
+			return err
+		}
+		rest = remaining
+
+		edge := &flowEdge{
+			From:  from,
+			To:    to,
+			Label: strings.Trim(strings.TrimSpace(label), `"`),
+			Style: "solid",
+			Arrow: strings.HasSuffix(arrow, ">"),
+		}
+		if strings.Contains(arrow, ".") {
+			edge.Style = "dotted"
+		} else if strings.HasPrefix(arrow, "=") {
+			edge.Style = "thick"
+		}
+		c.Edges = append(c.Edges, edge)
+		from = to
+	}
+	return nil
+}
+
+// parseNodeRef 解析節點 ID 與可選的形狀及標籤，回傳節點與剩餘字串
+func (c *flowchart) parseNodeRef(s string) (*flowNode, string, error) {
+	s = strings.TrimLeft(s, " \t")
+	id := flowIDPattern.FindString(s)
+	if id == "" {
+		return nil, "", fmt.Errorf("缺少節點名稱: %s", s)
+	}
+	s = s[len(id):]
+
+	node, ok := c.byID[id]
+	if !ok {
+		node = &flowNode{ID: id, Label: id, Shape: shapeRect}
+		c.byID[id] = node
+		c.Nodes = append(c.Nodes, node)
+	}
+
+	for _, shape := range flowShapes {
+		if !strings.HasPrefix(s, shape.open) {
+			continue
+		}
+		end := strings.Index(s[len(shape.open):], shape.close)
+		if end < 0 {
+			return nil, "", fmt.Errorf("節點 %s 缺少結束符號 %s", id, shape.close)
+		}
+		label := strings.TrimSpace(s[len(shape.open) : len(shape.open)+end])
+		node.Label = strings.Trim(label, `"`)
+		node.Shape = shape.shape
+		s = s[len(shape.open)+end+len(shape.close):]
+		break
+	}
+	return node, s, nil
+}
+
+// ---- 流程圖排版 ----
+
+const (
+	diagramFontSize   = 14
+	diagramLineHeight = 18
+	diagramRankGap    = 50 // 層與層之間的距離
+	diagramNodeGap    = 30 // 同層節點之間的距離
+	diagramMargin     = 10
+)
+
+// labelLines 將標籤依 <br> 拆成多行
+func labelLines(label string) []string {
+	label = strings.NewReplacer("<br/>", "\n", "<br />", "\n", "<br>", "\n").Replace(label)
+	return strings.Split(label, "\n")
+}
+
+// textWidth 估算文字寬度，中日韓等全形字元約為半形字元的兩倍寬
+func textWidth(s string) float64 {
+	var width float64
+	for _, r := range s {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) || r >= 0xFF00 && r <= 0xFFEF {
+			width += diagramFontSize
+		} else {
+			width += diagramFontSize * 0.6
+		}
+	}
+	return width
+}
+
+// measure 依標籤與形狀計算節點大小
+func (n *flowNode) measure() {
+	lines := labelLines(n.Label)
+	var width float64
+	for _, line := range lines {
+		width = math.Max(width, textWidth(line))
+	}
+	textHeight := float64(len(lines) * diagramLineHeight)
+
+	n.w = math.Max(width+30, 60)
+	n.h = textHeight + 22
+	switch n.Shape {
+	case shapeCircle:
+		d := math.Max(math.Max(width, textHeight)+24, 50)
+		n.w, n.h = d, d
+	case shapeDiamond:
+		n.w = math.Max(width*1.5+20, 70)
+		n.h = math.Max(textHeight*2+20, 56)
+	case shapeStadium:
+		n.w += n.h / 2
+	}
+}
+
+// layout 以分層方式計算所有節點位置，回傳圖表寬高
+func (c *flowchart) layout() (float64, float64) {
+	c.markBackEdges()
+	c.assignRanks()
+
+	// 依層級分組，先以宣告順序排列
+	var layers [][]*flowNode
+	for i, n := range c.Nodes {
+		n.measure()
+		n.order = float64(i)
+		for len(layers) <= n.rank {
+			layers = append(layers, nil)
+		}
+		layers[n.rank] = append(layers[n.rank], n)
+	}
+
+	// 依上層相連節點的平均位置重新排序，減少連線交叉
+	preds := map[*flowNode][]*flowNode{}
+	for _, e := range c.Edges {
+		if !e.back && e.From != e.To {
+			preds[e.To] = append(preds[e.To], e.From)
+		}
+	}
+	for _, layer := range layers[1:] {
+		for _, n := range layer {
+			if len(preds[n]) == 0 {
+				continue
+			}
+			var sum float64
+			for _, p := range preds[n] {
+				sum += p.order
+			}
+			n.order = sum / float64(len(preds[n]))
+		}
+		sort.SliceStable(layer, func(i, j int) bool { return layer[i].order < layer[j].order })
+		for i, n := range layer {
+			n.order = float64(i)
+		}
+	}
+
+	horizontal := c.Direction == "LR" || c.Direction == "RL"
+
+	// 主軸為層級方向，交叉軸為同層節點排列方向
+	var crossSizes []float64
+	var maxCross float64
+	for _, layer := range layers {
+		var size float64
+		for i, n := range layer {
+			if i > 0 {
+				size += diagramNodeGap
+			}
+			size += crossSize(n, horizontal)
+		}
+		crossSizes = append(crossSizes, size)
+		maxCross = math.Max(maxCross, size)
+	}
+
+	var main float64 = diagramMargin
+	for i, layer := range layers {
+		var depth float64
+		for _, n := range layer {
+			depth = math.Max(depth, mainSize(n, horizontal))
+		}
+		cross := diagramMargin + (maxCross-crossSizes[i])/2
+		for _, n := range layer {
+			size := crossSize(n, horizontal)
+			if horizontal {
+				n.x, n.y = main+depth/2, cross+size/2
+			} else {
+				n.x, n.y = cross+size/2, main+depth/2
+			}
+			cross += size + diagramNodeGap
+		}
+		main += depth + diagramRankGap
+		if horizontal {
+			// 水平排列時連線較長，保留標籤空間
+			main += 20
+		}
+	}
+	main += diagramMargin - diagramRankGap
+	if horizontal {
+		main -= 20
+	}
+
+	width, height := main, maxCross+2*diagramMargin
+	if !horizontal {
+		width, height = height, width
+	}
+
+	// 反向排列
+	for _, n := range c.Nodes {
+		switch c.Direction {
+		case "BT":
+			n.y = height - n.y
+		case "RL":
+			n.x = width - n.x
+		}
+	}
+	return width, height
+}
+
+func crossSize(n *flowNode, horizontal bool) float64 {
+	if horizontal {
+		return n.h
+	}
+	return n.w
+}
+
+func mainSize(n *flowNode, horizontal bool) float64 {
+	if horizontal {
+		return n.w
+	}
+	return n.h
+}
+
+// markBackEdges 以深度優先搜尋找出形成循環的連線
+func (c *flowchart) markBackEdges() {
+	out := map[*flowNode][]*flowEdge{}
+	for _, e := range c.Edges {
+		out[e.From] = append(out[e.From], e)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[*flowNode]int{}
+	var visit func(n *flowNode)
+	visit = func(n *flowNode) {
+		state[n] = visiting
+		for _, e := range out[n] {
+			switch state[e.To] {
+			case visiting:
+				e.back = true
+			case unvisited:
+				visit(e.To)
+			}
+		}
+		state[n] = done
+	}
+	for _, n := range c.Nodes {
+		if state[n] == unvisited {
+			visit(n)
+		}
+	}
+}
+
+// assignRanks 以最長路徑決定節點層級，反向連線不列入計算
+func (c *flowchart) assignRanks() {
+	for range c.Nodes {
+		changed := false
+		for _, e := range c.Edges {
+			if e.back || e.From == e.To {
+				continue
+			}
+			if e.To.rank < e.From.rank+1 {
+				e.To.rank = e.From.rank + 1
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+}
+
+// ---- SVG 輸出 ----
+
+// 圖表預設配色，深色模式由頁面 CSS 覆寫
+const (
+	diagramNodeFill   = "#eef4ff"
+	diagramNodeStroke = "#4a6fa5"
+	diagramEdgeColor  = "#555555"
+	diagramTextColor  = "#222222"
+	diagramLabelFill  = "#ffffff"
+)
+
+// svg 輸出流程圖的 SVG
+func (c *flowchart) svg(id string) string {
+	width, height := c.layout()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" class="flowchart" role="img" aria-label="流程圖" `+
+		`viewBox="0 0 %s %s" width="%s" height="%s" font-size="%d">`,
+		num(width), num(height), num(width), num(height), diagramFontSize)
+	fmt.Fprintf(&sb, `<defs><marker id="%s-arrow" viewBox="0 0 10 10" refX="9" refY="5" markerWidth="8" markerHeight="8" orient="auto-start-reverse">`+
+		`<path class="arrowhead" d="M0,0 L10,5 L0,10 z" fill="%s"/></marker></defs>`, id, diagramEdgeColor)
+
+	// 先畫連線，讓節點覆蓋在連線上方
+	for _, e := range c.Edges {
+		c.writeEdge(&sb, e, id)
+	}
+	for _, n := range c.Nodes {
+		writeNode(&sb, n)
+	}
+	sb.WriteString("</svg>")
+	return sb.String()
+}
+
+// writeNode 輸出節點形狀與標籤
+func writeNode(sb *strings.Builder, n *flowNode) {
+	style := fmt.Sprintf(` class="node-shape" fill="%s" stroke="%s" stroke-width="1.5"`, diagramNodeFill, diagramNodeStroke)
+	left, top := n.x-n.w/2, n.y-n.h/2
+
+	sb.WriteString(`<g class="node">`)
+	switch n.Shape {
+	case shapeCircle:
+		fmt.Fprintf(sb, `<circle cx="%s" cy="%s" r="%s"%s/>`, num(n.x), num(n.y), num(n.w/2), style)
+	case shapeDiamond:
+		fmt.Fprintf(sb, `<polygon points="%s,%s %s,%s %s,%s %s,%s"%s/>`,
+			num(n.x), num(top), num(left+n.w), num(n.y), num(n.x), num(top+n.h), num(left), num(n.y), style)
+	default:
+		radius := 0.0
+		switch n.Shape {
+		case shapeRound:
+			radius = 8
+		case shapeStadium:
+			radius = n.h / 2
+		}
+		fmt.Fprintf(sb, `<rect x="%s" y="%s" width="%s" height="%s" rx="%s"%s/>`,
+			num(left), num(top), num(n.w), num(n.h), num(radius), style)
+	}
+	writeText(sb, n.x, n.y, labelLines(n.Label))
+	sb.WriteString("</g>")
+}
+
+// writeText 輸出置中的多行文字
+func writeText(sb *strings.Builder, x, y float64, lines []string) {
+	startY := y - float64(len(lines)-1)*diagramLineHeight/2
+	fmt.Fprintf(sb, `<text class="label" x="%s" y="%s" text-anchor="middle" dominant-baseline="central" fill="%s">`,
+		num(x), num(startY), diagramTextColor)
+	for i, line := range lines {
+		if i == 0 {
+			fmt.Fprintf(sb, `<tspan x="%s">%s</tspan>`, num(x), html.EscapeString(line))
+		} else {
+			fmt.Fprintf(sb, `<tspan x="%s" dy="%d">%s</tspan>`, num(x), diagramLineHeight, html.EscapeString(line))
+		}
+	}
+	sb.WriteString("</text>")
+}
+
+// writeEdge 輸出連線與連線標籤
+func (c *flowchart) writeEdge(sb *strings.Builder, e *flowEdge, id string) {
+	attrs := fmt.Sprintf(` class="edge edge-%s" fill="none" stroke="%s"`, e.Style, diagramEdgeColor)
+	switch e.Style {
+	case "dotted":
+		attrs += ` stroke-width="1.5" stroke-dasharray="4 3"`
+	case "thick":
+		attrs += ` stroke-width="3"`
+	default:
+		attrs += ` stroke-width="1.5"`
+	}
+	if e.Arrow {
+		attrs += fmt.Sprintf(` marker-end="url(#%s-arrow)"`, id)
+	}
+
+	var labelX, labelY float64
+	if e.From == e.To {
+		// 自我連線畫在節點右側
+		n := e.From
+		x, y := n.x+n.w/2, n.y
+		fmt.Fprintf(sb, `<path d="M%s,%s C%s,%s %s,%s %s,%s"%s/>`,
+			num(x), num(y-8), num(x+40), num(y-30), num(x+40), num(y+30), num(x), num(y+8), attrs)
+		labelX, labelY = x+40, y
+	} else {
+		x1, y1 := boundaryPoint(e.From, e.To.x, e.To.y)
+		x2, y2 := boundaryPoint(e.To, e.From.x, e.From.y)
+		if e.back {
+			// 反向連線畫成弧線，避免與正向連線重疊
+			dx, dy := x2-x1, y2-y1
+			length := math.Hypot(dx, dy)
+			cx, cy := (x1+x2)/2-dy/length*40, (y1+y2)/2+dx/length*40
+			fmt.Fprintf(sb, `<path d="M%s,%s Q%s,%s %s,%s"%s/>`,
+				num(x1), num(y1), num(cx), num(cy), num(x2), num(y2), attrs)
+			// 二次貝茲曲線中點
+			labelX, labelY = (x1+2*cx+x2)/4, (y1+2*cy+y2)/4
+		} else {
+			fmt.Fprintf(sb, `<path d="M%s,%s L%s,%s"%s/>`, num(x1), num(y1), num(x2), num(y2), attrs)
+			labelX, labelY = (x1+x2)/2, (y1+y2)/2
+		}
+	}
+
+	if e.Label == "" {
+		return
+	}
+	lines := labelLines(e.Label)
+	var width float64
+	for _, line := range lines {
+		width = math.Max(width, textWidth(line))
+	}
+	height := float64(len(lines) * diagramLineHeight)
+	fmt.Fprintf(sb, `<g class="edge-label"><rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`,
+		num(labelX-width/2-4), num(labelY-height/2), num(width+8), num(height), diagramLabelFill)
+	writeText(sb, labelX, labelY, lines)
+	sb.WriteString("</g>")
+}
+
+// boundaryPoint 計算從節點中心朝 (tx, ty) 方向與節點邊框的交點
+func boundaryPoint(n *flowNode, tx, ty float64) (float64, float64) {
+	dx, dy := tx-n.x, ty-n.y
+	if dx == 0 && dy == 0 {
+		return n.x, n.y
+	}
+
+	var t float64
+	switch n.Shape {
+	case shapeCircle:
+		t = (n.w / 2) / math.Hypot(dx, dy)
+	case shapeDiamond:
+		t = 1 / (math.Abs(dx)/(n.w/2) + math.Abs(dy)/(n.h/2))
+	default:
+		t = math.Inf(1)
+		if dx != 0 {
+			t = (n.w / 2) / math.Abs(dx)
+		}
+		if dy != 0 {
+			t = math.Min(t, (n.h/2)/math.Abs(dy))
+		}
+	}
+	return n.x + dx*t, n.y + dy*t
+}
+
+// num 將座標格式化為最多一位小數
+func num(v float64) string {
+	s := fmt.Sprintf("%.1f", v)
+	return strings.TrimSuffix(s, ".0")
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+)
+
+func TestParseFlowchart(t *testing.T) {
+	type edge struct{ from, to, label, style string }
+	tests := []struct {
+		name      string
+		src       string
+		direction string
+		nodes     map[string]string // ID 對應的形狀
+		labels    map[string]string // ID 對應的標籤
+		edges     []edge
+		wantErr   string
+	}{
+		{
+			name:      "連線鏈與形狀",
+			src:       "flowchart LR\n  A[開始] --> B{判斷} -->|是| C((結束))\n  B -- 否 --> D(重試)",
+			direction: "LR",
+			nodes:     map[string]string{"A": shapeRect, "B": shapeDiamond, "C": shapeCircle, "D": shapeRound},
+			labels:    map[string]string{"A": "開始", "B": "判斷", "C": "結束", "D": "重試"},
+			edges:     []edge{{"A", "B", "", "solid"}, {"B", "C", "是", "solid"}, {"B", "D", "否", "solid"}},
+		},
+		{
+			name:      "預設方向與分號",
+			src:       "graph\nA --- B; B -.-> C; C ==> A",
+			direction: "TD",
+			nodes:     map[string]string{"A": shapeRect, "B": shapeRect, "C": shapeRect},
+			edges:     []edge{{"A", "B", "", "solid"}, {"B", "C", "", "dotted"}, {"C", "A", "", "thick"}},
+		},
+		{
+			name:      "TB 視為 TD",
+			src:       "graph TB\nA",
+			direction: "TD",
+			nodes:     map[string]string{"A": shapeRect},
+		},
+		{
+			name:   "引號標籤與體育場形狀",
+			src:    "graph TD\nA([\"開始 (A)\"])",
+			nodes:  map[string]string{"A": shapeStadium},
+			labels: map[string]string{"A": "開始 (A)"},
+		},
+		{
+			name:   "忽略註解、樣式與子圖",
+			src:    "graph TD\n%% 註解\nsubgraph 群組\nA --> B\nend\nstyle A fill:#f9f\nclassDef red fill:#f00\nclass A red",
+			nodes:  map[string]string{"A": shapeRect, "B": shapeRect},
+			edges:  []edge{{"A", "B", "", "solid"}},
+			labels: map[string]string{"A": "A"},
+		},
+		{name: "不支援的圖表類型", src: "sequenceDiagram\nA->>B: hi", wantErr: "不支援的圖表類型 sequenceDiagram"},
+		{name: "空白內容", src: "\n  \n", wantErr: "圖表內容為空"},
+		{name: "沒有節點", src: "graph TD\n", wantErr: "沒有任何節點"},
+		{name: "缺少結束符號", src: "graph TD\nA[開始 --> B", wantErr: "缺少結束符號 ]"},
+		{name: "無法解析的連線", src: "graph TD\nA ~~ B", wantErr: "無法解析連線"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := parseFlowchart(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v，應包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.direction != "" && chart.Direction != tt.direction {
+				t.Errorf("方向 = %s，應為 %s", chart.Direction, tt.direction)
+			}
+			if len(chart.Nodes) != len(tt.nodes) {
+				t.Errorf("節點數 = %d，應為 %d", len(chart.Nodes), len(tt.nodes))
+			}
+			for id, shape := range tt.nodes {
+				node := chart.byID[id]
+				if node == nil {
+					t.Errorf("缺少節點 %s", id)
+					continue
+				}
+				if node.Shape != shape {
+					t.Errorf("節點 %s 形狀 = %s，應為 %s", id, node.Shape, shape)
+				}
+			}
+			for id, label := range tt.labels {
+				if node := chart.byID[id]; node == nil || node.Label != label {
+					t.Errorf("節點 %s 標籤錯誤: %+v", id, node)
+				}
+			}
+			if len(chart.Edges) != len(tt.edges) {
+				t.Fatalf("連線數 = %d，應為 %d", len(chart.Edges), len(tt.edges))
+			}
+			for i, want := range tt.edges {
+				got := chart.Edges[i]
+				if got.From.ID != want.from || got.To.ID != want.to || got.Label != want.label || got.Style != want.style {
+					t.Errorf("連線 %d = %s→%s %q %s，應為 %+v", i, got.From.ID, got.To.ID, got.Label, got.Style, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFlowchartLayout(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		check func(t *testing.T, c *flowchart)
+	}{
+		{
+			name: "由上而下依層級排列",
+			src:  "graph TD\nA --> B --> C",
+			check: func(t *testing.T, c *flowchart) {
+				a, b, cc := c.byID["A"], c.byID["B"], c.byID["C"]
+				if !(a.y < b.y && b.y < cc.y) || a.x != b.x {
+					t.Errorf("座標 A(%v,%v) B(%v,%v) C(%v,%v)", a.x, a.y, b.x, b.y, cc.x, cc.y)
+				}
+			},
+		},
+		{
+			name: "由右而左",
+			src:  "graph RL\nA --> B",
+			check: func(t *testing.T, c *flowchart) {
+				if c.byID["A"].x <= c.byID["B"].x {
+					t.Error("RL 時 A 應在 B 右側")
+				}
+			},
+		},
+		{
+			name: "循環不影響分層",
+			src:  "graph TD\nA --> B --> C --> A",
+			check: func(t *testing.T, c *flowchart) {
+				if c.byID["A"].rank != 0 || c.byID["C"].rank != 2 {
+					t.Errorf("層級 A=%d C=%d", c.byID["A"].rank, c.byID["C"].rank)
+				}
+				if !c.Edges[2].back {
+					t.Error("C --> A 應標示為反向連線")
+				}
+			},
+		},
+		{
+			name: "分支排在同一層",
+			src:  "graph TD\nA --> B\nA --> C",
+			check: func(t *testing.T, c *flowchart) {
+				if c.byID["B"].y != c.byID["C"].y || c.byID["B"].x == c.byID["C"].x {
+					t.Error("B 與 C 應在同一層的不同位置")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := parseFlowchart(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			width, height := chart.layout()
+			if width <= 0 || height <= 0 {
+				t.Fatalf("大小 = %v x %v", width, height)
+			}
+			for _, n := range chart.Nodes {
+				if n.x-n.w/2 < 0 || n.x+n.w/2 > width || n.y-n.h/2 < 0 || n.y+n.h/2 > height {
+					t.Errorf("節點 %s 超出圖表範圍", n.ID)
+				}
+			}
+			tt.check(t, chart)
+		})
+	}
+}
+
+func TestDiagramMarkdown(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(diagramExtension{}))
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "流程圖轉為 SVG",
+			src:  "```mermaid\ngraph TD\nA[<開始>] --> B\n```",
+			want: []string{`<div class="diagram"><svg`, `marker id="diagram-`, `&lt;開始&gt;`},
+		},
+		{
+			name:    "不支援的圖表以程式碼區塊顯示",
+			src:     "```mermaid\npie\n\"a\" : 1\n```",
+			want:    []string{`<div class="diagram diagram-error"><pre><code class="language-mermaid">pie`, `無法顯示圖表：不支援的圖表類型 pie`},
+			notWant: []string{"<svg"},
+		},
+		{
+			name:    "其他語言的程式碼區塊不處理",
+			src:     "```go\ngraph TD\n```",
+			want:    []string{`<pre><code class="language-go">`},
+			notWant: []string{"diagram"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := md.Convert([]byte(tt.src), &buf); err != nil {
+				t.Fatal(err)
+			}
+			out := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("輸出不包含 %q\n%s", want, out)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("輸出不應包含 %q\n%s", notWant, out)
+				}
+			}
+		})
+	}
+}
@@ -3,13 +3,11 @@ package handler
 import (
 	"log"
 	"net/http"
-	ur "net/url"
 	"strconv"
 	"strings"
 	"text/template"
 
 	"github.com/HazelnutParadise/Go-Utils/conv"
-	"github.com/yuin/goldmark"
 
 	"support/db"
 	"support/obj"
@@ -46,6 +44,32 @@ func DocHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	writeDocPage(w, r, doc)
+}
+
+// AdminDocPreviewHandler 讓後台使用者以前台版面預覽文件，包含尚未發布的草稿
+func AdminDocPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	docID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil {
+		log.Println("Invalid doc ID:", err)
+		NotFoundHandler(w, r)
+		return
+	}
+
+	doc, err := db.GetDoc(uint(docID))
+	if err != nil {
+		log.Println("Error fetching doc:", err)
+		NotFoundHandler(w, r)
+		return
+	}
+
+	// 草稿預覽不應被搜尋引擎收錄
+	w.Header().Set("X-Robots-Tag", "noindex")
+	writeDocPage(w, r, doc)
+}
+
+// writeDocPage 以前台版面輸出文件
+func writeDocPage(w http.ResponseWriter, r *http.Request, doc obj.Doc) {
 	// 獲取分類列表
 	categories, err := db.GetCategoryList()
 	if err != nil {
@@ -75,22 +99,20 @@ func DocHandler(w http.ResponseWriter, r *http.Request) {
 		Categories:        categories,
 	}
 
-	// URL 解碼
-	md, err := ur.QueryUnescape(doc.Content)
+	// 渲染文章內容
+	rendered, err := renderDoc(doc)
 	if err != nil {
-		log.Println("URL decode error:", err)
+		log.Println("Doc render error:", err)
 		data.HTMLContent = "<p>內容解析失敗</p>"
 	} else {
-		var htmlBuilder strings.Builder
-		if err := goldmark.Convert([]byte(md), &htmlBuilder); err != nil {
-			log.Println("Markdown parse error:", err)
-			data.HTMLContent = "<p>內容解析失敗</p>"
-		} else {
-			data.HTMLContent = htmlBuilder.String()
-		}
+		data.HTMLContent = rendered.HTML
+		data.TOC = rendered.TOC
 	}
 
 	data.PageTitle = doc.Title + " | " + data.PageTitle
+	if doc.IsDraft {
+		data.PageTitle = "[草稿預覽] " + data.PageTitle
+	}
 
 	// 解析並執行模板
 	tmpl, err := template.ParseFiles("templates/doc.html", "templates/header.html")
@@ -0,0 +1,642 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"sync"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	xdraw "golang.org/x/image/draw"
+)
+
+// 圖片處理的設定
+const (
+	maxStoredImageWidth = 2560 // 上傳的圖片超過此寬度時縮小後再保存
+	defaultImageWidth   = 960  // 文章內容區的最大顯示寬度
+	thumbnailSize       = 240  // 後台圖片列表的正方形縮圖邊長
+	originalJPEGQuality = 90   // 重新編碼上傳圖片的 JPEG 品質
+	variantJPEGQuality  = 82   // 縮圖與不同寬度版本的 JPEG 品質
+)
+
+// imageWidths 提供的圖片寬度，超過原圖寬度的版本直接使用原圖
+var imageWidths = []int{320, 640, 960, 1280, 1920}
+
+// ---- 上傳時的處理 ----
+
+// normalizeImage 重新編碼上傳的圖片：依 EXIF 方向轉正、縮小過大的圖片，並去除 EXIF 等中繼資料。
+// GIF 可能是動畫，逐格重新編碼而不縮放。回傳編碼後的內容、格式與尺寸
+func normalizeImage(file io.ReadSeeker, src image.Image, contentType string) ([]byte, string, image.Point, error) {
+	if contentType == "image/gif" {
+		data, err := reencodeGIF(file)
+		return data, contentType, src.Bounds().Size(), err
+	}
+
+	if contentType == "image/jpeg" {
+		src = applyOrientation(src, jpegOrientation(file))
+	}
+	if src.Bounds().Dx() > maxStoredImageWidth {
+		src = resizeImage(src, maxStoredImageWidth)
+	}
+
+	// PNG 保持無損；WebP 只有無損編碼器，照片重新編碼後會變大，依是否有透明部分轉為 JPEG 或 PNG
+	outType := contentType
+	if contentType == "image/webp" {
+		outType = imageOutputType(src)
+	}
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, src, outType, originalJPEGQuality); err != nil {
+		return nil, "", image.Point{}, err
+	}
+	return buf.Bytes(), outType, src.Bounds().Size(), nil
+}
+
+// reencodeGIF 重新編碼 GIF，只保留畫格、調色盤、延遲與循環次數，去除註解與其他擴充區塊中的中繼資料
+func reencodeGIF(file io.ReadSeeker) ([]byte, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	anim, err := gif.DecodeAll(file)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// imageOutputType 縮圖使用的格式：沒有透明部分時使用檔案較小的 JPEG
+func imageOutputType(img image.Image) string {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// encodeImage 以指定格式編碼圖片，輸出不包含任何中繼資料
+func encodeImage(w io.Writer, img image.Image, contentType string, quality int) error {
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "image/png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		return encoder.Encode(w, img)
+	case "image/webp":
+		// 無損編碼，不使用 quality
+		return nativewebp.Encode(w, img, nil)
+	}
+	return fmt.Errorf("不支援的輸出格式: %s", contentType)
+}
+
+// resizeImage 等比例縮放到指定寬度
+func resizeImage(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	height := max(1, int(math.Round(float64(b.Dy())*float64(width)/float64(b.Dx()))))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, b, xdraw.Src, nil)
+	return dst
+}
+
+// thumbnailImage 從中央裁切正方形並縮放為縮圖
+func thumbnailImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x, y := b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, image.Rect(x, y, x+side, y+side), xdraw.Src, nil)
+	return dst
+}
+
+// jpegOrientation 讀取 JPEG 的 EXIF 方向，沒有或無法解析時回傳 1（不需旋轉）
+func jpegOrientation(file io.ReadSeeker) int {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 1
+	}
+	r := bufio.NewReader(file)
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+		// 影像資料開始後就不會再有 EXIF
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return 1
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 1
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+// exifOrientation 從 EXIF 的 TIFF 結構中讀取 IFD0 的方向標籤
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation 依 EXIF 方向旋轉或翻轉圖片，使其以正確的方向保存
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 水平翻轉
+				dx, dy = w-1-x, y
+			case 3: // 旋轉 180 度
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻轉
+				dx, dy = x, h-1-y
+			case 5: // 沿左上到右下的對角線翻轉
+				dx, dy = y, x
+			case 6: // 順時針旋轉 90 度
+				dx, dy = h-1-y, x
+			case 7: // 沿右上到左下的對角線翻轉
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆時針旋轉 90 度
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], rgba.Pix[rgba.PixOffset(x, y):rgba.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
+
+// ---- 縮圖與不同寬度的版本 ----
+
+// 同時間只產生一個版本，避免大量請求同時解碼大圖耗盡記憶體
+var variantMu sync.Mutex
+
+// imageVariantURL 圖片指定寬度版本的網址
+func imageVariantURL(id uint, size string) string {
+	return db.UploadURLPath + strconv.FormatUint(uint64(id), 10) + "/" + size
+}
+
+// webpSuffix WebP 版本網址的結尾，例如 /uploads/{id}/640.webp
+const webpSuffix = ".webp"
+
+// fullSize 與原圖同寬的 WebP 版本，用於 srcset 中最大的一項
+const fullSize = "full"
+
+// variantTypes 版本檔案可能的格式，webp 為 true 時只有 WebP
+func variantTypes(webp bool) []string {
+	if webp {
+		return []string{"image/webp"}
+	}
+	return []string{"image/jpeg", "image/png"}
+}
+
+// findImageVariant 尋找已快取的版本檔案
+func findImageVariant(id uint, size string, webp bool) (string, string, bool) {
+	for _, contentType := range variantTypes(webp) {
+		file := path.Join(db.ImageVariantDir(id), size+allowedImageTypes[contentType])
+		if _, err := os.Stat(file); err == nil {
+			return file, contentType, true
+		}
+	}
+	return "", "", false
+}
+
+// imageVariant 取得圖片的縮圖或指定寬度的版本，第一次使用時產生並快取在磁碟上。
+// size 為 "thumb"、寬度或 fullSize；webp 為 true 時產生 WebP，否則依是否透明使用 JPEG 或 PNG
+func imageVariant(record obj.Image, size string, width int, webp bool) (string, string, error) {
+	if file, contentType, ok := findImageVariant(record.ID, size, webp); ok {
+		return file, contentType, nil
+	}
+
+	variantMu.Lock()
+	defer variantMu.Unlock()
+	// 等待期間可能已由其他請求產生
+	if file, contentType, ok := findImageVariant(record.ID, size, webp); ok {
+		return file, contentType, nil
+	}
+
+	src, err := decodeStoredImage(record)
+	if err != nil {
+		return "", "", err
+	}
+	switch {
+	case size == "thumb":
+		src = thumbnailImage(src, thumbnailSize)
+	case width < src.Bounds().Dx():
+		src = resizeImage(src, width)
+	}
+	contentType := imageOutputType(src)
+	if webp {
+		contentType = "image/webp"
+	}
+
+	dir := db.ImageVariantDir(record.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	// 先寫入暫存檔再改名，避免其他請求讀到寫到一半的檔案
+	tmp, err := os.CreateTemp(dir, size+"-*.tmp")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = encodeImage(tmp, src, contentType, variantJPEGQuality)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", err
+	}
+	file := path.Join(dir, size+allowedImageTypes[contentType])
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return "", "", err
+	}
+	return file, contentType, nil
+}
+
+// decodeStoredImage 解碼保存的原圖，舊的上傳可能仍帶有 EXIF 方向
+func decodeStoredImage(record obj.Image) (image.Image, error) {
+	f, _, err := db.Uploads.Open(db.UploadKey(record))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	if record.ContentType == "image/jpeg" {
+		src = applyOrientation(src, jpegOrientation(f))
+	}
+	return src, nil
+}
+
+// ensureImageSize 補上舊圖片記錄缺少的像素尺寸
+func ensureImageSize(record obj.Image) obj.Image {
+	if !record.IsImage() || (record.Width > 0 && record.Height > 0) {
+		return record
+	}
+	f, _, err := db.Uploads.Open(db.UploadKey(record))
+	if err != nil {
+		return record
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return record
+	}
+	record.Width, record.Height = config.Width, config.Height
+	if record.ContentType == "image/jpeg" && jpegOrientation(f) >= 5 {
+		record.Width, record.Height = config.Height, config.Width
+	}
+	if err := db.UpdateImageSize(record.ID, record.Width, record.Height); err != nil {
+		log.Println("Error updating image size:", err)
+	}
+	return record
+}
+
+// ImageVariantHandler 提供圖片的縮圖（/uploads/{id}/thumb）與指定寬度的版本（/uploads/{id}/{width}）。
+// 網址加上 .webp 時提供 WebP 版本，/uploads/{id}/full.webp 為與原圖同寬的 WebP
+func ImageVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	size, webp := strings.CutSuffix(r.PathValue("width"), webpSuffix)
+	width, err := strconv.Atoi(size)
+	if size != "thumb" && !(webp && size == fullSize) && (err != nil || !slices.Contains(imageWidths, width)) {
+		http.NotFound(w, r)
+		return
+	}
+	record, err := db.GetImage(uint(id))
+	if err != nil || !record.IsImage() {
+		http.NotFound(w, r)
+		return
+	}
+	record = ensureImageSize(record)
+
+	// 同一個網址的內容不會改變
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	// GIF 動畫與不比原圖小的 JPEG、PNG 版本直接使用原圖；WebP 版本最大為原圖寬度
+	if size != "thumb" && (record.ContentType == "image/gif" || (!webp && width >= record.Width)) {
+		serveUpload(w, r, db.UploadKey(record), record.ContentType, "")
+		return
+	}
+	if webp && (size == fullSize || width >= record.Width) {
+		size, width = fullSize, record.Width
+	}
+	file, contentType, err := imageVariant(record, size, width, false)
+	if err == nil && webp {
+		file, contentType, err = smallerWebPVariant(record, size, width, file, contentType)
+	}
+	if err != nil {
+		log.Printf("Error creating variant %s of image %d: %v", size, record.ID, err)
+		w.Header().Del("Cache-Control")
+		http.Error(w, "圖片處理失敗", http.StatusInternalServerError)
+		return
+	}
+	setUploadHeaders(w, path.Base(file), contentType)
+	http.ServeFile(w, r, file)
+}
+
+// smallerWebPVariant 產生 WebP 版本，並與 JPEG 或 PNG 版本比較後回傳較小的檔案。
+// WebP 只有無損編碼，照片常比 JPEG 大，此時仍提供 JPEG，瀏覽器依實際內容顯示
+func smallerWebPVariant(record obj.Image, size string, width int, file, contentType string) (string, string, error) {
+	webpFile, webpType, err := imageVariant(record, size, width, true)
+	if err != nil {
+		return "", "", err
+	}
+	webpInfo, err := os.Stat(webpFile)
+	if err != nil {
+		return "", "", err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", "", err
+	}
+	if webpInfo.Size() < info.Size() {
+		return webpFile, webpType, nil
+	}
+	return file, contentType, nil
+}
+
+// ---- 文章中的響應式圖片 ----
+
+// lookupUpload 依文章中的圖片網址找出上傳的檔案記錄
+var lookupUpload = func(target string) (obj.Image, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, db.UploadURLPath) {
+		return obj.Image{}, false
+	}
+	record, err := db.GetImageByURL(u.Path)
+	if err != nil {
+		return obj.Image{}, false
+	}
+	return record, true
+}
+
+// imageTransformer 為指向上傳圖片的圖片加上 srcset 與尺寸，讓瀏覽器依螢幕寬度下載適合的版本，
+// 並以 <picture> 提供 WebP 版本。以圖片語法插入的上傳影片替換為影片播放器
+type imageTransformer struct{}
+
+func (t *imageTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var videos, pictures []*ast.Image
+	var videoRecords, pictureRecords []obj.Image
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		img, ok := n.(*ast.Image)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		record, ok := lookupUpload(string(img.Destination))
+		switch {
+		case !ok:
+		case record.Kind == obj.ImageKindVideo:
+			videos = append(videos, img)
+			videoRecords = append(videoRecords, record)
+		case record.IsImage():
+			if record = ensureImageSize(record); record.Width > 0 {
+				setResponsiveImage(img, record)
+				if record.ContentType != "image/gif" {
+					pictures = append(pictures, img)
+					pictureRecords = append(pictureRecords, record)
+				}
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+
+	// 走訪結束後再替換，避免修改正在走訪的樹
+	for i, img := range videos {
+		img.Parent().ReplaceChild(img.Parent(), img, &videoNode{
+			Record: videoRecords[i],
+			Label:  inlineText(img, source),
+			Title:  string(img.Title),
+		})
+	}
+	for i, img := range pictures {
+		picture := &pictureNode{Srcset: webpSrcset(pictureRecords[i])}
+		if sizes, ok := img.AttributeString("sizes"); ok {
+			picture.Sizes = string(sizes.([]byte))
+		}
+		img.Parent().ReplaceChild(img.Parent(), img, picture)
+		picture.AppendChild(picture, img)
+	}
+}
+
+// setResponsiveImage 設定圖片的 srcset、sizes 與寬高，寬高可避免載入時版面跳動
+func setResponsiveImage(img *ast.Image, record obj.Image) {
+	if record.ContentType != "image/gif" {
+		var srcset []string
+		for _, width := range imageWidths {
+			if width < record.Width {
+				srcset = append(srcset, fmt.Sprintf("%s %dw", imageVariantURL(record.ID, strconv.Itoa(width)), width))
+			}
+		}
+		if len(srcset) > 0 {
+			srcset = append(srcset, fmt.Sprintf("%s %dw", record.URL, record.Width))
+			img.SetAttributeString("srcset", []byte(strings.Join(srcset, ", ")))
+			img.SetAttributeString("sizes", []byte(fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", defaultImageWidth, defaultImageWidth)))
+		}
+	}
+	img.SetAttributeString("width", []byte(strconv.Itoa(record.Width)))
+	img.SetAttributeString("height", []byte(strconv.Itoa(record.Height)))
+	img.SetAttributeString("loading", []byte("lazy"))
+	img.SetAttributeString("decoding", []byte("async"))
+}
+
+// webpSrcset 圖片 WebP 版本的 srcset，寬度與 JPEG、PNG 版本相同，最大一項為原圖寬度
+func webpSrcset(record obj.Image) string {
+	var srcset []string
+	for _, width := range imageWidths {
+		if width < record.Width {
+			srcset = append(srcset, fmt.Sprintf("%s %dw", imageVariantURL(record.ID, strconv.Itoa(width)+webpSuffix), width))
+		}
+	}
+	srcset = append(srcset, fmt.Sprintf("%s %dw", imageVariantURL(record.ID, fullSize+webpSuffix), record.Width))
+	return strings.Join(srcset, ", ")
+}
+
+// imageExtension 註冊響應式圖片的轉換器
+type imageExtension struct{}
+
+func (e imageExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(&imageTransformer{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&videoRenderer{}, 500),
+		util.Prioritized(&pictureRenderer{}, 500),
+	))
+}
+
+// ---- 文章中的 WebP 圖片 ----
+
+var kindPicture = ast.NewNodeKind("Picture")
+
+// pictureNode 包住上傳圖片的 <picture>，子節點為原本的圖片，Srcset 與 Sizes 為 WebP 版本的設定
+type pictureNode struct {
+	ast.BaseInline
+	Srcset string
+	Sizes  string
+}
+
+func (n *pictureNode) Kind() ast.NodeKind { return kindPicture }
+
+func (n *pictureNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Srcset": n.Srcset}, nil)
+}
+
+// pictureRenderer 輸出 <picture> 與 WebP 的 <source>，支援 WebP 的瀏覽器使用 WebP 版本，其餘使用原本的圖片
+type pictureRenderer struct{}
+
+func (r *pictureRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindPicture, r.renderPicture)
+}
+
+func (r *pictureRenderer) renderPicture(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		_, _ = w.WriteString("</picture>")
+		return ast.WalkContinue, nil
+	}
+	n := node.(*pictureNode)
+	_, _ = w.WriteString(`<picture><source type="image/webp" srcset="` + html.EscapeString(n.Srcset) + `"`)
+	if n.Sizes != "" {
+		_, _ = w.WriteString(` sizes="` + html.EscapeString(n.Sizes) + `"`)
+	}
+	_, _ = w.WriteString(">")
+	return ast.WalkContinue, nil
+}
+
+// ---- 文章中的影片 ----
+
+var kindVideo = ast.NewNodeKind("Video")
+
+// videoNode 上傳的影片，Label 為圖片語法中的替代文字，Title 為標題
+type videoNode struct {
+	ast.BaseInline
+	Record obj.Image
+	Label  string
+	Title  string
+}
+
+func (n *videoNode) Kind() ast.NodeKind { return kindVideo }
+
+func (n *videoNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"URL": n.Record.URL, "Label": n.Label}, nil)
+}
+
+// inlineText 取得節點內的純文字，用於圖片的替代文字
+func inlineText(n ast.Node, source []byte) string {
+	var sb strings.Builder
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if t, ok := c.(*ast.Text); ok {
+			sb.Write(t.Segment.Value(source))
+		} else {
+			sb.WriteString(inlineText(c, source))
+		}
+	}
+	return sb.String()
+}
+
+// videoRenderer 將影片節點輸出為只載入中繼資料的播放器，不支援的瀏覽器顯示下載連結
+type videoRenderer struct{}
+
+func (r *videoRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindVideo, r.renderVideo)
+}
+
+func (r *videoRenderer) renderVideo(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	n := node.(*videoNode)
+	label := n.Label
+	if label == "" {
+		label = n.Record.Filename
+	}
+	src := html.EscapeString(n.Record.URL)
+	_, _ = w.WriteString(`<video controls preload="metadata" aria-label="` + html.EscapeString(label) + `"`)
+	if n.Title != "" {
+		_, _ = w.WriteString(` title="` + html.EscapeString(n.Title) + `"`)
+	}
+	_, _ = w.WriteString(`><source src="` + src + `" type="` + html.EscapeString(n.Record.ContentType) + `">`)
+	_, _ = w.WriteString(`<a href="` + src + `">` + html.EscapeString(label) + `</a></video>`)
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"unicode/utf8"
+)
+
+// contentHash 計算內容的 SHA-256
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// storedImageHash 計算儲存後端中圖片檔案的 SHA-256
+func storedImageHash(image obj.Image) (string, error) {
+	f, _, err := db.Uploads.Open(db.UploadKey(image))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// findDuplicateImage 尋找內容相同且檔案仍存在的圖片
+func findDuplicateImage(hash string) (obj.Image, bool) {
+	image, err := db.GetImageByHash(hash)
+	if err != nil {
+		return obj.Image{}, false
+	}
+	if _, err := db.Uploads.Stat(db.UploadKey(image)); err != nil {
+		return obj.Image{}, false
+	}
+	return image, true
+}
+
+// replaceImageReferences 將內容中對重複圖片的引用（含縮圖與不同寬度的版本）改為保留的圖片
+func replaceImageReferences(content string, duplicate, kept obj.Image) string {
+	content = strings.ReplaceAll(content, duplicate.URL, kept.URL)
+	variantPrefix := func(id uint) string {
+		return db.UploadURLPath + strconv.FormatUint(uint64(id), 10) + "/"
+	}
+	return strings.ReplaceAll(content, variantPrefix(duplicate.ID), variantPrefix(kept.ID))
+}
+
+// mergeImageMeta 將重複圖片的資訊補到保留的圖片：替代文字與說明只在保留的圖片沒有時補上，標籤則合併，
+// 合併後超過長度限制時保留原本的標籤
+func mergeImageMeta(kept, duplicate obj.Image) (alt, caption, tags string) {
+	alt, caption, tags = kept.Alt, kept.Caption, kept.Tags
+	if alt == "" {
+		alt = duplicate.Alt
+	}
+	if caption == "" {
+		caption = duplicate.Caption
+	}
+	if duplicate.Tags != "" {
+		if merged := normalizeImageTags(tags + "," + duplicate.Tags); utf8.RuneCountInString(merged) <= maxImageTagsLength {
+			tags = merged
+		}
+	}
+	return alt, caption, tags
+}
+
+// DedupeImages 一次性工作：補上既有圖片的 SHA-256，合併內容相同的圖片。
+// 保留最早上傳的一份，文章、內容片段與內容變數值中的引用改為指向保留的圖片，
+// 並將替代文字、說明與標籤補到保留的圖片後，再刪除其餘的記錄與檔案。
+// 回傳刪除的重複圖片數量
+func DedupeImages() (int, error) {
+	images, err := db.GetAllImages()
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].ID < images[j].ID })
+
+	// 依內容分組，第一張為保留的圖片
+	groups := map[string][]obj.Image{}
+	var hashes []string
+	for _, image := range images {
+		if image.ContentHash == "" {
+			hash, err := storedImageHash(image)
+			if err != nil {
+				log.Printf("Skipping image %d (%s): %v", image.ID, image.URL, err)
+				continue
+			}
+			if err := db.UpdateImageHash(image.ID, hash); err != nil {
+				return 0, err
+			}
+			image.ContentHash = hash
+		}
+		if _, ok := groups[image.ContentHash]; !ok {
+			hashes = append(hashes, image.ContentHash)
+		}
+		groups[image.ContentHash] = append(groups[image.ContentHash], image)
+	}
+
+	docs, err := db.GetAllDocs()
+	if err != nil {
+		return 0, err
+	}
+	snippets, err := db.GetSnippetList()
+	if err != nil {
+		return 0, err
+	}
+	variables, err := db.GetVariableList()
+	if err != nil {
+		return 0, err
+	}
+
+	// 先替換所有引用，全部保存後才刪除檔案，中途失敗也不會留下失效的連結
+	docContents, docOriginals := map[uint]string{}, map[uint]string{}
+	snippetContents, snippetOriginals := map[uint]string{}, map[uint]string{}
+	variableValues := map[uint]string{}
+	var merges [][2]obj.Image
+	for _, hash := range hashes {
+		group := groups[hash]
+		kept := group[0]
+		for _, duplicate := range group[1:] {
+			merges = append(merges, [2]obj.Image{duplicate, kept})
+			for _, doc := range docs {
+				content, ok := docContents[doc.ID]
+				if !ok {
+					if content, err = url.QueryUnescape(doc.Content); err != nil {
+						log.Printf("Skipping doc %d: %v", doc.ID, err)
+						continue
+					}
+					docOriginals[doc.ID] = content
+				}
+				docContents[doc.ID] = replaceImageReferences(content, duplicate, kept)
+			}
+			for _, snippet := range snippets {
+				content, ok := snippetContents[snippet.ID]
+				if !ok {
+					if content, err = url.QueryUnescape(snippet.Content); err != nil {
+						log.Printf("Skipping snippet %s: %v", snippet.Name, err)
+						continue
+					}
+					snippetOriginals[snippet.ID] = content
+				}
+				snippetContents[snippet.ID] = replaceImageReferences(content, duplicate, kept)
+			}
+			for _, variable := range variables {
+				value, ok := variableValues[variable.ID]
+				if !ok {
+					value = variable.Value
+				}
+				variableValues[variable.ID] = replaceImageReferences(value, duplicate, kept)
+			}
+		}
+	}
+	for _, doc := range docs {
+		if content, ok := docContents[doc.ID]; ok && content != docOriginals[doc.ID] {
+			if err := db.UpdateDocContent(doc.ID, url.QueryEscape(content)); err != nil {
+				return 0, err
+			}
+			log.Printf("Updated image references in doc %d (%s)", doc.ID, doc.Title)
+		}
+	}
+	for _, snippet := range snippets {
+		if content, ok := snippetContents[snippet.ID]; ok && content != snippetOriginals[snippet.ID] {
+			if err := db.UpdateSnippetContent(snippet.ID, url.QueryEscape(content)); err != nil {
+				return 0, err
+			}
+			log.Printf("Updated image references in snippet %s", snippet.Name)
+		}
+	}
+	for _, variable := range variables {
+		if value, ok := variableValues[variable.ID]; ok && value != variable.Value {
+			if err := db.UpdateVariable(variable.ID, variable.Key, value, variable.Description); err != nil {
+				return 0, err
+			}
+			log.Printf("Updated image references in variable %s", variable.Key)
+		}
+	}
+
+	keptImages := map[uint]obj.Image{} // 保留的圖片目前的資訊，同一張圖片可能合併多張重複圖片
+	for _, merge := range merges {
+		duplicate, kept := merge[0], merge[1]
+		if current, ok := keptImages[kept.ID]; ok {
+			kept = current
+		}
+		alt, caption, tags := mergeImageMeta(kept, duplicate)
+		if alt != kept.Alt || caption != kept.Caption || tags != kept.Tags {
+			if err := db.UpdateImageMeta(kept.ID, alt, caption, tags); err != nil {
+				return 0, err
+			}
+			kept.Alt, kept.Caption, kept.Tags = alt, caption, tags
+		}
+		keptImages[kept.ID] = kept
+
+		if err := db.DeleteImage(duplicate.ID); err != nil {
+			return 0, err
+		}
+		err := db.AddAuditLog(&obj.AuditLog{
+			Username:   "system",
+			Action:     obj.AuditImageMerge,
+			TargetType: "image",
+			TargetID:   duplicate.ID,
+			TargetName: duplicate.Filename,
+			Before:     imageAuditSummary(duplicate),
+			After:      "合併至：" + kept.URL,
+		})
+		if err != nil {
+			log.Println("Error recording audit log:", err)
+		}
+		log.Printf("Merged image %d (%s) into %d (%s)", duplicate.ID, duplicate.URL, kept.ID, kept.URL)
+	}
+	if err := RebuildImageUsage(); err != nil {
+		return len(merges), err
+	}
+	return len(merges), nil
+}
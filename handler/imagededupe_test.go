@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"support/obj"
+)
+
+func TestMergeImageMeta(t *testing.T) {
+	longTags := strings.Repeat("標", maxImageTagsLength-2)
+	tests := []struct {
+		name               string
+		kept, duplicate    obj.Image
+		alt, caption, tags string
+	}{
+		{
+			name:      "補上保留的圖片沒有的資訊",
+			kept:      obj.Image{},
+			duplicate: obj.Image{Alt: "替代", Caption: "說明", Tags: "首頁"},
+			alt:       "替代", caption: "說明", tags: "首頁",
+		},
+		{
+			name:      "不覆蓋保留的圖片既有的資訊",
+			kept:      obj.Image{Alt: "原本", Caption: "原本說明"},
+			duplicate: obj.Image{Alt: "替代", Caption: "說明"},
+			alt:       "原本", caption: "原本說明",
+		},
+		{
+			name:      "合併標籤並去除重複",
+			kept:      obj.Image{Tags: "產品, 首頁"},
+			duplicate: obj.Image{Tags: "首頁，新品"},
+			tags:      "產品, 首頁, 新品",
+		},
+		{
+			name:      "重複圖片沒有標籤",
+			kept:      obj.Image{Tags: "產品,首頁"},
+			duplicate: obj.Image{},
+			tags:      "產品,首頁",
+		},
+		{
+			name:      "合併後超過長度限制時保留原本的標籤",
+			kept:      obj.Image{Tags: longTags},
+			duplicate: obj.Image{Tags: "新品"},
+			tags:      longTags,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alt, caption, tags := mergeImageMeta(tt.kept, tt.duplicate)
+			if alt != tt.alt || caption != tt.caption || tags != tt.tags {
+				t.Errorf("得到 %q %q %q，應為 %q %q %q", alt, caption, tags, tt.alt, tt.caption, tt.tags)
+			}
+		})
+	}
+}
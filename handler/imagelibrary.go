@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"unicode/utf8"
+)
+
+// 圖片列表與圖片資訊的限制
+const (
+	imagePageSize        = 24  // 圖片管理頁面每頁顯示的數量
+	imageLibraryPageSize = 12  // 編輯器中的圖片庫每頁顯示的數量
+	maxImageAltLength    = 200 // 替代文字最多 200 個字符
+	maxImageCaptionLen   = 500 // 說明文字最多 500 個字符
+	maxImageTagsLength   = 200 // 標籤合計最多 200 個字符
+)
+
+// parseImageFilter 從查詢參數取得圖片的搜尋條件與頁碼
+func parseImageFilter(r *http.Request, pageSize int) (obj.ImageFilter, int) {
+	query := r.URL.Query()
+	filter := obj.ImageFilter{
+		Keyword: strings.TrimSpace(query.Get("q")),
+		Sort:    query.Get("sort"),
+		Kind:    query.Get("type"),
+		Limit:   pageSize,
+	}
+	if !slices.ContainsFunc(obj.ImageSorts, func(s obj.ImageSortOption) bool { return s.Value == filter.Sort }) {
+		filter.Sort = obj.ImageSortNewest
+	}
+	if !slices.ContainsFunc(obj.ImageKinds, func(k obj.ImageKindOption) bool { return k.Value == filter.Kind }) {
+		filter.Kind = ""
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	filter.Offset = (page - 1) * pageSize
+	return filter, page
+}
+
+// imageListURL 圖片管理頁面指定頁碼的網址，沿用目前的搜尋條件
+func imageListURL(filter obj.ImageFilter, page int) string {
+	values := url.Values{}
+	if filter.Keyword != "" {
+		values.Set("q", filter.Keyword)
+	}
+	if filter.Sort != obj.ImageSortNewest {
+		values.Set("sort", filter.Sort)
+	}
+	if filter.Kind != "" {
+		values.Set("type", filter.Kind)
+	}
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	if len(values) == 0 {
+		return "/admin/images"
+	}
+	return "/admin/images?" + values.Encode()
+}
+
+// imagesReturnURL 編輯或刪除圖片後要回到的列表頁面，只接受圖片管理頁面的網址
+func imagesReturnURL(r *http.Request) string {
+	target := r.FormValue("return")
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path != "/admin/images" {
+		return "/admin/images"
+	}
+	// 移除上一次的訊息，避免與新的訊息重複
+	query := u.Query()
+	query.Del("message")
+	query.Del("type")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// normalizeImageTags 整理以逗號分隔的標籤，去除空白與重複的標籤
+func normalizeImageTags(input string) string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(input, func(c rune) bool { return c == ',' || c == '，' || c == '、' }) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return strings.Join(tags, ", ")
+}
+
+// checkImageMeta 檢查圖片資訊的長度，不符合時回傳原因
+func checkImageMeta(alt, caption, tags string) string {
+	switch {
+	case utf8.RuneCountInString(alt) > maxImageAltLength:
+		return fmt.Sprintf("替代文字不能超過 %d 個字符", maxImageAltLength)
+	case utf8.RuneCountInString(caption) > maxImageCaptionLen:
+		return fmt.Sprintf("說明文字不能超過 %d 個字符", maxImageCaptionLen)
+	case utf8.RuneCountInString(tags) > maxImageTagsLength:
+		return fmt.Sprintf("標籤不能超過 %d 個字符", maxImageTagsLength)
+	}
+	return ""
+}
+
+// imageMetaSummary 圖片資訊的摘要，用於操作紀錄
+func imageMetaSummary(alt, caption, tags string) string {
+	return fmt.Sprintf("替代文字：%s；說明：%s；標籤：%s", alt, caption, tags)
+}
+
+// AdminImageMetaHandler 編輯圖片的替代文字、說明與標籤
+func AdminImageMetaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/images", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/images", "表單解析錯誤", "danger")
+		return
+	}
+	returnURL := imagesReturnURL(r)
+
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 32)
+	if err != nil {
+		redirectWithMessage(w, r, returnURL, "無效的圖片ID", "danger")
+		return
+	}
+	image, err := db.GetImage(uint(id))
+	if err != nil {
+		log.Println("Error fetching image:", err)
+		redirectWithMessage(w, r, returnURL, "找不到圖片", "danger")
+		return
+	}
+
+	alt := strings.TrimSpace(r.FormValue("alt"))
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	tags := normalizeImageTags(r.FormValue("tags"))
+	if reason := checkImageMeta(alt, caption, tags); reason != "" {
+		redirectWithMessage(w, r, returnURL, reason, "danger")
+		return
+	}
+
+	if err := db.UpdateImageMeta(image.ID, alt, caption, tags); err != nil {
+		log.Println("Error updating image:", err)
+		redirectWithMessage(w, r, returnURL, "更新圖片資訊失敗: "+err.Error(), "danger")
+		return
+	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditImageUpdate,
+		TargetType: "image",
+		TargetID:   image.ID,
+		TargetName: image.Filename,
+		Before:     imageMetaSummary(image.Alt, image.Caption, image.Tags),
+		After:      imageMetaSummary(alt, caption, tags),
+	})
+	redirectWithMessage(w, r, returnURL, "圖片資訊已更新", "success")
+}
+
+// libraryImage 編輯器圖片庫中的一個檔案，圖片以外的檔案沒有縮圖
+type libraryImage struct {
+	ID       uint   `json:"id"`
+	URL      string `json:"url"`
+	Thumb    string `json:"thumb,omitempty"`
+	Kind     string `json:"kind"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Alt      string `json:"alt"`
+	Caption  string `json:"caption"`
+}
+
+// AdminImageLibraryHandler 以 JSON 回應圖片與附件的搜尋結果，供編輯器選擇已上傳的檔案
+func AdminImageLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	filter, page := parseImageFilter(r, imageLibraryPageSize)
+	images, total, err := db.SearchImages(filter)
+	if err != nil {
+		log.Println("Error searching images:", err)
+		http.Error(w, "搜尋圖片失敗", http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]libraryImage, len(images))
+	for i, image := range images {
+		results[i] = libraryImage{
+			ID:       image.ID,
+			URL:      image.URL,
+			Kind:     image.Kind,
+			Filename: image.Filename,
+			Size:     image.Size,
+			Alt:      image.Alt,
+			Caption:  image.Caption,
+		}
+		if image.IsImage() {
+			results[i].Thumb = imageVariantURL(image.ID, "thumb")
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"images":  results,
+		"total":   total,
+		"page":    page,
+		"hasMore": int64(filter.Offset+len(images)) < total,
+	})
+}
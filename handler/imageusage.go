@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"time"
+)
+
+// orphanGracePeriod 剛上傳、還沒儲存到文章中的圖片不算未使用
+const orphanGracePeriod = 24 * time.Hour
+
+// uploadReferencePattern 內容中指向上傳檔案的網址，包含完整網址與相對路徑
+var uploadReferencePattern = regexp.MustCompile(regexp.QuoteMeta(db.UploadURLPath) + "[^\\s\"'()<>\\[\\]{}|\\\\^`]+")
+
+// imageURLIndex 以圖片網址找出圖片 ID
+func imageURLIndex() (map[string]uint, error) {
+	images, err := db.GetAllImages()
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]uint, len(images))
+	for _, image := range images {
+		index[image.URL] = image.ID
+	}
+	return index, nil
+}
+
+// referencedImages 找出內容引用的圖片 ID，包含 /uploads/{id}/{寬度} 形式的縮圖與不同寬度的版本
+func referencedImages(content string, index map[string]uint) []uint {
+	var ids []uint
+	for _, ref := range uploadReferencePattern.FindAllString(content, -1) {
+		if i := strings.IndexAny(ref, "?#"); i >= 0 {
+			ref = ref[:i]
+		}
+		ref = strings.TrimRight(ref, ".,;:!")
+
+		id, ok := index[ref]
+		if !ok {
+			rest := strings.TrimPrefix(ref, db.UploadURLPath)
+			if first, _, found := strings.Cut(rest, "/"); found {
+				if n, err := strconv.ParseUint(first, 10, 32); err == nil {
+					id, ok = uint(n), true
+				}
+			}
+		}
+		if ok && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// updateImageUsage 依儲存後的內容更新文章、內容片段或內容變數引用的圖片，content 為未編碼的內容。
+// 失敗時只記錄錯誤，不影響儲存，下次啟動伺服器時會重新建立索引
+func updateImageUsage(setUsage func(uint, []uint) error, id uint, content string) {
+	index, err := imageURLIndex()
+	if err == nil {
+		err = setUsage(id, referencedImages(content, index))
+	}
+	if err != nil {
+		log.Println("Error updating image usage:", err)
+	}
+}
+
+// updateDocImageUsage 更新文章引用的圖片
+func updateDocImageUsage(docID uint, content string) {
+	updateImageUsage(db.SetDocImageUsage, docID, content)
+}
+
+// updateSnippetImageUsage 更新內容片段引用的圖片
+func updateSnippetImageUsage(snippetID uint, content string) {
+	updateImageUsage(db.SetSnippetImageUsage, snippetID, content)
+}
+
+// updateVariableImageUsage 更新內容變數的值引用的圖片
+func updateVariableImageUsage(variableID uint, value string) {
+	updateImageUsage(db.SetVariableImageUsage, variableID, value)
+}
+
+// RebuildImageUsage 掃描所有文章、內容片段與內容變數，重新建立圖片引用索引。
+// 變數的值會插入引用它的文章，即使沒有文章引用也視為使用中，避免清除後變數指向不存在的檔案
+func RebuildImageUsage() error {
+	index, err := imageURLIndex()
+	if err != nil {
+		return err
+	}
+	docs, err := db.GetAllDocs()
+	if err != nil {
+		return err
+	}
+	snippets, err := db.GetSnippetList()
+	if err != nil {
+		return err
+	}
+	variables, err := db.GetVariableList()
+	if err != nil {
+		return err
+	}
+
+	var usages []obj.ImageUsage
+	for _, doc := range docs {
+		content, err := url.QueryUnescape(doc.Content)
+		if err != nil {
+			continue
+		}
+		for _, id := range referencedImages(content, index) {
+			usages = append(usages, obj.ImageUsage{ImageID: id, DocID: doc.ID})
+		}
+	}
+	for _, snippet := range snippets {
+		content, err := url.QueryUnescape(snippet.Content)
+		if err != nil {
+			continue
+		}
+		for _, id := range referencedImages(content, index) {
+			usages = append(usages, obj.ImageUsage{ImageID: id, SnippetID: snippet.ID})
+		}
+	}
+	for _, variable := range variables {
+		for _, id := range referencedImages(variable.Value, index) {
+			usages = append(usages, obj.ImageUsage{ImageID: id, VariableID: variable.ID})
+		}
+	}
+	return db.ReplaceAllImageUsage(usages)
+}
+
+// imageUsersDescription 列出引用圖片的文章、內容片段與內容變數，用於無法刪除時的訊息
+func imageUsersDescription(docs []obj.Doc, snippets []obj.Snippet, variables []obj.Variable) string {
+	var parts []string
+	if len(docs) > 0 {
+		titles := make([]string, len(docs))
+		for i, doc := range docs {
+			titles[i] = "「" + doc.Title + "」"
+		}
+		parts = append(parts, "文章"+strings.Join(titles, "、"))
+	}
+	if len(snippets) > 0 {
+		names := make([]string, len(snippets))
+		for i, snippet := range snippets {
+			names[i] = "「" + snippet.Name + "」"
+		}
+		parts = append(parts, "內容片段"+strings.Join(names, "、"))
+	}
+	if len(variables) > 0 {
+		keys := make([]string, len(variables))
+		for i, variable := range variables {
+			keys[i] = "「" + variable.Key + "」"
+		}
+		parts = append(parts, "內容變數"+strings.Join(keys, "、"))
+	}
+	return strings.Join(parts, "；")
+}
+
+// unusedImages 可以清除的未使用圖片
+func unusedImages() ([]obj.Image, error) {
+	return db.GetUnusedImages(time.Now().Add(-orphanGracePeriod))
+}
+
+// AdminImageCleanupHandler 刪除沒有被任何文章、內容片段或內容變數引用的圖片
+func AdminImageCleanupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/images", http.StatusSeeOther)
+		return
+	}
+	returnURL := imagesReturnURL(r)
+
+	// 清除前重新建立索引，避免因索引過時而刪除仍在使用的圖片
+	if err := RebuildImageUsage(); err != nil {
+		log.Println("Error rebuilding image usage:", err)
+		redirectWithMessage(w, r, returnURL, "無法檢查圖片使用狀況", "danger")
+		return
+	}
+	images, err := unusedImages()
+	if err != nil {
+		log.Println("Error fetching unused images:", err)
+		redirectWithMessage(w, r, returnURL, "無法檢查圖片使用狀況", "danger")
+		return
+	}
+	if len(images) == 0 {
+		redirectWithMessage(w, r, returnURL, "沒有需要清除的檔案", "info")
+		return
+	}
+
+	deleted := 0
+	for _, image := range images {
+		if err := db.DeleteImage(image.ID); err != nil {
+			log.Printf("Error deleting image %d: %v", image.ID, err)
+			continue
+		}
+		deleted++
+		recordAudit(r, auditEntry{
+			Action:     obj.AuditImageClean,
+			TargetType: "image",
+			TargetID:   image.ID,
+			TargetName: image.Filename,
+			Before:     imageAuditSummary(image),
+		})
+	}
+	invalidateAllRenders()
+
+	if deleted < len(images) {
+		redirectWithMessage(w, r, returnURL,
+			"已清除 "+strconv.Itoa(deleted)+" 個未使用的檔案，"+strconv.Itoa(len(images)-deleted)+" 個刪除失敗", "warning")
+		return
+	}
+	redirectWithMessage(w, r, returnURL, "已清除 "+strconv.Itoa(deleted)+" 個未使用的檔案", "success")
+}
@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// 定期檢查已發布文章連結的間隔
+const LinkCheckInterval = 6 * time.Hour
+
+// docLink 文章中的站內連結或圖片引用
+type docLink struct {
+	Target  string // 原始網址
+	IsImage bool
+	DocID   uint   // 指向文章時的文章 ID，ID 無效時為 0
+	Upload  string // 指向上傳檔案時的網址路徑
+}
+
+// collectLinks 走訪 AST，收集所有指向站內文章或上傳檔案的連結與圖片
+func collectLinks(root ast.Node) []docLink {
+	var links []docLink
+	_ = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch v := n.(type) {
+		case *ast.Link:
+			if link, ok := resolveLink(string(v.Destination), false); ok {
+				links = append(links, link)
+			}
+		case *ast.Image:
+			if link, ok := resolveLink(string(v.Destination), true); ok {
+				links = append(links, link)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return links
+}
+
+// resolveLink 解析連結網址，僅回傳指向站內文章或上傳檔案的連結
+func resolveLink(target string, isImage bool) (docLink, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return docLink{}, false
+	}
+
+	link := docLink{Target: target, IsImage: isImage}
+	p := "/" + strings.TrimPrefix(u.Path, "/")
+	switch {
+	case p == "/doc" || p == "/doc.php":
+		// 與 DocHandler 相同，忽略 ID 後面多餘的參數
+		idStr := u.Query().Get("id")
+		if idx := strings.IndexAny(idStr, "?&"); idx > 0 {
+			idStr = idStr[:idx]
+		}
+		if id, err := strconv.ParseUint(idStr, 10, 32); err == nil {
+			link.DocID = uint(id)
+		}
+	case strings.HasPrefix(p, db.UploadURLPath):
+		link.Upload = p
+	default:
+		return docLink{}, false
+	}
+	return link, true
+}
+
+// linkChecker 檢查連結目標是否存在，並快取同一次檢查中的查詢結果
+type linkChecker struct {
+	docs    map[uint]*obj.Doc // nil 表示文章不存在
+	uploads map[string]bool
+	now     time.Time
+}
+
+func newLinkChecker() *linkChecker {
+	return &linkChecker{
+		docs:    map[uint]*obj.Doc{},
+		uploads: map[string]bool{},
+		now:     time.Now(),
+	}
+}
+
+// check 檢查一篇文章的所有連結，回傳發現的問題
+func (c *linkChecker) check(doc obj.Doc, links []docLink) []obj.LinkIssue {
+	var issues []obj.LinkIssue
+	for _, link := range links {
+		kind := c.problem(link)
+		if kind == "" {
+			continue
+		}
+		issues = append(issues, obj.LinkIssue{
+			DocID:     doc.ID,
+			DocTitle:  doc.Title,
+			Target:    link.Target,
+			IsImage:   link.IsImage,
+			Kind:      kind,
+			CheckedAt: c.now,
+		})
+	}
+	return issues
+}
+
+// problem 回傳連結的問題類型，連結正常時回傳空字串
+func (c *linkChecker) problem(link docLink) string {
+	if link.Upload != "" {
+		ok, cached := c.uploads[link.Upload]
+		if !cached {
+			ok = uploadExists(link.Upload)
+			c.uploads[link.Upload] = ok
+		}
+		if !ok {
+			return obj.LinkIssueMissingImage
+		}
+		return ""
+	}
+
+	if link.DocID == 0 {
+		return obj.LinkIssueBrokenDoc
+	}
+	target, cached := c.docs[link.DocID]
+	if !cached {
+		if doc, err := db.GetDoc(link.DocID); err == nil {
+			target = &doc
+		}
+		c.docs[link.DocID] = target
+	}
+	switch {
+	case target == nil:
+		return obj.LinkIssueBrokenDoc
+	case target.IsDraft:
+		return obj.LinkIssueDraftDoc
+	}
+	return ""
+}
+
+// uploadExists 檢查上傳檔案的記錄與實體檔案是否都存在
+func uploadExists(urlPath string) bool {
+	// 縮圖與不同寬度的版本（/uploads/{id}/{width}）只要原圖存在即可
+	if parts := strings.Split(strings.TrimPrefix(urlPath, db.UploadURLPath), "/"); len(parts) == 2 {
+		id, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return false
+		}
+		image, err := db.GetImage(uint(id))
+		if err != nil {
+			return false
+		}
+		_, err = db.Uploads.Stat(db.UploadKey(image))
+		return err == nil
+	}
+
+	image, err := db.GetImageByURL(urlPath)
+	if err != nil {
+		return false
+	}
+	_, err = db.Uploads.Stat(db.UploadKey(image))
+	return err == nil
+}
+
+// 避免定期檢查與手動檢查同時執行
+var linkCheck struct {
+	sync.Mutex
+	lastRun time.Time
+}
+
+// RunLinkCheck 檢查所有已發布文章的站內連結與圖片，並保存檢查結果
+func RunLinkCheck() ([]obj.LinkIssue, error) {
+	linkCheck.Lock()
+	defer linkCheck.Unlock()
+
+	docs, err := db.GetPublishedDocs()
+	if err != nil {
+		return nil, err
+	}
+
+	checker := newLinkChecker()
+	var issues []obj.LinkIssue
+	for _, doc := range docs {
+		// 透過渲染流程取得連結，內容片段中的連結也會一併檢查
+		rendered, err := renderDoc(doc)
+		if err != nil {
+			log.Printf("Link check: render doc %d error: %v", doc.ID, err)
+			continue
+		}
+		issues = append(issues, checker.check(doc, rendered.Links)...)
+	}
+
+	err = db.ReplaceLinkIssues(issues)
+	if err != nil {
+		return nil, err
+	}
+	linkCheck.lastRun = checker.now
+	return issues, nil
+}
+
+// StartLinkChecker 在背景定期執行連結檢查，啟動時會先執行一次
+func StartLinkChecker() {
+	go func() {
+		for {
+			issues, err := RunLinkCheck()
+			if err != nil {
+				log.Println("Link check error:", err)
+			} else {
+				log.Printf("Link check finished: %d issues found", len(issues))
+			}
+			time.Sleep(LinkCheckInterval)
+		}
+	}()
+}
+
+// AdminLinkHealthHandler 處理連結健康報告頁面
+func AdminLinkHealthHandler(w http.ResponseWriter, r *http.Request) {
+	// 獲取當前的管理員會話
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 從資料庫獲取最近一次的檢查結果
+	issues, err := db.GetLinkIssues()
+	if err != nil {
+		log.Println("Error fetching link issues:", err)
+	}
+
+	linkCheck.Lock()
+	lastRun := linkCheck.lastRun
+	linkCheck.Unlock()
+
+	// 檢查是否有訊息要顯示
+	message := r.URL.Query().Get("message")
+	messageType := r.URL.Query().Get("type")
+	if messageType == "" && message != "" {
+		messageType = "success" // 預設訊息類型
+	}
+
+	// 準備模板資料
+	data := map[string]interface{}{
+		"Active":      "link_health",
+		"Issues":      issues,
+		"LastRun":     lastRun,
+		"Message":     message,
+		"MessageType": messageType,
+		"Username":    session.Username,
+		"CSRFToken":   csrfToken(r),
+	}
+
+	// 解析模板
+	tmpl, err := template.ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/link_health.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminLinkCheckHandler 處理手動執行連結檢查
+func AdminLinkCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/link-health", http.StatusSeeOther)
+		return
+	}
+
+	issues, err := RunLinkCheck()
+	if err != nil {
+		log.Println("Link check error:", err)
+		redirectWithMessage(w, r, "/admin/link-health", "連結檢查失敗: "+err.Error(), "danger")
+		return
+	}
+
+	if len(issues) == 0 {
+		redirectWithMessage(w, r, "/admin/link-health", "檢查完成，沒有發現失效連結", "success")
+		return
+	}
+	redirectWithMessage(w, r, "/admin/link-health", "檢查完成，發現 "+strconv.Itoa(len(issues))+" 個問題", "warning")
+}
@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"support/db"
+	"support/obj"
+	"sync"
+	"time"
+)
+
+// ---- 登入失敗次數限制 ----
+
+// 登入限制的預設值，可在系統設定頁面調整
+const (
+	defaultLoginMaxFailures   = 5
+	defaultLoginMaxIPFailures = 20
+	defaultLoginLockMinutes   = 15
+	defaultLoginBackoffSecond = 1
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errUserDisabled       = errors.New("user disabled")
+)
+
+// loginLimits 登入嘗試的限制設定
+type loginLimits struct {
+	MaxFailures   int           // 同一帳號連續失敗幾次後鎖定
+	MaxIPFailures int           // 同一 IP 在鎖定時間內失敗幾次後鎖定
+	LockDuration  time.Duration // 鎖定時間，也是計算失敗次數的時間範圍
+	Backoff       time.Duration // 失敗後的基本等待時間，每次失敗加倍
+}
+
+// settingInt 讀取整數設定，未設定或格式錯誤時回傳預設值
+func settingInt(key string, defaultValue int) int {
+	n, err := strconv.Atoi(db.GetSetting(key, ""))
+	if err != nil || n < 0 {
+		return defaultValue
+	}
+	return n
+}
+
+// getLoginLimits 取得目前的登入限制設定
+func getLoginLimits() loginLimits {
+	return loginLimits{
+		MaxFailures:   max(settingInt(obj.SettingLoginMaxFailures, defaultLoginMaxFailures), 1),
+		MaxIPFailures: max(settingInt(obj.SettingLoginMaxIPFailures, defaultLoginMaxIPFailures), 1),
+		LockDuration:  time.Duration(max(settingInt(obj.SettingLoginLockMinutes, defaultLoginLockMinutes), 1)) * time.Minute,
+		Backoff:       time.Duration(settingInt(obj.SettingLoginBackoffSecond, defaultLoginBackoffSecond)) * time.Second,
+	}
+}
+
+// loginThrottledError 登入嘗試被暫時拒絕
+type loginThrottledError struct {
+	Wait   time.Duration // 需要再等待的時間
+	Locked bool          // 已達失敗上限而鎖定，否則為失敗後的等待時間
+}
+
+func (e *loginThrottledError) Error() string {
+	return fmt.Sprintf("login throttled for %s", e.Wait)
+}
+
+// Message 顯示給使用者的訊息
+func (e *loginThrottledError) Message() string {
+	if e.Locked {
+		minutes := int(e.Wait.Minutes()) + 1
+		return fmt.Sprintf("登入失敗次數過多，已暫時鎖定，請於 %d 分鐘後再試", minutes)
+	}
+	seconds := int(e.Wait.Seconds()) + 1
+	return fmt.Sprintf("嘗試過於頻繁，請於 %d 秒後再試", seconds)
+}
+
+// RetryAfter Retry-After 標頭的秒數
+func (e *loginThrottledError) RetryAfter() string {
+	return strconv.Itoa(int(e.Wait.Seconds()) + 1)
+}
+
+// consecutiveFailures 計算最近連續失敗的次數與最後一次失敗的時間，遇到成功的登入即停止
+func consecutiveFailures(attempts []obj.LoginAttempt) (int, time.Time) {
+	var last time.Time
+	count := 0
+	for _, a := range attempts {
+		if a.Success {
+			break
+		}
+		if count == 0 {
+			last = a.CreateTime
+		}
+		count++
+	}
+	return count, last
+}
+
+// backoffWait 第 count 次失敗後需等待的時間，由 backoff 起逐次加倍，最多為 limit
+func backoffWait(backoff time.Duration, count int, limit time.Duration) time.Duration {
+	wait := backoff
+	for i := 1; i < count && wait < limit; i++ {
+		wait <<= 1
+	}
+	return min(wait, limit)
+}
+
+// checkLoginAllowed 檢查帳號與 IP 目前是否允許嘗試登入
+func checkLoginAllowed(username, ip string) error {
+	limits := getLoginLimits()
+	now := time.Now()
+	since := now.Add(-limits.LockDuration)
+
+	// 帳號：失敗後等待時間逐次加倍，達到上限後鎖定
+	attempts, err := db.GetRecentUserLoginAttempts(username, since, limits.MaxFailures)
+	if err != nil {
+		return err
+	}
+	count, last := consecutiveFailures(attempts)
+	if count >= limits.MaxFailures {
+		return &loginThrottledError{Wait: last.Add(limits.LockDuration).Sub(now), Locked: true}
+	}
+	if count > 0 && limits.Backoff > 0 {
+		wait := backoffWait(limits.Backoff, count, limits.LockDuration)
+		if remaining := last.Add(wait).Sub(now); remaining > 0 {
+			return &loginThrottledError{Wait: remaining}
+		}
+	}
+
+	// IP：計算鎖定時間內所有的失敗，中間成功的登入不會重置次數，避免以其他帳號登入來規避限制
+	failures, err := db.GetRecentIPLoginFailures(ip, since, limits.MaxIPFailures)
+	if err != nil {
+		return err
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	count, last = len(failures), failures[0].CreateTime
+	if count >= limits.MaxIPFailures {
+		return &loginThrottledError{Wait: last.Add(limits.LockDuration).Sub(now), Locked: true}
+	}
+	if limits.Backoff > 0 {
+		wait := backoffWait(limits.Backoff, count, limits.LockDuration)
+		if remaining := last.Add(wait).Sub(now); remaining > 0 {
+			return &loginThrottledError{Wait: remaining}
+		}
+	}
+	return nil
+}
+
+// recordLoginAttempt 記錄一次登入嘗試
+func recordLoginAttempt(r *http.Request, username string, success, blocked bool, reason string) {
+	attempt := obj.LoginAttempt{
+		Username:  username,
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+		Success:   success,
+		Blocked:   blocked,
+		Reason:    reason,
+	}
+	if err := db.AddLoginAttempt(&attempt); err != nil {
+		log.Println("Error recording login attempt:", err)
+	}
+	if !success {
+		log.Printf("Login failed: %s from %s (%s)", username, attempt.IPAddress, reason)
+	}
+}
+
+// authenticatePassword 驗證帳號密碼並套用失敗次數限制，所有登入方式都應透過此函數驗證。
+// 失敗會自動記錄；成功時不記錄，由呼叫端在完成所有驗證步驟後呼叫 recordLoginAttempt，
+// 避免已知密碼的人藉由重新登入重置兩步驟驗證的失敗次數。
+func authenticatePassword(r *http.Request, username, password string) (obj.User, error) {
+	if err := checkLoginAllowed(username, clientIP(r)); err != nil {
+		var throttled *loginThrottledError
+		if errors.As(err, &throttled) {
+			reason := "嘗試過於頻繁"
+			if throttled.Locked {
+				reason = "已鎖定"
+			}
+			recordLoginAttempt(r, username, false, true, reason)
+		}
+		return obj.User{}, err
+	}
+
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		// 帳號不存在時仍計算雜湊，避免從回應時間判斷帳號是否存在
+		db.VerifyPassword(dummyPasswordHash(), password)
+		recordLoginAttempt(r, username, false, false, "帳號不存在")
+		return obj.User{}, errInvalidCredentials
+	}
+	if !db.VerifyPassword(user.Password, password) {
+		recordLoginAttempt(r, username, false, false, "密碼錯誤")
+		return obj.User{}, errInvalidCredentials
+	}
+	if user.Disabled {
+		recordLoginAttempt(r, username, false, false, "帳號已停用")
+		return obj.User{}, errUserDisabled
+	}
+	upgradePasswordHash(user, password)
+	return user, nil
+}
+
+// upgradePasswordHash 密碼驗證成功後，舊演算法或較弱參數的雜湊值改用目前的設定重新儲存
+func upgradePasswordHash(user obj.User, password string) {
+	if !db.NeedsRehash(user.Password) {
+		return
+	}
+	// 改用 bcrypt 後，超過長度上限的舊密碼維持原本的雜湊值
+	if maxBytes := db.MaxPasswordBytes(); maxBytes > 0 && len(password) > maxBytes {
+		return
+	}
+	hash, err := db.HashPassword(password)
+	if err == nil {
+		err = db.UpgradePasswordHash(user.ID, user.Password, hash)
+	}
+	if err != nil {
+		log.Printf("Error upgrading password hash for %s: %v", user.Username, err)
+	}
+}
+
+// dummyPasswordHash 帳號不存在時用來比對的雜湊值，第一次使用時才計算
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := db.HashPassword("dummy password for timing")
+	return hash
+})
+
+// showLoginFailure 依驗證錯誤顯示對應的登入錯誤訊息
+func showLoginFailure(w http.ResponseWriter, r *http.Request, err error) {
+	var throttled *loginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", throttled.RetryAfter())
+		w.WriteHeader(http.StatusTooManyRequests)
+		showLoginError(w, r, throttled.Message())
+	case errors.Is(err, errInvalidCredentials):
+		showLoginError(w, r, "用戶名或密碼錯誤")
+	case errors.Is(err, errUserDisabled):
+		showLoginError(w, r, "此帳號已停用")
+	default:
+		log.Println("Login error:", err)
+		showLoginError(w, r, "登入失敗，請稍後再試")
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"support/db"
+	"text/template"
+)
+
+// 登入紀錄頁面最多顯示的筆數
+const loginAttemptsPageLimit = 200
+
+// AdminLoginAttemptsHandler 處理登入失敗紀錄頁面
+func AdminLoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 可依帳號或 IP 過濾
+	keyword := strings.TrimSpace(r.URL.Query().Get("q"))
+	attempts, err := db.GetLoginFailures(keyword, loginAttemptsPageLimit)
+	if err != nil {
+		log.Println("Error fetching login attempts:", err)
+	}
+
+	// 準備模板資料
+	data := map[string]interface{}{
+		"Active":    "login_attempts",
+		"Attempts":  attempts,
+		"Keyword":   keyword,
+		"Limit":     loginAttemptsPageLimit,
+		"Username":  session.Username,
+		"CSRFToken": csrfToken(r),
+	}
+
+	// 解析模板
+	tmpl, err := template.ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/login_attempts.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const smtpTimeout = 15 * time.Second // 連線到 SMTP 伺服器的逾時時間
+
+// MailConfig 寄信用的 SMTP 設定
+type MailConfig struct {
+	Host     string
+	Port     int    // 465 使用 TLS 連線，其他連接埠在伺服器支援時使用 STARTTLS
+	Username string // 留空表示不需要登入，例如本機的測試信箱
+	Password string
+	From     string // 寄件者，例如「支援中心 <noreply@example.com>」
+	BaseURL  string // 網站網址，用於產生信件中的連結，不可取自請求的 Host 標頭
+}
+
+var mailConfig *MailConfig // 未設定 SMTP 時為 nil
+
+// ConfigureMail 啟用寄信功能，應在伺服器啟動時呼叫
+func ConfigureMail(cfg MailConfig) error {
+	if cfg.Host == "" {
+		return errors.New("SMTP 主機為必填")
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return fmt.Errorf("無效的 SMTP 連接埠: %d", cfg.Port)
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return fmt.Errorf("無效的寄件者: %s", cfg.From)
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if u, err := url.Parse(cfg.BaseURL); err != nil || !u.IsAbs() {
+		return errors.New("網站網址必須是完整網址，例如 https://support.hazelnut-paradise.com")
+	}
+	mailConfig = &cfg
+	return nil
+}
+
+// mailEnabled 是否已設定寄信
+func mailEnabled() bool {
+	return mailConfig != nil
+}
+
+// buildMail 組成純文字信件，標題與內文皆以 UTF-8 編碼
+func buildMail(from, to, subject, body string) ([]byte, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, err
+	}
+	domain := sender.Address[strings.LastIndex(sender.Address, "@")+1:]
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", sender.String()) // 寄件者名稱含中文時需經過編碼
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// sendMail 透過設定的 SMTP 伺服器寄出純文字信件
+func sendMail(to, subject, body string) error {
+	if mailConfig == nil {
+		return errors.New("尚未設定 SMTP")
+	}
+	from, err := mail.ParseAddress(mailConfig.From)
+	if err != nil {
+		return err
+	}
+	msg, err := buildMail(mailConfig.From, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(mailConfig.Host, strconv.Itoa(mailConfig.Port))
+	var conn net.Conn
+	if mailConfig.Port == 465 {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", addr, &tls.Config{ServerName: mailConfig.Host})
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, mailConfig.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && mailConfig.Port != 465 {
+		if err := client.StartTLS(&tls.Config{ServerName: mailConfig.Host}); err != nil {
+			return err
+		}
+	}
+	if mailConfig.Username != "" {
+		// PlainAuth 只允許在 TLS 連線或本機上送出密碼
+		auth := smtp.PlainAuth("", mailConfig.Username, mailConfig.Password, mailConfig.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpSink 本機的測試信箱，只實作寄信需要的 SMTP 指令並保存收到的信件，
+// 與 MailHog 等工具相同，不需要登入也不支援 STARTTLS
+type smtpSink struct {
+	listener net.Listener
+	auth     bool // 是否宣告支援 AUTH PLAIN
+
+	mu         sync.Mutex
+	from       string
+	recipients []string
+	data       string
+	credential string // AUTH PLAIN 收到的帳號密碼，格式為 帳號:密碼
+	done       chan struct{}
+}
+
+// newSMTPSink 啟動測試信箱並以它設定寄信，測試結束時還原設定
+func newSMTPSink(t *testing.T, auth bool, username, password string) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &smtpSink{listener: listener, auth: auth, done: make(chan struct{})}
+	go sink.serve()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	if err := ConfigureMail(MailConfig{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: username,
+		Password: password,
+		From:     "支援中心 <noreply@example.com>",
+		BaseURL:  "https://support.example.com/",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		mailConfig = nil
+	})
+	return sink
+}
+
+func (s *smtpSink) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer close(s.done)
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			if s.auth {
+				reply("250-sink")
+				reply("250 AUTH PLAIN")
+			} else {
+				reply("250 sink")
+			}
+		case strings.HasPrefix(cmd, "AUTH PLAIN "):
+			// 格式為 authzid\0帳號\0密碼
+			raw, _ := base64.StdEncoding.DecodeString(line[len("AUTH PLAIN "):])
+			parts := strings.Split(string(raw), "\x00")
+			s.mu.Lock()
+			if len(parts) == 3 {
+				s.credential = parts[1] + ":" + parts[2]
+			}
+			s.mu.Unlock()
+			reply("235 ok")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			s.mu.Lock()
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			s.mu.Unlock()
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpt := strings.Trim(line[len("RCPT TO:"):], "<>")
+			if strings.HasSuffix(rcpt, "@invalid.example") {
+				reply("550 no such user")
+				continue
+			}
+			s.mu.Lock()
+			s.recipients = append(s.recipients, rcpt)
+			s.mu.Unlock()
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, ".")) // 還原 dot-stuffing
+			}
+			s.mu.Lock()
+			s.data = data.String()
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// message 等待連線結束後解析收到的信件
+func (s *smtpSink) message(t *testing.T) *mail.Message {
+	t.Helper()
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, err := mail.ReadMessage(strings.NewReader(s.data))
+	if err != nil {
+		t.Fatalf("無法解析信件: %v\n%s", err, s.data)
+	}
+	return msg
+}
+
+func TestSendMailToLocalSink(t *testing.T) {
+	sink := newSMTPSink(t, false, "", "")
+	body := "請點選以下連結重設密碼：\nhttps://support.example.com/admin/reset-password?token=abc=def\n\n." // 最後一行只有句點，需經過 dot-stuffing
+	if err := sendMail("alice@example.com", "重設密碼", body); err != nil {
+		t.Fatal(err)
+	}
+	msg := sink.message(t)
+
+	if sink.from != "noreply@example.com" {
+		t.Errorf("MAIL FROM = %q", sink.from)
+	}
+	if len(sink.recipients) != 1 || sink.recipients[0] != "alice@example.com" {
+		t.Errorf("RCPT TO = %v", sink.recipients)
+	}
+	if sink.credential != "" {
+		t.Error("未設定帳號時不應登入")
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "重設密碼" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Name != "支援中心" || from[0].Address != "noreply@example.com" {
+		t.Errorf("From = %v (%v)", from, err)
+	}
+	if msg.Header.Get("Message-Id") == "" || !strings.HasSuffix(msg.Header.Get("Message-Id"), "@example.com>") {
+		t.Errorf("Message-ID = %q", msg.Header.Get("Message-Id"))
+	}
+	if msg.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+		t.Errorf("Content-Transfer-Encoding = %q", msg.Header.Get("Content-Transfer-Encoding"))
+	}
+
+	// net/mail 不會解碼 quoted-printable
+	decoded, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DATA 結尾會補上換行
+	if want := strings.ReplaceAll(body, "\n", "\r\n") + "\r\n"; string(decoded) != want {
+		t.Errorf("內文 = %q，應為 %q", decoded, want)
+	}
+}
+
+func TestSendMailAuth(t *testing.T) {
+	// 連到本機時 PlainAuth 允許在未加密的連線上送出密碼
+	sink := newSMTPSink(t, true, "noreply@example.com", "p@ss")
+	if err := sendMail("bob@example.com", "測試", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	sink.message(t)
+	if sink.credential != "noreply@example.com:p@ss" {
+		t.Errorf("AUTH PLAIN = %q", sink.credential)
+	}
+}
+
+func TestSendMailRejectedRecipient(t *testing.T) {
+	newSMTPSink(t, false, "", "")
+	if err := sendMail("nobody@invalid.example", "測試", "hello"); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("收件者被拒絕時應回傳錯誤，err = %v", err)
+	}
+}
+
+func TestConfigureMail(t *testing.T) {
+	t.Cleanup(func() { mailConfig = nil })
+	tests := []struct {
+		name string
+		cfg  MailConfig
+		ok   bool
+	}{
+		{"本機測試信箱", MailConfig{Host: "localhost", Port: 1025, From: "noreply@example.com", BaseURL: "http://localhost:3000"}, true},
+		{"缺少主機", MailConfig{Port: 1025, From: "noreply@example.com", BaseURL: "http://localhost:3000"}, false},
+		{"連接埠錯誤", MailConfig{Host: "localhost", Port: 70000, From: "noreply@example.com", BaseURL: "http://localhost:3000"}, false},
+		{"寄件者錯誤", MailConfig{Host: "localhost", Port: 1025, From: "noreply", BaseURL: "http://localhost:3000"}, false},
+		{"網站網址不完整", MailConfig{Host: "localhost", Port: 1025, From: "noreply@example.com", BaseURL: "/support"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailConfig = nil
+			err := ConfigureMail(tt.cfg)
+			if (err == nil) != tt.ok {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+	if err := ConfigureMail(MailConfig{Host: "localhost", Port: 1025, From: "noreply@example.com", BaseURL: "http://localhost:3000/"}); err != nil {
+		t.Fatal(err)
+	}
+	if mailConfig.BaseURL != "http://localhost:3000" {
+		t.Errorf("BaseURL = %q，結尾的斜線應被移除", mailConfig.BaseURL)
+	}
+}
@@ -0,0 +1,847 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// ---- Markdown 數學公式語法 ----
+// 行內公式使用 $...$，獨立公式使用 $$...$$，於伺服器端轉為 MathML，讀者不需載入任何外部腳本
+
+var kindMath = ast.NewNodeKind("Math")
+var kindMathBlock = ast.NewNodeKind("MathBlock")
+
+// mathInline 行內公式節點
+type mathInline struct {
+	ast.BaseInline
+	Source  string
+	Display bool // 以 $$...$$ 寫在段落中的公式
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMath }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Source": n.Source}, nil)
+}
+
+// mathBlock 獨立成段的公式節點
+type mathBlock struct {
+	ast.BaseBlock
+	Source []byte
+	closed bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Source": string(n.Source)}, nil)
+}
+
+// mathInlineParser 解析 $...$ 與段落中的 $$...$$
+// 與 Pandoc 相同，開頭 $ 後與結尾 $ 前不能是空白，結尾 $ 後不能緊接數字，避免誤判 "$5 到 $10"
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+
+	if bytes.HasPrefix(line, []byte("$$")) {
+		end := bytes.Index(line[2:], []byte("$$"))
+		if end <= 0 {
+			return nil
+		}
+		block.Advance(end + 4)
+		return &mathInline{Source: string(line[2 : end+2]), Display: true}
+	}
+
+	if len(line) < 3 || unicode.IsSpace(rune(line[1])) {
+		return nil
+	}
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++ // 跳過跳脫字元，例如 \$
+		case '$':
+			if unicode.IsSpace(rune(line[i-1])) {
+				return nil
+			}
+			if i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9' {
+				return nil
+			}
+			block.Advance(i + 1)
+			return &mathInline{Source: string(line[1:i])}
+		case '\n':
+			return nil
+		}
+	}
+	return nil
+}
+
+// mathBlockParser 解析以 $$ 開頭的獨立公式區塊，結尾的 $$ 必須在下一個空行之前
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+
+	node := &mathBlock{}
+	rest := line[pos+2:]
+	if end := bytes.Index(rest, []byte("$$")); end >= 0 {
+		// 單行的 $$...$$，結尾後面不能有其他內容
+		if !util.IsBlank(rest[end+2:]) {
+			return nil, parser.NoChildren
+		}
+		node.Source = append(node.Source, rest[:end]...)
+		node.closed = true
+	} else {
+		// 沒有結尾 $$ 時不視為公式區塊，以一般段落顯示，避免吞掉之後的整篇文章
+		if !hasMathBlockEnd(reader) {
+			return nil, parser.NoChildren
+		}
+		node.Source = append(node.Source, rest...)
+	}
+	reader.Advance(segment.Len() - 1)
+	return node, parser.NoChildren
+}
+
+// hasMathBlockEnd 往下找結尾的 $$，公式中不能有空行，遇到空行或文件結尾即視為未結束
+func hasMathBlockEnd(reader text.Reader) bool {
+	line, segment := reader.Position()
+	defer reader.SetPosition(line, segment)
+
+	reader.AdvanceLine()
+	for {
+		next, _ := reader.PeekLine()
+		if next == nil || util.IsBlank(next) {
+			return false
+		}
+		if bytes.Contains(next, []byte("$$")) {
+			return true
+		}
+		reader.AdvanceLine()
+	}
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*mathBlock)
+	if n.closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	if line == nil {
+		return parser.Close
+	}
+	if end := bytes.Index(line, []byte("$$")); end >= 0 {
+		n.Source = append(n.Source, line[:end]...)
+		n.closed = true
+		reader.Advance(segment.Len() - 1)
+		return parser.Continue | parser.NoChildren
+	}
+	n.Source = append(n.Source, line...)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// mathExtension 註冊數學公式的解析器與渲染器
+type mathExtension struct{}
+
+func (e mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 750)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&mathRenderer{}, 500)))
+}
+
+// mathRenderer 將公式節點輸出為 MathML
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, r.renderInline)
+	reg.Register(kindMathBlock, r.renderBlock)
+}
+
+func (r *mathRenderer) renderInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*mathInline)
+		_, _ = w.WriteString(renderMath(n.Source, n.Display))
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*mathBlock)
+		_, _ = w.WriteString(`<div class="math-block">`)
+		_, _ = w.WriteString(renderMath(string(n.Source), true))
+		_, _ = w.WriteString("</div>\n")
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// renderMath 將 TeX 公式轉為 MathML，結果依內容雜湊快取
+// 無法解析的公式原樣顯示並標示錯誤
+func renderMath(src string, display bool) string {
+	kind := "math-inline"
+	if display {
+		kind = "math-display"
+	}
+	return cachedFragment(kind, src, func() string {
+		mathml, err := texToMathML(src, display)
+		if err != nil {
+			return `<code class="math-error" title="` + html.EscapeString(err.Error()) + `">` +
+				html.EscapeString(src) + `</code>`
+		}
+		return mathml
+	})
+}
+
+// ---- TeX → MathML 轉換 ----
+// 支援常用的 LaTeX 數學子集：上下標、分數、根號、希臘字母與常用符號、
+// 重音符號、\left \right 括號、\text 以及 matrix / cases / aligned 等環境
+
+// texToMathML 將 TeX 公式轉為 MathML 元素
+func texToMathML(src string, display bool) (string, error) {
+	p := &texParser{tokens: tokenizeTeX(src), display: display}
+	var body string
+	for {
+		list, err := p.parseList()
+		if err != nil {
+			return "", err
+		}
+		body += list
+
+		// 環境外的換行與對齊符號：換行輸出為斷行，對齊符號忽略
+		tok := p.peek()
+		if tok == `\\` {
+			body += `<mspace linebreak="newline"/>`
+		} else if tok != "&" {
+			break
+		}
+		p.next()
+	}
+	if !p.eof() {
+		return "", fmt.Errorf("多餘的 %s", p.peek())
+	}
+
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return `<math xmlns="http://www.w3.org/1998/Math/MathML" display="` + mode + `"><semantics><mrow>` +
+		body + `</mrow><annotation encoding="application/x-tex">` + html.EscapeString(src) +
+		`</annotation></semantics></math>`, nil
+}
+
+// tokenizeTeX 將 TeX 原始碼切分為指令、數字與單一字元，連續空白合併為一個空白
+func tokenizeTeX(src string) []string {
+	var tokens []string
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			for i < len(runes) && unicode.IsSpace(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, " ")
+		case r == '%':
+			// 註解到行尾
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '\\':
+			j := i + 1
+			if j < len(runes) && isASCIILetter(runes[j]) {
+				for j < len(runes) && isASCIILetter(runes[j]) {
+					j++
+				}
+			} else if j < len(runes) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case r >= '0' && r <= '9':
+			j := i
+			for j < len(runes) && (runes[j] >= '0' && runes[j] <= '9' ||
+				runes[j] == '.' && j+1 < len(runes) && runes[j+1] >= '0' && runes[j+1] <= '9') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
+
+func isASCIILetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// 希臘字母及其他以 <mi> 輸出的符號
+var texIdentifiers = map[string]string{
+	`\alpha`: "α", `\beta`: "β", `\gamma`: "γ", `\delta`: "δ", `\epsilon`: "ϵ", `\varepsilon`: "ε",
+	`\zeta`: "ζ", `\eta`: "η", `\theta`: "θ", `\vartheta`: "ϑ", `\iota`: "ι", `\kappa`: "κ",
+	`\lambda`: "λ", `\mu`: "μ", `\nu`: "ν", `\xi`: "ξ", `\pi`: "π", `\varpi`: "ϖ", `\rho`: "ρ",
+	`\varrho`: "ϱ", `\sigma`: "σ", `\varsigma`: "ς", `\tau`: "τ", `\upsilon`: "υ", `\phi`: "ϕ",
+	`\varphi`: "φ", `\chi`: "χ", `\psi`: "ψ", `\omega`: "ω",
+	`\Gamma`: "Γ", `\Delta`: "Δ", `\Theta`: "Θ", `\Lambda`: "Λ", `\Xi`: "Ξ", `\Pi`: "Π",
+	`\Sigma`: "Σ", `\Upsilon`: "Υ", `\Phi`: "Φ", `\Psi`: "Ψ", `\Omega`: "Ω",
+	`\infty`: "∞", `\partial`: "∂", `\nabla`: "∇", `\emptyset`: "∅", `\varnothing`: "∅",
+	`\ell`: "ℓ", `\hbar`: "ℏ", `\Re`: "ℜ", `\Im`: "ℑ", `\aleph`: "ℵ", `\angle`: "∠",
+	`\triangle`: "△", `\top`: "⊤", `\bot`: "⊥",
+}
+
+// 以 <mo> 輸出的運算子、關係符號與括號
+var texOperators = map[string]string{
+	`\times`: "×", `\cdot`: "⋅", `\pm`: "±", `\mp`: "∓", `\div`: "÷", `\ast`: "∗", `\star`: "⋆",
+	`\circ`: "∘", `\bullet`: "∙", `\oplus`: "⊕", `\otimes`: "⊗", `\setminus`: "∖",
+	`\leq`: "≤", `\le`: "≤", `\geq`: "≥", `\ge`: "≥", `\neq`: "≠", `\ne`: "≠", `\approx`: "≈",
+	`\equiv`: "≡", `\sim`: "∼", `\simeq`: "≃", `\cong`: "≅", `\propto`: "∝", `\ll`: "≪", `\gg`: "≫",
+	`\to`: "→", `\rightarrow`: "→", `\leftarrow`: "←", `\gets`: "←", `\Rightarrow`: "⇒",
+	`\Leftarrow`: "⇐", `\Leftrightarrow`: "⇔", `\leftrightarrow`: "↔", `\iff`: "⟺",
+	`\implies`: "⟹", `\mapsto`: "↦", `\uparrow`: "↑", `\downarrow`: "↓",
+	`\in`: "∈", `\notin`: "∉", `\ni`: "∋", `\subset`: "⊂", `\subseteq`: "⊆", `\supset`: "⊃",
+	`\supseteq`: "⊇", `\cup`: "∪", `\cap`: "∩", `\forall`: "∀", `\exists`: "∃", `\neg`: "¬",
+	`\lnot`: "¬", `\land`: "∧", `\lor`: "∨", `\wedge`: "∧", `\vee`: "∨", `\perp`: "⊥",
+	`\parallel`: "∥", `\mid`: "∣", `\colon`: ":", `\prime`: "′", `\degree`: "°",
+	`\cdots`: "⋯", `\ldots`: "…", `\dots`: "…", `\vdots`: "⋮", `\ddots`: "⋱",
+	`\langle`: "⟨", `\rangle`: "⟩", `\lfloor`: "⌊", `\rfloor`: "⌋", `\lceil`: "⌈", `\rceil`: "⌉",
+	`\lvert`: "|", `\rvert`: "|", `\vert`: "|", `\lVert`: "‖", `\rVert`: "‖", `\Vert`: "‖", `\|`: "‖",
+	`\{`: "{", `\}`: "}", `\%`: "%", `\$`: "$", `\#`: "#", `\&`: "&", `\_`: "_",
+}
+
+// 大型運算子，display 模式下上下標置於符號上下方
+var texBigOperators = map[string]string{
+	`\sum`: "∑", `\prod`: "∏", `\coprod`: "∐", `\bigcup`: "⋃", `\bigcap`: "⋂",
+	`\bigoplus`: "⨁", `\bigotimes`: "⨂", `\bigvee`: "⋁", `\bigwedge`: "⋀",
+}
+
+// 積分符號，上下標一律置於右側
+var texIntegrals = map[string]string{
+	`\int`: "∫", `\iint`: "∬", `\iiint`: "∭", `\oint`: "∮",
+}
+
+// 函數名稱，limits 為 true 時 display 模式下標置於下方
+var texFunctions = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "cot": false, "sec": false, "csc": false,
+	"arcsin": false, "arccos": false, "arctan": false, "sinh": false, "cosh": false, "tanh": false,
+	"log": false, "ln": false, "lg": false, "exp": false, "det": false, "gcd": false, "deg": false,
+	"dim": false, "ker": false, "arg": false, "Pr": false, "mod": false,
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true, "inf": true,
+}
+
+// 重音符號
+var texAccents = map[string]string{
+	`\hat`: "^", `\widehat`: "^", `\bar`: "¯", `\overline`: "‾", `\vec`: "→", `\overrightarrow`: "→",
+	`\dot`: "˙", `\ddot`: "¨", `\tilde`: "~", `\widetilde`: "~", `\check`: "ˇ", `\breve`: "˘",
+}
+
+// 空白指令對應的寬度
+var texSpaces = map[string]string{
+	`\,`: "0.1667em", `\:`: "0.2222em", `\>`: "0.2222em", `\;`: "0.2778em", `\ `: "0.25em",
+	`\quad`: "1em", `\qquad`: "2em", `\!`: "-0.1667em",
+}
+
+// 字型指令對應的 mathvariant
+var texFonts = map[string]string{
+	`\mathrm`: "normal", `\operatorname`: "normal", `\mathbf`: "bold", `\boldsymbol`: "bold-italic",
+	`\mathit`: "italic", `\mathcal`: "script", `\mathscr`: "script", `\mathfrak`: "fraktur",
+	`\mathsf`: "sans-serif", `\mathtt`: "monospace", `\mathbb`: "double-struck",
+}
+
+// 不影響輸出的排版指令
+var texIgnored = map[string]bool{
+	`\displaystyle`: true, `\textstyle`: true, `\scriptstyle`: true, `\limits`: true, `\nolimits`: true,
+	`\big`: true, `\Big`: true, `\bigg`: true, `\Bigg`: true, `\bigl`: true, `\bigr`: true,
+	`\Bigl`: true, `\Bigr`: true, `\biggl`: true, `\biggr`: true, `\middle`: true, `\not`: true,
+}
+
+// 矩陣類環境的左右括號
+var texMatrixFences = map[string][2]string{
+	"matrix": {"", ""}, "pmatrix": {"(", ")"}, "bmatrix": {"[", "]"}, "Bmatrix": {"{", "}"},
+	"vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"}, "cases": {"{", ""}, "aligned": {"", ""},
+	"align": {"", ""}, "align*": {"", ""}, "gathered": {"", ""}, "array": {"", ""}, "split": {"", ""},
+}
+
+// texParser 遞迴下降解析 TeX token 並輸出 MathML
+type texParser struct {
+	tokens  []string
+	pos     int
+	display bool
+	optArg  int // 正在解析 \sqrt[...] 的選用參數，此時 ] 才是結束符號
+}
+
+func (p *texParser) eof() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *texParser) peek() string {
+	if p.eof() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *texParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *texParser) skipSpace() {
+	for p.peek() == " " {
+		p.pos++
+	}
+}
+
+// parseList 解析一串元素，直到遇到 }、\right、\end、& 或 \\ 為止（不消耗該 token）
+func (p *texParser) parseList() (string, error) {
+	var sb strings.Builder
+	for {
+		p.skipSpace()
+		switch p.peek() {
+		case "", "}", `\right`, `\end`, "&", `\\`:
+			return sb.String(), nil
+		case "]":
+			if p.optArg > 0 {
+				return sb.String(), nil
+			}
+		}
+		el, err := p.parseScripted()
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(el)
+	}
+}
+
+// parseScripted 解析一個元素及其上下標
+func (p *texParser) parseScripted() (string, error) {
+	var base string
+	var limits bool
+	switch p.peek() {
+	case "^", "_", "'":
+		// 沒有底數的上下標
+		base = "<mrow></mrow>"
+	default:
+		var err error
+		base, limits, err = p.parseAtom()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var sub, sup string
+	var hasSub, hasSup bool
+	for {
+		p.skipSpace()
+		switch p.peek() {
+		case "'":
+			p.next()
+			sup += "<mo>′</mo>"
+			hasSup = true
+			continue
+		case "^":
+			p.next()
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", err
+			}
+			sup += arg
+			hasSup = true
+			continue
+		case "_":
+			p.next()
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", err
+			}
+			sub += arg
+			hasSub = true
+			continue
+		}
+		break
+	}
+
+	under, over := "msub", "msup"
+	both := "msubsup"
+	if limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case hasSub && hasSup:
+		return "<" + both + ">" + base + mrow(sub) + mrow(sup) + "</" + both + ">", nil
+	case hasSub:
+		return "<" + under + ">" + base + mrow(sub) + "</" + under + ">", nil
+	case hasSup:
+		return "<" + over + ">" + base + mrow(sup) + "</" + over + ">", nil
+	}
+	return base, nil
+}
+
+// parseArg 解析指令參數：{...} 群組或單一元素
+func (p *texParser) parseArg() (string, error) {
+	p.skipSpace()
+	if p.eof() {
+		return "", errors.New("缺少參數")
+	}
+	if p.peek() == "{" {
+		p.next()
+		body, err := p.parseList()
+		if err != nil {
+			return "", err
+		}
+		if p.next() != "}" {
+			return "", errors.New("缺少 }")
+		}
+		return mrow(body), nil
+	}
+	el, _, err := p.parseAtom()
+	return el, err
+}
+
+// parseRawArg 讀取 {...} 內的原始文字，用於 \text 與環境名稱
+func (p *texParser) parseRawArg() (string, error) {
+	p.skipSpace()
+	if p.next() != "{" {
+		return "", errors.New("缺少 {")
+	}
+	var sb strings.Builder
+	depth := 0
+	for !p.eof() {
+		tok := p.next()
+		switch tok {
+		case "{":
+			depth++
+		case "}":
+			if depth == 0 {
+				return sb.String(), nil
+			}
+			depth--
+		}
+		if strings.HasPrefix(tok, `\`) && len(tok) == 2 && !isASCIILetter(rune(tok[1])) {
+			// \{ \% 等跳脫字元
+			tok = tok[1:]
+		}
+		sb.WriteString(tok)
+	}
+	return "", errors.New("缺少 }")
+}
+
+// parseAtom 解析單一元素，回傳 MathML 以及是否為 display 模式下上下標置於上下方的運算子
+func (p *texParser) parseAtom() (string, bool, error) {
+	p.skipSpace()
+	tok := p.next()
+
+	switch {
+	case tok == "":
+		return "", false, errors.New("公式不完整")
+	case tok == "{":
+		body, err := p.parseList()
+		if err != nil {
+			return "", false, err
+		}
+		if p.next() != "}" {
+			return "", false, errors.New("缺少 }")
+		}
+		return mrow(body), false, nil
+	case tok[0] >= '0' && tok[0] <= '9':
+		return element("mn", tok), false, nil
+	case !strings.HasPrefix(tok, `\`):
+		return p.parseChar(tok), false, nil
+	}
+
+	// 指令
+	if s, ok := texIdentifiers[tok]; ok {
+		return element("mi", s), false, nil
+	}
+	if s, ok := texOperators[tok]; ok {
+		return element("mo", s), false, nil
+	}
+	if s, ok := texBigOperators[tok]; ok {
+		return `<mo largeop="true" movablelimits="true">` + s + "</mo>", true, nil
+	}
+	if s, ok := texIntegrals[tok]; ok {
+		return `<mo largeop="true">` + s + "</mo>", false, nil
+	}
+	if limits, ok := texFunctions[tok[1:]]; ok {
+		return `<mi mathvariant="normal">` + tok[1:] + "</mi>", limits, nil
+	}
+	if width, ok := texSpaces[tok]; ok {
+		return `<mspace width="` + width + `"/>`, false, nil
+	}
+	if texIgnored[tok] {
+		return "", false, nil
+	}
+	if accent, ok := texAccents[tok]; ok {
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mover accent="true">` + arg + `<mo stretchy="true">` + accent + "</mo></mover>", false, nil
+	}
+	if variant, ok := texFonts[tok]; ok {
+		raw, err := p.parseRawArg()
+		if err != nil {
+			return "", false, err
+		}
+		return fontElement(raw, variant), false, nil
+	}
+
+	switch tok {
+	case `\frac`, `\dfrac`, `\tfrac`, `\binom`:
+		num, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if tok == `\binom` {
+			return `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + `</mfrac><mo>)</mo></mrow>`, false, nil
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+	case `\sqrt`:
+		p.skipSpace()
+		if p.peek() == "[" {
+			p.next()
+			p.optArg++
+			index, err := p.parseList()
+			p.optArg--
+			if err != nil {
+				return "", false, err
+			}
+			if p.next() != "]" {
+				return "", false, errors.New("缺少 ]")
+			}
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", false, err
+			}
+			return "<mroot>" + arg + mrow(index) + "</mroot>", false, nil
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return "<msqrt>" + arg + "</msqrt>", false, nil
+	case `\text`, `\textrm`, `\mbox`, `\textbf`, `\textit`:
+		raw, err := p.parseRawArg()
+		if err != nil {
+			return "", false, err
+		}
+		return element("mtext", raw), false, nil
+	case `\underline`:
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<munder accentunder="true">` + arg + `<mo stretchy="true">‾</mo></munder>`, false, nil
+	case `\left`:
+		return p.parseFenced()
+	case `\begin`:
+		return p.parseEnvironment()
+	}
+
+	// 不支援的指令，標示錯誤但不中斷整個公式
+	return "<merror><mtext>" + html.EscapeString(tok) + "</mtext></merror>", false, nil
+}
+
+// parseChar 處理一般字元
+func (p *texParser) parseChar(tok string) string {
+	r := []rune(tok)[0]
+	switch {
+	case isASCIILetter(r):
+		return element("mi", tok)
+	case tok == "-":
+		return element("mo", "−")
+	case tok == "*":
+		return element("mo", "∗")
+	case strings.ContainsRune("+=<>/|,;:!?()[].", r):
+		return element("mo", tok)
+	case unicode.IsLetter(r) && r > unicode.MaxLatin1:
+		// 中文等文字視為一般文字
+		return element("mtext", tok)
+	}
+	return element("mi", tok)
+}
+
+// parseFenced 解析 \left ... \right 括號
+func (p *texParser) parseFenced() (string, bool, error) {
+	open, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	body, err := p.parseList()
+	if err != nil {
+		return "", false, err
+	}
+	if p.next() != `\right` {
+		return "", false, errors.New(`缺少 \right`)
+	}
+	closing, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	return "<mrow>" + fence(open) + body + fence(closing) + "</mrow>", false, nil
+}
+
+// parseDelimiter 讀取 \left 與 \right 後的括號，"." 表示不顯示
+func (p *texParser) parseDelimiter() (string, error) {
+	p.skipSpace()
+	tok := p.next()
+	switch {
+	case tok == "":
+		return "", errors.New("缺少括號")
+	case tok == ".":
+		return "", nil
+	case texOperators[tok] != "":
+		return texOperators[tok], nil
+	}
+	return tok, nil
+}
+
+// parseEnvironment 解析 \begin{env} ... \end{env}
+func (p *texParser) parseEnvironment() (string, bool, error) {
+	name, err := p.parseRawArg()
+	if err != nil {
+		return "", false, err
+	}
+	fences, ok := texMatrixFences[name]
+	if !ok {
+		return "", false, fmt.Errorf("不支援的環境 %s", name)
+	}
+	if name == "array" {
+		// 欄位格式參數不影響輸出
+		if _, err := p.parseRawArg(); err != nil {
+			return "", false, err
+		}
+	}
+
+	align := "center"
+	switch name {
+	case "cases":
+		align = "left"
+	case "aligned", "align", "align*", "split":
+		align = "right left"
+	}
+
+	var rows strings.Builder
+	var cells strings.Builder
+	for {
+		cell, err := p.parseList()
+		if err != nil {
+			return "", false, err
+		}
+		cells.WriteString("<mtd>" + cell + "</mtd>")
+
+		switch p.next() {
+		case "&":
+			continue
+		case `\\`:
+			rows.WriteString("<mtr>" + cells.String() + "</mtr>")
+			cells.Reset()
+			continue
+		case `\end`:
+			if cells.Len() > len("<mtd></mtd>") || rows.Len() == 0 {
+				rows.WriteString("<mtr>" + cells.String() + "</mtr>")
+			}
+			end, err := p.parseRawArg()
+			if err != nil {
+				return "", false, err
+			}
+			if end != name {
+				return "", false, fmt.Errorf(`\begin{%s} 與 \end{%s} 不一致`, name, end)
+			}
+			table := `<mtable columnalign="` + align + `">` + rows.String() + "</mtable>"
+			return "<mrow>" + fence(fences[0]) + table + fence(fences[1]) + "</mrow>", false, nil
+		default:
+			return "", false, fmt.Errorf(`缺少 \end{%s}`, name)
+		}
+	}
+}
+
+// mrow 將多個元素包成一組
+func mrow(body string) string {
+	return "<mrow>" + body + "</mrow>"
+}
+
+// element 輸出單一 MathML 元素
+func element(tag, content string) string {
+	return "<" + tag + ">" + html.EscapeString(content) + "</" + tag + ">"
+}
+
+// fence 輸出可伸縮的括號
+func fence(delim string) string {
+	if delim == "" {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true">` + html.EscapeString(delim) + "</mo>"
+}
+
+// fontElement 以指定字型輸出文字；黑板粗體直接使用 Unicode 字元，以支援不處理 mathvariant 的瀏覽器
+func fontElement(raw, variant string) string {
+	raw = strings.TrimSpace(raw)
+	if variant == "double-struck" {
+		var sb strings.Builder
+		for _, r := range raw {
+			sb.WriteRune(doubleStruck(r))
+		}
+		return element("mi", sb.String())
+	}
+	return `<mi mathvariant="` + variant + `">` + html.EscapeString(raw) + "</mi>"
+}
+
+// doubleStruck 將英文字母轉為對應的黑板粗體字元
+func doubleStruck(r rune) rune {
+	special := map[rune]rune{'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'}
+	if s, ok := special[r]; ok {
+		return s
+	}
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return 0x1D538 + (r - 'A')
+	case r >= 'a' && r <= 'z':
+		return 0x1D552 + (r - 'a')
+	case r >= '0' && r <= '9':
+		return 0x1D7D8 + (r - '0')
+	}
+	return r
+}
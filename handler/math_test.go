@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+)
+
+// mathMarkdown 只啟用數學公式擴充的轉換器，不需要資料庫
+var mathMarkdown = goldmark.New(goldmark.WithExtensions(mathExtension{}))
+
+func convertMath(t *testing.T, src string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := mathMarkdown.Convert([]byte(src), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMathMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string // 輸出中應包含的片段
+		notWant []string // 輸出中不應包含的片段
+	}{
+		{
+			name: "行內公式",
+			src:  "質能等價 $E = mc^2$ 的公式",
+			want: []string{`<p>質能等價 <math`, `display="inline"`, `<msup><mi>c</mi><mrow><mn>2</mn></mrow></msup>`, ` 的公式</p>`},
+		},
+		{
+			name:    "金額不是公式",
+			src:     "價格從 $5 到 $10",
+			want:    []string{"<p>價格從 $5 到 $10</p>"},
+			notWant: []string{"<math"},
+		},
+		{
+			name:    "開頭 $ 後為空白",
+			src:     "a $ b$ c",
+			notWant: []string{"<math"},
+		},
+		{
+			name:    "跳脫的錢字號",
+			src:     `$a \$ b$`,
+			want:    []string{`<mo>$</mo>`},
+			notWant: []string{`\$ b$</p>`},
+		},
+		{
+			name: "段落中的 $$",
+			src:  "公式 $$x^2$$ 結束",
+			want: []string{`display="block"`, `結束</p>`},
+		},
+		{
+			name: "多行公式區塊",
+			src:  "$$\n\\frac{a}{b}\n$$\n\n之後的段落",
+			want: []string{`<div class="math-block">`, `<mfrac><mrow><mi>a</mi></mrow><mrow><mi>b</mi></mrow></mfrac>`, `<p>之後的段落</p>`},
+		},
+		{
+			name: "單行公式區塊",
+			src:  "$$ \\sqrt{2} $$",
+			want: []string{`<div class="math-block">`, `<msqrt>`},
+		},
+		{
+			name:    "未結束的公式區塊以段落顯示",
+			src:     "$$\nE = mc^2\n\n# Heading\n\nMore text",
+			want:    []string{"<p>$$\nE = mc^2</p>", `<h1>Heading</h1>`, `<p>More text</p>`},
+			notWant: []string{"<math"},
+		},
+		{
+			name:    "空行之後的 $$ 不是結尾",
+			src:     "$$\nx\n\ny\n$$",
+			notWant: []string{`<div class="math-block">`},
+		},
+		{
+			name: "引用區塊中的公式",
+			src:  "> $$\n> x\n> $$",
+			want: []string{"<blockquote>\n<div class=\"math-block\">"},
+		},
+		{
+			name: "程式碼中的錢字號",
+			src:  "`$x$`",
+			want: []string{"<code>$x$</code>"},
+		},
+		{
+			name: "無法解析的公式",
+			src:  `$\frac{a}$`,
+			want: []string{`<code class="math-error" title="缺少參數">\frac{a}</code>`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := convertMath(t, tt.src)
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("輸出不包含 %q\n%s", want, out)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("輸出不應包含 %q\n%s", notWant, out)
+				}
+			}
+		})
+	}
+}
+
+func TestTeXToMathML(t *testing.T) {
+	tests := []struct {
+		src     string
+		display bool
+		want    string
+		wantErr string // 不為空時應回傳包含此文字的錯誤
+	}{
+		{src: `x_i^2`, want: `<msubsup><mi>x</mi><mrow><mi>i</mi></mrow><mrow><mn>2</mn></mrow></msubsup>`},
+		{src: `f'`, want: `<msup><mi>f</mi><mrow><mo>′</mo></mrow></msup>`},
+		{src: `\alpha + \beta \leq 3.14`, want: `<mi>α</mi><mo>+</mo><mi>β</mi><mo>≤</mo><mn>3.14</mn>`},
+		{src: `\sqrt[3]{x}`, want: `<mroot><mrow><mi>x</mi></mrow><mrow><mn>3</mn></mrow></mroot>`},
+		{src: `\binom{n}{k}`, want: `<mfrac linethickness="0">`},
+		{src: `\sum_{i=1}^n i`, display: true, want: `<munderover><mo largeop="true" movablelimits="true">∑</mo>`},
+		{src: `\sum_{i=1}^n i`, want: `<msubsup><mo largeop="true" movablelimits="true">∑</mo>`},
+		{src: `\int_0^1 x`, display: true, want: `<msubsup><mo largeop="true">∫</mo>`},
+		{src: `\lim_{x \to 0}`, display: true, want: `<munder><mi mathvariant="normal">lim</mi>`},
+		{src: `\left( x \right.`, want: `<mrow><mo fence="true" stretchy="true">(</mo><mi>x</mi></mrow>`},
+		{src: `\text{若 } x > 0`, want: `<mtext>若 </mtext><mi>x</mi><mo>&gt;</mo>`},
+		{src: `\mathbb{R}`, want: `<mi>ℝ</mi>`},
+		{src: `\mathbf{v}`, want: `<mi mathvariant="bold">v</mi>`},
+		{src: `\hat{x}`, want: `<mover accent="true"><mrow><mi>x</mi></mrow><mo stretchy="true">^</mo></mover>`},
+		{src: `\begin{pmatrix} a & b \\ c & d \end{pmatrix}`, want: `<mtable columnalign="center"><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable>`},
+		{src: `|x| = \begin{cases} x & x \ge 0 \\ -x & x < 0 \end{cases}`, want: `<mo fence="true" stretchy="true">{</mo><mtable columnalign="left">`},
+		{src: `a \\ b`, display: true, want: `<mi>a</mi><mspace linebreak="newline"/><mi>b</mi>`},
+		{src: `x % 註解`, want: `<mrow><mi>x</mi></mrow>`},
+		{src: `\unknown`, want: `<merror><mtext>\unknown</mtext></merror>`},
+		{src: `<script>`, want: `<mo>&lt;</mo><mi>s</mi><mi>c</mi>`},
+
+		{src: `\frac{a}`, wantErr: "缺少參數"},
+		{src: `{x`, wantErr: "缺少 }"},
+		{src: `x}`, wantErr: "多餘的 }"},
+		{src: `\left( x`, wantErr: `缺少 \right`},
+		{src: `\begin{foo} x \end{foo}`, wantErr: "不支援的環境 foo"},
+		{src: `\begin{matrix} x \end{pmatrix}`, wantErr: "不一致"},
+		{src: `\begin{matrix} x`, wantErr: `缺少 \end{matrix}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			out, err := texToMathML(tt.src, tt.display)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v，應包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("輸出不包含 %q\n%s", tt.want, out)
+			}
+			if !strings.Contains(out, `<annotation encoding="application/x-tex">`) {
+				t.Error("缺少 TeX 原始碼的 annotation")
+			}
+		})
+	}
+}
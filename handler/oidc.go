@@ -0,0 +1,715 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"support/db"
+	"support/obj"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	OIDCStateCookieName = "admin_oidc_state"
+	oidcLoginTimeout    = 10 * time.Minute // 從導向身分提供者到完成登入的最長時間
+	oidcClockSkew       = time.Minute      // 允許與身分提供者之間的時間誤差
+	oidcKeysMinRefresh  = time.Minute      // 遇到未知的金鑰時重新取得 JWKS 的最短間隔
+	oidcHTTPTimeout     = 10 * time.Second // 呼叫身分提供者的逾時時間
+	oidcMaxResponseSize = 1 << 20          // 身分提供者回應的大小上限
+)
+
+// OIDCConfig 單一登入（OpenID Connect）設定
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string            // 公開用戶端可留空，只依靠 PKCE
+	RedirectURL  string            // 例如 https://support.hazelnut-paradise.com/admin/login/oidc/callback
+	Scopes       []string          // 必須包含 openid
+	GroupsClaim  string            // ID token 中群組的 claim 名稱
+	RoleMap      map[string]string // 群組對應的角色，屬於多個群組時取權限最高的角色
+	DefaultRole  string            // 沒有符合的群組時給予的角色，空值表示拒絕登入
+	DisplayName  string            // 登入按鈕上顯示的身分提供者名稱
+}
+
+var (
+	oidcConfig           *OIDCConfig // 未啟用單一登入時為 nil
+	passwordLoginEnabled = true
+)
+
+// ConfigureOIDC 啟用單一登入，應在伺服器啟動時呼叫
+func ConfigureOIDC(cfg OIDCConfig) error {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return errors.New("issuer、client ID 與 redirect URL 皆為必填")
+	}
+	if u, err := url.Parse(cfg.RedirectURL); err != nil || !u.IsAbs() {
+		return errors.New("redirect URL 必須是完整網址")
+	}
+	if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.DefaultRole != "" && !validRole(cfg.DefaultRole) {
+		return fmt.Errorf("無效的預設角色: %s", cfg.DefaultRole)
+	}
+	if len(cfg.RoleMap) == 0 && cfg.DefaultRole == "" {
+		return errors.New("必須設定群組角色對應或預設角色，否則沒有人能登入")
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = "SSO"
+	}
+	oidcConfig = &cfg
+	return nil
+}
+
+// SetPasswordLogin 設定是否允許以帳號密碼登入
+func SetPasswordLogin(enabled bool) {
+	passwordLoginEnabled = enabled
+}
+
+// ParseOIDCRoleMap 解析以逗號分隔的「群組=角色」對應，例如 support-admins=admin,writers=editor
+func ParseOIDCRoleMap(s string) (map[string]string, error) {
+	roles := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || !validRole(role) {
+			return nil, fmt.Errorf("無效的群組角色對應: %s", pair)
+		}
+		roles[group] = role
+	}
+	return roles, nil
+}
+
+// ---- 身分提供者的中繼資料與金鑰 ----
+
+// oidcProvider 從 /.well-known/openid-configuration 取得的端點
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// 身分提供者的中繼資料與簽章金鑰，第一次使用時才取得，伺服器啟動時身分提供者不必在線
+var oidcCache = struct {
+	sync.Mutex
+	provider    *oidcProvider
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}{}
+
+var oidcHTTPClient = &http.Client{Timeout: oidcHTTPTimeout}
+
+// oidcGetJSON 以 GET 取得 JSON 並解析
+func oidcGetJSON(endpoint string, v interface{}) error {
+	resp, err := oidcHTTPClient.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 回應 %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(v)
+}
+
+// getOIDCProvider 取得身分提供者的端點，成功後快取
+func getOIDCProvider() (*oidcProvider, error) {
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+	if oidcCache.provider != nil {
+		return oidcCache.provider, nil
+	}
+
+	var provider oidcProvider
+	err := oidcGetJSON(oidcConfig.Issuer+"/.well-known/openid-configuration", &provider)
+	if err != nil {
+		return nil, err
+	}
+	// 規範要求中繼資料中的 issuer 與設定完全相同，避免被導向其他身分提供者
+	if strings.TrimSuffix(provider.Issuer, "/") != oidcConfig.Issuer {
+		return nil, fmt.Errorf("issuer 不符: %s", provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("身分提供者的中繼資料缺少必要的端點")
+	}
+	oidcCache.provider = &provider
+	return oidcCache.provider, nil
+}
+
+// jsonWebKey JWKS 中的一把公鑰
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey 將 JWK 轉換為公鑰，不支援的金鑰類型回傳 nil
+func (k jsonWebKey) publicKey() crypto.PublicKey {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err1 := b64.DecodeString(k.N)
+		e, err2 := b64.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			return nil
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil
+		}
+		x, err1 := b64.DecodeString(k.X)
+		y, err2 := b64.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil
+		}
+		return key
+	}
+	return nil
+}
+
+// oidcSigningKey 依 kid 取得簽章公鑰，找不到時重新取得 JWKS 以支援身分提供者輪替金鑰
+func oidcSigningKey(provider *oidcProvider, kid string) (crypto.PublicKey, error) {
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+
+	lookup := func() crypto.PublicKey {
+		if kid == "" && len(oidcCache.keys) == 1 {
+			for _, key := range oidcCache.keys {
+				return key
+			}
+		}
+		return oidcCache.keys[kid]
+	}
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	if time.Since(oidcCache.keysFetched) < oidcKeysMinRefresh {
+		return nil, fmt.Errorf("找不到簽章金鑰: %s", kid)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := oidcGetJSON(provider.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	oidcCache.keys = map[string]crypto.PublicKey{}
+	oidcCache.keysFetched = time.Now()
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key := k.publicKey(); key != nil {
+			oidcCache.keys[k.Kid] = key
+		}
+	}
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("找不到簽章金鑰: %s", kid)
+}
+
+// ---- ID token 驗證 ----
+
+// verifyJWTSignature 以公鑰驗證 JWT 簽章，只接受非對稱演算法
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("不支援的簽章演算法: %s", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, sig, nil)
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(pub, digest, r, s) {
+				return nil
+			}
+			return errors.New("簽章錯誤")
+		}
+	}
+	return fmt.Errorf("簽章演算法 %s 與金鑰類型不符", alg)
+}
+
+// verifyIDToken 驗證 ID token 的簽章、發行者、對象、有效期限與 nonce，回傳其中的 claims
+func verifyIDToken(provider *oidcProvider, idToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID token 格式錯誤")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// 拒絕 none 與 HS256 等演算法，避免以公開資訊偽造簽章
+	if len(header.Alg) != 5 || !slices.Contains([]string{"RS", "PS", "ES"}, header.Alg[:2]) {
+		return nil, fmt.Errorf("不支援的簽章演算法: %s", header.Alg)
+	}
+	key, err := oidcSigningKey(provider, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("ID token 簽章格式錯誤")
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != provider.Issuer {
+		return nil, fmt.Errorf("ID token 的發行者不符: %s", iss)
+	}
+	audience := claimStrings(claims, "aud")
+	if !slices.Contains(audience, oidcConfig.ClientID) {
+		return nil, errors.New("ID token 的對象不符")
+	}
+	if azp, ok := claims["azp"].(string); len(audience) > 1 && (!ok || azp != oidcConfig.ClientID) {
+		return nil, errors.New("ID token 的授權對象不符")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("ID token 已過期")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("ID token 的簽發時間錯誤")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("ID token 的 nonce 不符")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("ID token 缺少 sub")
+	}
+	return claims, nil
+}
+
+// decodeJWTPart 解碼 JWT 的 header 或 payload
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("ID token 格式錯誤")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("ID token 格式錯誤")
+	}
+	return nil
+}
+
+// claimStrings 取得字串或字串陣列型態的 claim
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// oidcRole 依群組決定角色，屬於多個群組時取權限最高的角色
+func oidcRole(groups []string) string {
+	for _, info := range obj.Roles {
+		for _, group := range groups {
+			if oidcConfig.RoleMap[group] == info.Role {
+				return info.Role
+			}
+		}
+	}
+	return oidcConfig.DefaultRole
+}
+
+// oidcUsername 新建帳號時使用的使用者名稱
+func oidcUsername(claims map[string]interface{}) string {
+	for _, name := range []string{"preferred_username", "email"} {
+		if v, _ := claims[name].(string); strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+// ---- 登入流程 ----
+
+// oidcPendingLogin 已導向身分提供者、等待回呼的登入
+type oidcPendingLogin struct {
+	verifier string // PKCE code verifier
+	nonce    string
+	expiry   time.Time
+}
+
+var oidcPendingLogins = struct {
+	sync.Mutex
+	items map[string]*oidcPendingLogin
+}{items: map[string]*oidcPendingLogin{}}
+
+// oidcEnabled 是否已啟用單一登入
+func oidcEnabled() bool {
+	return oidcConfig != nil
+}
+
+// AdminOIDCLoginHandler 導向身分提供者進行單一登入（授權碼流程搭配 PKCE）
+func AdminOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	provider, err := getOIDCProvider()
+	if err != nil {
+		log.Println("OIDC discovery error:", err)
+		showLoginError(w, r, "無法連線到身分提供者，請稍後再試")
+		return
+	}
+
+	// state、PKCE verifier 與 nonce 都使用與會話ID相同強度的隨機值
+	var values [3]string
+	for i := range values {
+		values[i], err = generateSessionID()
+		if err != nil {
+			log.Println("OIDC state error:", err)
+			showLoginError(w, r, "單一登入失敗，請稍後再試")
+			return
+		}
+	}
+	state, verifier, nonce := values[0], values[1], values[2]
+	expiry := time.Now().Add(oidcLoginTimeout)
+
+	oidcPendingLogins.Lock()
+	// 順便清除過期的項目
+	for key, p := range oidcPendingLogins.items {
+		if time.Now().After(p.expiry) {
+			delete(oidcPendingLogins.items, key)
+		}
+	}
+	oidcPendingLogins.items[hashSessionID(state)] = &oidcPendingLogin{verifier: verifier, nonce: nonce, expiry: expiry}
+	oidcPendingLogins.Unlock()
+
+	// state 同時存在 Cookie 中，確保回呼來自發起登入的同一個瀏覽器；
+	// 回呼是從身分提供者導回的跨站請求，因此只能使用 Lax
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    state,
+		Expires:  expiry,
+		Path:     "/admin/login/oidc",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidcConfig.ClientID},
+		"redirect_uri":          {oidcConfig.RedirectURL},
+		"scope":                 {strings.Join(oidcConfig.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	authURL := provider.AuthorizationEndpoint
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + query.Encode()
+	} else {
+		authURL += "?" + query.Encode()
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// exchangeOIDCCode 以授權碼與 PKCE verifier 向身分提供者換取 ID token
+func exchangeOIDCCode(provider *oidcProvider, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcConfig.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if oidcConfig.ClientSecret == "" {
+		form.Set("client_id", oidcConfig.ClientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if oidcConfig.ClientSecret != "" {
+		// client_secret_basic 要求帳號密碼先經過 URL 編碼
+		req.SetBasicAuth(url.QueryEscape(oidcConfig.ClientID), url.QueryEscape(oidcConfig.ClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(&result); err != nil {
+		return "", fmt.Errorf("token endpoint 回應 %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return "", fmt.Errorf("token endpoint 回應 %s: %s %s", resp.Status, result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return "", errors.New("token endpoint 沒有回傳 ID token")
+	}
+	return result.IDToken, nil
+}
+
+// errOIDCDenied 身分驗證成功但不允許登入後台，訊息可直接顯示給使用者
+type errOIDCDenied struct {
+	username string
+	reason   string
+}
+
+func (e *errOIDCDenied) Error() string {
+	return e.reason
+}
+
+// provisionOIDCUser 取得單一登入對應的本機帳號，第一次登入時自動建立，之後每次登入依群組同步角色
+func provisionOIDCUser(r *http.Request, claims map[string]interface{}) (obj.User, error) {
+	sub, _ := claims["sub"].(string)
+	groups := claimStrings(claims, oidcConfig.GroupsClaim)
+	role := oidcRole(groups)
+
+	user, err := db.GetUserByOIDCSubject(oidcConfig.Issuer, sub)
+	if err == nil {
+		if user.Disabled {
+			return obj.User{}, &errOIDCDenied{user.Username, "此帳號已停用"}
+		}
+		if role == "" {
+			return obj.User{}, &errOIDCDenied{user.Username, "你的帳號不屬於任何可登入後台的群組"}
+		}
+		if role != user.Role {
+			if err := db.UpdateUserRole(user.ID, role); err != nil {
+				return obj.User{}, err
+			}
+			recordAuditAs(r, user.Username, auditEntry{
+				Action:     obj.AuditUserUpdate,
+				TargetType: "user",
+				TargetID:   user.ID,
+				TargetName: user.Username,
+				Before:     userAuditSummary(user.Role, user.Email, user.Disabled),
+				After:      userAuditSummary(role, user.Email, user.Disabled) + "；依身分提供者群組同步",
+			})
+			user.Role = role
+		}
+		return user, nil
+	}
+
+	username := oidcUsername(claims)
+	if role == "" {
+		return obj.User{}, &errOIDCDenied{username, "你的帳號不屬於任何可登入後台的群組"}
+	}
+	// 不自動連結同名的本機帳號，避免身分提供者上的同名帳號接管既有帳號
+	if _, err := db.GetUserByUsername(username); err == nil {
+		return obj.User{}, &errOIDCDenied{username, "帳號名稱「" + username + "」已被其他帳號使用，請聯絡管理員"}
+	}
+
+	user = obj.User{
+		Username:    username,
+		Role:        role,
+		OIDCIssuer:  oidcConfig.Issuer,
+		OIDCSubject: sub,
+	}
+	if err := db.AddUser(&user); err != nil {
+		return obj.User{}, err
+	}
+	recordAuditAs(r, username, auditEntry{
+		Action:     obj.AuditUserCreate,
+		TargetType: "user",
+		TargetID:   user.ID,
+		TargetName: username,
+		After:      userAuditSummary(user.Role, user.Email, user.Disabled) + "；由單一登入自動建立",
+	})
+	return user, nil
+}
+
+// AdminOIDCCallbackHandler 處理身分提供者導回的授權碼
+func AdminOIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// state 只能使用一次
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    "",
+		Path:     "/admin/login/oidc",
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+	})
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(OIDCStateCookieName)
+	if err != nil || state == "" || cookie.Value != state {
+		showLoginError(w, r, "單一登入已逾時或無效，請重新登入")
+		return
+	}
+	key := hashSessionID(state)
+	oidcPendingLogins.Lock()
+	pending, ok := oidcPendingLogins.items[key]
+	delete(oidcPendingLogins.items, key)
+	oidcPendingLogins.Unlock()
+	if !ok || time.Now().After(pending.expiry) {
+		showLoginError(w, r, "單一登入已逾時或無效，請重新登入")
+		return
+	}
+
+	// 使用者在身分提供者取消登入或被拒絕
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		log.Printf("OIDC authorization error: %s %s", errCode, r.URL.Query().Get("error_description"))
+		showLoginError(w, r, "單一登入失敗："+errCode)
+		return
+	}
+
+	provider, err := getOIDCProvider()
+	if err != nil {
+		log.Println("OIDC discovery error:", err)
+		showLoginError(w, r, "無法連線到身分提供者，請稍後再試")
+		return
+	}
+	idToken, err := exchangeOIDCCode(provider, r.URL.Query().Get("code"), pending.verifier)
+	if err != nil {
+		log.Println("OIDC token exchange error:", err)
+		showLoginError(w, r, "單一登入失敗，請稍後再試")
+		return
+	}
+	claims, err := verifyIDToken(provider, idToken, pending.nonce)
+	if err != nil {
+		log.Println("OIDC ID token error:", err)
+		showLoginError(w, r, "單一登入失敗，身分驗證資訊無效")
+		return
+	}
+
+	user, err := provisionOIDCUser(r, claims)
+	if err != nil {
+		var denied *errOIDCDenied
+		if errors.As(err, &denied) {
+			recordLoginAttempt(r, denied.username, false, false, "單一登入："+denied.reason)
+			w.WriteHeader(http.StatusForbidden)
+			showLoginError(w, r, denied.reason)
+			return
+		}
+		log.Println("OIDC provisioning error:", err)
+		showLoginError(w, r, "單一登入失敗，請稍後再試")
+		return
+	}
+
+	// 已啟用兩步驟驗證的使用者仍需輸入驗證碼
+	if user.TOTPEnabled {
+		err = startPendingLogin(w, user.Username)
+		if err != nil {
+			log.Println("Pending login error:", err)
+			showLoginError(w, r, "創建會話失敗")
+			return
+		}
+		showLoginRedirect(w, "/admin/login/2fa")
+		return
+	}
+
+	_, err = startAdminSession(w, r, user.Username)
+	if err != nil {
+		log.Println("Session save error:", err)
+		showLoginError(w, r, "創建會話失敗")
+		return
+	}
+	recordLoginAttempt(r, user.Username, true, false, "單一登入")
+	recordAuditAs(r, user.Username, auditEntry{Action: obj.AuditLoginSSO, After: oidcConfig.DisplayName})
+	showLoginRedirect(w, "/admin/dashboard")
+}
+
+// showLoginRedirect 以頁面轉址代替 HTTP 重定向。
+// 從身分提供者導回屬於跨站請求，直接重定向時瀏覽器不會送出 SameSite=Strict 的會話 Cookie，
+// 由本站頁面發起的轉址則會視為同站請求。
+func showLoginRedirect(w http.ResponseWriter, target string) {
+	tmpl, err := template.ParseFiles("templates/admin/login_redirect.html")
+	if err != nil {
+		log.Println("Login redirect template parse error:", err)
+		http.Error(w, "模板解析錯誤", http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, map[string]interface{}{"Target": target})
+	if err != nil {
+		log.Println("Login redirect template execute error:", err)
+		http.Error(w, "模板執行錯誤", http.StatusInternalServerError)
+	}
+}
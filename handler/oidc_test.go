@@ -0,0 +1,415 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testOIDCClientID    = "support-client"
+	testOIDCRedirectURL = "https://support.example.com/admin/login/oidc/callback"
+)
+
+// mockOIDCProvider 本機的模擬身分提供者，提供 discovery、JWKS 與 token endpoint
+type mockOIDCProvider struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	keys     []jsonWebKey // JWKS 目前公開的金鑰
+	idToken  string       // token endpoint 回傳的 ID token
+	jwksHits int
+	form     url.Values // token endpoint 最後收到的表單
+	username string     // token endpoint 最後收到的 Basic 認證帳號
+	password string
+}
+
+// newMockOIDCProvider 啟動模擬身分提供者並以它設定單一登入，測試結束時還原設定
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	p := &mockOIDCProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.jwksHits++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": p.keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		r.ParseForm()
+		p.form = r.PostForm
+		p.username, p.password, _ = r.BasicAuth()
+		if r.PostForm.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken, "token_type": "Bearer"})
+	})
+	p.server = httptest.NewServer(mux)
+
+	if err := ConfigureOIDC(OIDCConfig{
+		Issuer:      p.server.URL,
+		ClientID:    testOIDCClientID,
+		RedirectURL: testOIDCRedirectURL,
+		DefaultRole: "viewer",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.server.Close()
+		oidcConfig = nil
+		resetOIDCCache()
+	})
+	resetOIDCCache()
+	return p
+}
+
+// resetOIDCCache 清除快取的中繼資料與金鑰
+func resetOIDCCache() {
+	oidcCache.Lock()
+	oidcCache.provider = nil
+	oidcCache.keys = nil
+	oidcCache.keysFetched = time.Time{}
+	oidcCache.Unlock()
+}
+
+// setKeys 替換 JWKS 公開的金鑰
+func (p *mockOIDCProvider) setKeys(keys ...jsonWebKey) {
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+}
+
+func (p *mockOIDCProvider) setIDToken(token string) {
+	p.mu.Lock()
+	p.idToken = token
+	p.mu.Unlock()
+}
+
+func (p *mockOIDCProvider) provider(t *testing.T) *oidcProvider {
+	t.Helper()
+	provider, err := getOIDCProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// ---- 金鑰與簽章 ----
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) jsonWebKey {
+	return jsonWebKey{Kid: kid, Kty: "RSA", Use: "sig", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) jsonWebKey {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return jsonWebKey{Kid: kid, Kty: "EC", Use: "sig", Crv: key.Curve.Params().Name,
+		X: b64(key.X.FillBytes(make([]byte, size))), Y: b64(key.Y.FillBytes(make([]byte, size)))}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signJWT 以指定的演算法簽署 JWT。key 為 *rsa.PrivateKey、*ecdsa.PrivateKey、HS256 的 []byte，alg 為 none 時不簽章
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case nil:
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(alg, "PS") {
+			sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:], nil)
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+// validClaims 可以通過驗證的 claims
+func validClaims(issuer, nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                issuer,
+		"sub":                "user-1",
+		"aud":                testOIDCClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+	}
+}
+
+// ---- 測試 ----
+
+func TestVerifyIDToken(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	rsaKey, ecKey := newRSAKey(t), newECKey(t)
+	p.setKeys(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	provider := p.provider(t)
+
+	// with 修改一份有效的 claims
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		claims := validClaims(provider.Issuer, "n-123")
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+	// RSA 公鑰的 PEM 內容是公開資訊，HS256 以它當作密鑰就能偽造簽章
+	publicKeyBytes := rsaKey.PublicKey.N.Bytes()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string // 空字串表示應通過驗證
+	}{
+		{"RS256", signJWT(t, "RS256", "rsa-1", rsaKey, with(nil)), ""},
+		{"PS256", signJWT(t, "PS256", "rsa-1", rsaKey, with(nil)), ""},
+		{"ES256", signJWT(t, "ES256", "ec-1", ecKey, with(nil)), ""},
+		{"多個對象且 azp 正確", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{
+			"aud": []string{testOIDCClientID, "other"}, "azp": testOIDCClientID})), ""},
+
+		{"alg none", signJWT(t, "none", "rsa-1", nil, with(nil)), "不支援的簽章演算法"},
+		{"HS256 以公鑰為密鑰", signJWT(t, "HS256", "rsa-1", publicKeyBytes, with(nil)), "不支援的簽章演算法"},
+		{"RSA 金鑰搭配 ES256", signJWT(t, "ES256", "rsa-1", ecKey, with(nil)), "與金鑰類型不符"},
+		{"EC 金鑰搭配 RS256", signJWT(t, "RS256", "ec-1", rsaKey, with(nil)), "與金鑰類型不符"},
+		{"簽章錯誤", signJWT(t, "RS256", "rsa-1", newRSAKey(t), with(nil)), "verification error"},
+		{"發行者不符", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{"iss": "https://evil.example.com"})), "發行者不符"},
+		{"對象不符", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{"aud": "other-client"})), "對象不符"},
+		{"多個對象缺少 azp", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{
+			"aud": []string{testOIDCClientID, "other"}})), "授權對象不符"},
+		{"azp 不符", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{
+			"aud": []string{testOIDCClientID, "other"}, "azp": "other"})), "授權對象不符"},
+		{"已過期", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{
+			"exp": time.Now().Add(-2 * oidcClockSkew).Unix()})), "已過期"},
+		{"缺少 exp", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{"exp": nil})), "已過期"},
+		{"簽發時間在未來", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{
+			"iat": time.Now().Add(2 * oidcClockSkew).Unix()})), "簽發時間錯誤"},
+		{"nonce 不符", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{"nonce": "n-other"})), "nonce 不符"},
+		{"缺少 nonce", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{"nonce": nil})), "nonce 不符"},
+		{"缺少 sub", signJWT(t, "RS256", "rsa-1", rsaKey, with(map[string]interface{}{"sub": nil})), "缺少 sub"},
+		{"格式錯誤", "not-a-jwt", "格式錯誤"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyIDToken(provider, tt.token, "n-123")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("驗證失敗: %v", err)
+				}
+				if claims["sub"] != "user-1" {
+					t.Errorf("sub = %v", claims["sub"])
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("應該拒絕，但通過驗證")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("錯誤訊息 %q 不包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	p.setKeys(rsaJWK("old", oldKey))
+	provider := p.provider(t)
+	claims := validClaims(provider.Issuer, "n")
+
+	if _, err := verifyIDToken(provider, signJWT(t, "RS256", "old", oldKey, claims), "n"); err != nil {
+		t.Fatalf("舊金鑰驗證失敗: %v", err)
+	}
+
+	// 身分提供者輪替金鑰，剛取得 JWKS 時不會立即重新取得，避免以未知的 kid 大量請求 JWKS
+	p.setKeys(rsaJWK("new", newKey))
+	rotated := signJWT(t, "RS256", "new", newKey, claims)
+	if _, err := verifyIDToken(provider, rotated, "n"); err == nil || !strings.Contains(err.Error(), "找不到簽章金鑰") {
+		t.Fatalf("間隔內不應重新取得 JWKS，err = %v", err)
+	}
+	if p.jwksHits != 1 {
+		t.Fatalf("JWKS 取得次數 = %d，應為 1", p.jwksHits)
+	}
+
+	// 超過最短間隔後遇到未知的 kid，重新取得 JWKS
+	oidcCache.Lock()
+	oidcCache.keysFetched = time.Now().Add(-oidcKeysMinRefresh)
+	oidcCache.Unlock()
+	if _, err := verifyIDToken(provider, rotated, "n"); err != nil {
+		t.Fatalf("新金鑰驗證失敗: %v", err)
+	}
+	if p.jwksHits != 2 {
+		t.Fatalf("JWKS 取得次數 = %d，應為 2", p.jwksHits)
+	}
+
+	// 已從 JWKS 移除的舊金鑰不再接受
+	if _, err := verifyIDToken(provider, signJWT(t, "RS256", "old", oldKey, claims), "n"); err == nil {
+		t.Fatal("已移除的舊金鑰不應通過驗證")
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	oidcConfig.Issuer = p.server.URL + "/other"
+	if _, err := getOIDCProvider(); err == nil {
+		t.Fatal("中繼資料的 issuer 與設定不同時應拒絕")
+	}
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	key := newRSAKey(t)
+	p.setKeys(rsaJWK("k1", key))
+
+	// 導向身分提供者
+	rec := httptest.NewRecorder()
+	AdminOIDCLoginHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/login/oidc", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), p.server.URL+"/authorize?") {
+		t.Fatalf("Location = %s", rec.Header().Get("Location"))
+	}
+	query := location.Query()
+	for name, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testOIDCClientID,
+		"redirect_uri":          testOIDCRedirectURL,
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q，應為 %q", name, got, want)
+		}
+	}
+	if !strings.Contains(query.Get("scope"), "openid") {
+		t.Errorf("scope = %q", query.Get("scope"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != OIDCStateCookieName || cookies[0].Value != query.Get("state") {
+		t.Fatalf("state Cookie 錯誤: %v", cookies)
+	}
+
+	oidcPendingLogins.Lock()
+	pending := oidcPendingLogins.items[hashSessionID(query.Get("state"))]
+	oidcPendingLogins.Unlock()
+	if pending == nil || pending.nonce != query.Get("nonce") {
+		t.Fatal("找不到等待回呼的登入")
+	}
+
+	// 以授權碼換取 ID token，並確認 PKCE verifier 與 challenge 相符
+	p.setIDToken(signJWT(t, "RS256", "k1", key, validClaims(p.server.URL, pending.nonce)))
+	provider := p.provider(t)
+	idToken, err := exchangeOIDCCode(provider, "good-code", pending.verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := sha256.Sum256([]byte(p.form.Get("code_verifier")))
+	if b64(challenge[:]) != query.Get("code_challenge") {
+		t.Error("code_verifier 與 code_challenge 不符")
+	}
+	if p.form.Get("redirect_uri") != testOIDCRedirectURL || p.form.Get("client_id") != testOIDCClientID {
+		t.Errorf("token 請求內容錯誤: %v", p.form)
+	}
+	claims, err := verifyIDToken(provider, idToken, pending.nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oidcUsername(claims) != "alice" || oidcRole(claimStrings(claims, "groups")) != "viewer" {
+		t.Errorf("帳號 %q 角色 %q", oidcUsername(claims), oidcRole(nil))
+	}
+
+	// 授權碼無效時回傳身分提供者的錯誤
+	if _, err := exchangeOIDCCode(provider, "bad-code", pending.verifier); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestOIDCTokenClientSecret(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	oidcConfig.ClientSecret = "s3cret:&"
+	p.setIDToken("token")
+	if _, err := exchangeOIDCCode(p.provider(t), "good-code", "verifier"); err != nil {
+		t.Fatal(err)
+	}
+	// client_secret_basic 的帳號密碼經過 URL 編碼，且不在表單中重複送出 client_id
+	if p.username != testOIDCClientID || p.password != url.QueryEscape("s3cret:&") {
+		t.Errorf("Basic 認證 = %q / %q", p.username, p.password)
+	}
+	if p.form.Has("client_id") {
+		t.Error("使用 client secret 時不應在表單中送出 client_id")
+	}
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"log"
+	"strings"
+	"support/db"
+	"support/obj"
+	"sync"
+	"unicode"
+)
+
+// 密碼規則的預設值，可在系統設定頁面調整
+const (
+	defaultPasswordMinLength  = 12
+	defaultPasswordMinClasses = 3
+	defaultPasswordHistory    = 5
+	passwordMaxLength         = 128 // 避免以超長密碼消耗雜湊計算資源
+	passwordMaxHistory        = 24  // 與資料庫保留的舊密碼數量相同
+)
+
+// passwordPolicy 設定密碼時必須符合的規則
+type passwordPolicy struct {
+	MinLength   int  // 最短長度
+	MinClasses  int  // 大寫、小寫、數字、符號至少需要包含幾種
+	History     int  // 不可重複使用最近幾次的密碼，包含目前的密碼，0 表示不限制
+	BlockCommon bool // 禁止使用常見或已外洩的密碼
+}
+
+// defaultPasswordPolicy 尚未調整設定時的密碼規則
+var defaultPasswordPolicy = passwordPolicy{
+	MinLength:   defaultPasswordMinLength,
+	MinClasses:  defaultPasswordMinClasses,
+	History:     defaultPasswordHistory,
+	BlockCommon: true,
+}
+
+// getPasswordPolicy 取得目前的密碼規則
+func getPasswordPolicy() passwordPolicy {
+	return passwordPolicy{
+		MinLength:   min(max(settingInt(obj.SettingPasswordMinLength, defaultPasswordMinLength), 8), passwordMaxLength),
+		MinClasses:  min(max(settingInt(obj.SettingPasswordMinClasses, defaultPasswordMinClasses), 1), 4),
+		History:     min(settingInt(obj.SettingPasswordHistory, defaultPasswordHistory), passwordMaxHistory),
+		BlockCommon: db.GetSetting(obj.SettingPasswordBlockCommon, "true") == "true",
+	}
+}
+
+// Description 顯示給使用者的密碼規則說明
+func (p passwordPolicy) Description() string {
+	rules := []string{fmt.Sprintf("至少 %d 個字符", p.MinLength)}
+	if p.MinClasses > 1 {
+		rules = append(rules, fmt.Sprintf("包含大寫字母、小寫字母、數字、符號其中至少 %d 種", p.MinClasses))
+	}
+	if maxBytes := db.MaxPasswordBytes(); maxBytes > 0 {
+		rules = append(rules, fmt.Sprintf("不超過 %d 個位元組（中文字每字佔 3 個位元組）", maxBytes))
+	}
+	rules = append(rules, "不能包含使用者名稱")
+	if p.BlockCommon {
+		rules = append(rules, "不能使用常見的密碼")
+	}
+	if p.History > 0 {
+		rules = append(rules, fmt.Sprintf("不能與最近 %d 次用過的密碼相同", p.History))
+	}
+	return strings.Join(rules, "，")
+}
+
+// Check 檢查密碼是否符合規則，不符合時回傳原因，符合時回傳空字串。不檢查密碼歷史
+func (p passwordPolicy) Check(username, password string) string {
+	length := len([]rune(password))
+	if length < p.MinLength {
+		return fmt.Sprintf("密碼長度必須至少為 %d 個字符", p.MinLength)
+	}
+	if length > passwordMaxLength {
+		return fmt.Sprintf("密碼長度不能超過 %d 個字符", passwordMaxLength)
+	}
+	if maxBytes := db.MaxPasswordBytes(); maxBytes > 0 && len(password) > maxBytes {
+		return fmt.Sprintf("密碼不能超過 %d 個位元組（中文字每字佔 3 個位元組）", maxBytes)
+	}
+
+	// 大寫、小寫、數字、符號
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	kinds := 0
+	for _, ok := range []bool{upper, lower, digit, symbol} {
+		if ok {
+			kinds++
+		}
+	}
+	if kinds < p.MinClasses {
+		return fmt.Sprintf("密碼必須包含大寫字母、小寫字母、數字、符號其中至少 %d 種", p.MinClasses)
+	}
+
+	lowered := strings.ToLower(password)
+	if username != "" && strings.Contains(lowered, strings.ToLower(username)) {
+		return "密碼不能包含使用者名稱"
+	}
+	if p.BlockCommon {
+		for _, word := range weakPasswordWords {
+			if strings.Contains(lowered, word) {
+				return "密碼不能包含常見的字詞，例如「" + word + "」"
+			}
+		}
+		if isCommonPassword(password) {
+			return "這個密碼太常見，曾出現在外洩的密碼清單中，請改用其他密碼"
+		}
+	}
+	return ""
+}
+
+// CheckPasswordStrength 以預設的密碼規則檢查密碼，不符合時回傳原因，符合時回傳空字串。
+// 用於資料庫尚未建立前的初始管理員密碼
+func CheckPasswordStrength(username, password string) string {
+	return defaultPasswordPolicy.Check(username, password)
+}
+
+// checkNewPassword 檢查使用者的新密碼是否符合目前的密碼規則，包含不可重複使用最近的密碼
+func checkNewPassword(user obj.User, password string) string {
+	policy := getPasswordPolicy()
+	if reason := policy.Check(user.Username, password); reason != "" {
+		return reason
+	}
+	if policy.History == 0 || user.ID == 0 {
+		return ""
+	}
+
+	hashes := []string{user.Password}
+	if policy.History > 1 {
+		history, err := db.GetPasswordHistory(user.ID, policy.History-1)
+		if err != nil {
+			log.Println("Error fetching password history:", err)
+		}
+		hashes = append(hashes, history...)
+	}
+	for _, hash := range hashes {
+		if hash != "" && db.VerifyPassword(hash, password) {
+			return fmt.Sprintf("不能使用最近 %d 次用過的密碼", policy.History)
+		}
+	}
+	return ""
+}
+
+// 常見且容易被猜到的密碼片段
+var weakPasswordWords = []string{"password", "admin", "123456", "qwerty", "support"}
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords 常見密碼清單，第一次使用時才載入
+var commonPasswords = sync.OnceValue(func() map[string]bool {
+	passwords := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwords[line] = true
+		}
+	}
+	return passwords
+})
+
+// 常見的以數字或符號代替字母的寫法
+var leetReplacer = strings.NewReplacer("@", "a", "4", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+
+// isCommonPassword 密碼是否在常見密碼清單中。
+// 也會去掉前後的數字與符號並還原替代字母後比對，例如「P@ssw0rd2024!」
+func isCommonPassword(password string) bool {
+	list := commonPasswords()
+	lowered := strings.ToLower(password)
+	if list[lowered] {
+		return true
+	}
+	base := strings.TrimFunc(lowered, func(c rune) bool { return !unicode.IsLetter(c) })
+	base = leetReplacer.Replace(base)
+	return len(base) >= 4 && list[base]
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+	"time"
+)
+
+const (
+	passwordResetTimeout  = 30 * time.Minute // 重設密碼連結的有效時間
+	passwordResetInterval = 2 * time.Minute  // 同一帳號兩次寄出重設密碼信的最短間隔，避免信箱被灌爆
+)
+
+// passwordResetAvailable 是否提供忘記密碼功能，需要已設定寄信且允許帳號密碼登入
+func passwordResetAvailable() bool {
+	return mailEnabled() && passwordLoginEnabled
+}
+
+// showPasswordResetPage 顯示忘記密碼或重設密碼頁面
+func showPasswordResetPage(w http.ResponseWriter, page string, data map[string]interface{}) {
+	tmpl, err := template.ParseFiles("templates/admin/" + page)
+	if err != nil {
+		log.Println("Password reset template parse error:", err)
+		http.Error(w, "模板解析錯誤", http.StatusInternalServerError)
+		return
+	}
+	// 網址中帶有 token，不可經由 Referer 洩漏給頁面引用的外部資源
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Password reset template execute error:", err)
+		http.Error(w, "模板執行錯誤", http.StatusInternalServerError)
+	}
+}
+
+// passwordResetCandidates 依使用者名稱或電子郵件找出可以重設密碼的帳號
+func passwordResetCandidates(account string) []obj.User {
+	var users []obj.User
+	if strings.Contains(account, "@") {
+		found, err := db.GetUsersByEmail(account)
+		if err != nil {
+			log.Println("Error fetching users by email:", err)
+		}
+		users = found
+	}
+	if user, err := db.GetUserByUsername(account); err == nil {
+		users = append(users, user)
+	}
+
+	// 單一登入帳號沒有本機密碼，已停用與未設定信箱的帳號也不寄信
+	var candidates []obj.User
+	seen := map[uint]bool{}
+	for _, user := range users {
+		if user.Email != "" && !user.Disabled && !user.IsSSO() && !seen[user.ID] {
+			seen[user.ID] = true
+			candidates = append(candidates, user)
+		}
+	}
+	return candidates
+}
+
+// sendPasswordReset 建立重設密碼 token 並寄出連結，距離上次申請太近時略過
+func sendPasswordReset(r *http.Request, user obj.User) error {
+	if latest, err := db.GetLatestPasswordResetToken(user.ID); err == nil && time.Since(latest.CreateTime) < passwordResetInterval {
+		log.Printf("Password reset for %s skipped: requested too frequently", user.Username)
+		return nil
+	}
+
+	raw, err := generateSessionID()
+	if err != nil {
+		return err
+	}
+	err = db.AddPasswordResetToken(&obj.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashSessionID(raw),
+		ExpiresAt: time.Now().Add(passwordResetTimeout),
+		IPAddress: clientIP(r),
+	})
+	if err != nil {
+		return err
+	}
+	recordAuditAs(r, user.Username, auditEntry{Action: obj.AuditPasswordForgot, TargetType: "user", TargetID: user.ID, TargetName: user.Username})
+
+	link := mailConfig.BaseURL + "/admin/reset-password?token=" + url.QueryEscape(raw)
+	body := user.Username + " 您好：\n\n" +
+		"我們收到了重設支援中心後台密碼的申請。請在 30 分鐘內開啟以下連結設定新密碼：\n\n" +
+		link + "\n\n" +
+		"連結只能使用一次。如果您沒有申請重設密碼，請忽略這封信，您的密碼不會被變更。\n\n" +
+		"申請來源 IP：" + clientIP(r) + "\n"
+
+	// 在背景寄信，帳號是否存在都會立即回應，避免從回應時間推測帳號
+	go func() {
+		if err := sendMail(user.Email, "重設支援中心後台密碼", body); err != nil {
+			log.Printf("Error sending password reset mail to %s: %v", user.Username, err)
+		}
+	}()
+	return nil
+}
+
+// AdminForgotPasswordHandler 處理忘記密碼，寄出重設密碼連結
+func AdminForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if !passwordResetAvailable() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		showPasswordResetPage(w, "forgot_password.html", map[string]interface{}{})
+		return
+	}
+
+	account := strings.TrimSpace(r.FormValue("account"))
+	if account == "" {
+		showPasswordResetPage(w, "forgot_password.html", map[string]interface{}{
+			"ErrorMessage": "請輸入使用者名稱或電子郵件",
+		})
+		return
+	}
+
+	for _, user := range passwordResetCandidates(account) {
+		if err := sendPasswordReset(r, user); err != nil {
+			log.Println("Password reset error:", err)
+		}
+	}
+
+	// 不論帳號是否存在都顯示相同的訊息
+	showPasswordResetPage(w, "forgot_password.html", map[string]interface{}{"Sent": true})
+}
+
+// AdminResetPasswordHandler 處理以重設密碼連結設定新密碼
+func AdminResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if !passwordResetAvailable() {
+		http.NotFound(w, r)
+		return
+	}
+
+	raw := r.FormValue("token")
+	token, err := db.GetPasswordResetToken(hashSessionID(raw))
+	if raw == "" || err != nil {
+		showPasswordResetPage(w, "reset_password.html", map[string]interface{}{"Invalid": true})
+		return
+	}
+	user, err := db.GetUser(token.UserID)
+	if err != nil || user.Disabled || user.IsSSO() {
+		showPasswordResetPage(w, "reset_password.html", map[string]interface{}{"Invalid": true})
+		return
+	}
+
+	policy := getPasswordPolicy()
+	data := map[string]interface{}{
+		"Token":      raw,
+		"Username":   user.Username,
+		"MinLength":  policy.MinLength,
+		"PolicyText": policy.Description(),
+	}
+	if r.Method != http.MethodPost {
+		showPasswordResetPage(w, "reset_password.html", data)
+		return
+	}
+
+	password := r.FormValue("password")
+	if password != r.FormValue("confirm_password") {
+		data["ErrorMessage"] = "兩次輸入的密碼不一致"
+		showPasswordResetPage(w, "reset_password.html", data)
+		return
+	}
+	if reason := checkNewPassword(user, password); reason != "" {
+		data["ErrorMessage"] = reason
+		showPasswordResetPage(w, "reset_password.html", data)
+		return
+	}
+
+	hashedPassword, err := db.HashPassword(password)
+	if err == nil {
+		err = db.ResetUserPassword(token.ID, user.ID, hashedPassword)
+	}
+	if err != nil {
+		// 連結在填寫期間已被使用或過期
+		log.Println("Password reset error:", err)
+		showPasswordResetPage(w, "reset_password.html", map[string]interface{}{"Invalid": true})
+		return
+	}
+	recordAuditAs(r, user.Username, auditEntry{Action: obj.AuditPasswordReset, TargetType: "user", TargetID: user.ID, TargetName: user.Username})
+
+	showPasswordResetPage(w, "reset_password.html", map[string]interface{}{"Done": true, "Username": user.Username})
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"net/http"
+	"support/obj"
+)
+
+// permission 後台操作權限
+type permission string
+
+const (
+	permView             permission = "view"              // 瀏覽後台、預覽草稿、管理自己的帳號
+	permEditDocs         permission = "edit_docs"         // 編輯及發布既有文章
+	permManageDocs       permission = "manage_docs"       // 新增與刪除文章
+	permManageContent    permission = "manage_content"    // 管理內容片段、變數與圖片
+	permManageCategories permission = "manage_categories" // 新增與編輯分類
+	permDeleteCategories permission = "delete_categories" // 刪除分類（會一併刪除其下文章）
+	permManageUsers      permission = "manage_users"      // 管理使用者
+	permManageSettings   permission = "manage_settings"   // 變更系統設定
+)
+
+// 各角色擁有的權限
+var rolePermissions = map[string][]permission{
+	obj.RoleAdmin: {
+		permView, permEditDocs, permManageDocs, permManageContent,
+		permManageCategories, permDeleteCategories, permManageUsers, permManageSettings,
+	},
+	obj.RoleEditor:   {permView, permEditDocs, permManageDocs, permManageContent, permManageCategories},
+	obj.RoleReviewer: {permView, permEditDocs},
+	obj.RoleViewer:   {permView},
+}
+
+// hasPermission 檢查角色是否擁有指定權限
+func hasPermission(role string, perm permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// routeRule 路由所需的權限，Read 用於 GET 與 HEAD 請求，Write 用於其他會修改資料的請求
+type routeRule struct {
+	Read  permission
+	Write permission
+}
+
+// 後台各路由所需的權限，未列出的路由僅限管理員
+var routeRules = map[string]routeRule{
+	"/admin":           {permView, permView},
+	"/admin/dashboard": {permView, permView},
+
+	"/admin/categories":        {permView, permView},
+	"/admin/categories/add":    {permView, permManageCategories},
+	"/admin/categories/edit":   {permView, permManageCategories},
+	"/admin/categories/delete": {permView, permDeleteCategories},
+
+	"/admin/docs":         {permView, permView},
+	"/admin/docs/add":     {permView, permManageDocs},
+	"/admin/docs/edit":    {permEditDocs, permEditDocs}, // 以編輯頁新增文章時另外檢查 permManageDocs
+	"/admin/docs/update":  {permView, permEditDocs},
+	"/admin/docs/delete":  {permView, permManageDocs},
+	"/admin/docs/preview": {permView, permView},
+
+	"/admin/setup":               {permView, permView},
+	"/admin/change-password":     {permView, permView},
+	"/admin/sessions":            {permView, permView},
+	"/admin/sessions/revoke":     {permView, permView},
+	"/admin/sessions/revoke-all": {permView, permView},
+
+	"/admin/tokens":        {permView, permView},
+	"/admin/tokens/create": {permView, permView},
+	"/admin/tokens/revoke": {permView, permView},
+
+	"/admin/2fa":                {permView, permView},
+	"/admin/2fa/enable":         {permView, permView},
+	"/admin/2fa/disable":        {permView, permView},
+	"/admin/2fa/recovery-codes": {permView, permView},
+
+	"/admin/images":                 {permView, permView},
+	"/admin/images/upload":          {permManageContent, permManageContent},
+	"/admin/images/delete":          {permView, permManageContent},
+	"/admin/images/cleanup":         {permManageContent, permManageContent},
+	"/admin/images/meta":            {permView, permManageContent},
+	"/admin/images/library":         {permView, permView},
+	"/admin/images/batch":           {permManageContent, permManageContent},
+	"/admin/images/chunks":          {permManageContent, permManageContent},
+	"/admin/images/chunks/start":    {permManageContent, permManageContent},
+	"/admin/images/chunks/complete": {permManageContent, permManageContent},
+
+	"/admin/snippets":        {permView, permView},
+	"/admin/snippets/edit":   {permManageContent, permManageContent},
+	"/admin/snippets/delete": {permView, permManageContent},
+
+	"/admin/variables":        {permView, permView},
+	"/admin/variables/add":    {permView, permManageContent},
+	"/admin/variables/edit":   {permView, permManageContent},
+	"/admin/variables/delete": {permView, permManageContent},
+
+	"/admin/link-health":       {permView, permView},
+	"/admin/link-health/check": {permView, permEditDocs},
+
+	"/admin/users":        {permManageUsers, permManageUsers},
+	"/admin/users/add":    {permManageUsers, permManageUsers},
+	"/admin/users/edit":   {permManageUsers, permManageUsers},
+	"/admin/users/delete": {permManageUsers, permManageUsers},
+
+	"/admin/settings":       {permManageSettings, permManageSettings},
+	"/admin/login-attempts": {permManageUsers, permManageUsers},
+	"/admin/audit":          {permManageUsers, permManageUsers},
+	"/admin/audit/export":   {permManageUsers, permManageUsers},
+}
+
+// requiredPermission 取得請求所需的權限
+func requiredPermission(r *http.Request) permission {
+	rule, ok := routeRules[r.URL.Path]
+	if !ok {
+		return permManageUsers
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return rule.Read
+	}
+	return rule.Write
+}
+
+// forbidden 回應權限不足
+func forbidden(w http.ResponseWriter) {
+	http.Error(w, "權限不足", http.StatusForbidden)
+}
@@ -0,0 +1,471 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ---- QR Code 產生器 ----
+// 用於在伺服器端產生兩步驟驗證的 QR Code，不依賴外部服務或前端套件
+// 僅實作 byte 模式與 M 級錯誤更正，版本 1 到 15（最多約 400 位元組），足以容納 otpauth 網址
+
+// qrBlocks M 級錯誤更正的區塊配置：每區塊錯誤更正碼數、第一組區塊數與資料碼數、第二組區塊數與資料碼數
+var qrBlocks = [...][5]int{
+	1:  {10, 1, 16, 0, 0},
+	2:  {16, 1, 28, 0, 0},
+	3:  {26, 1, 44, 0, 0},
+	4:  {18, 2, 32, 0, 0},
+	5:  {24, 2, 43, 0, 0},
+	6:  {16, 4, 27, 0, 0},
+	7:  {18, 4, 31, 0, 0},
+	8:  {22, 2, 38, 2, 39},
+	9:  {22, 3, 36, 2, 37},
+	10: {26, 4, 43, 1, 44},
+	11: {30, 1, 50, 4, 51},
+	12: {22, 6, 36, 2, 37},
+	13: {22, 8, 37, 1, 38},
+	14: {24, 4, 40, 5, 41},
+	15: {24, 5, 41, 5, 42},
+}
+
+const qrMaxVersion = 15
+
+// qrCode QR Code 模組矩陣，true 為深色
+type qrCode struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool // 功能圖形（定位、校正、格式資訊等）所在位置，不可放置資料
+}
+
+// encodeQR 將文字編碼為 QR Code
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+
+	// 選擇可容納資料的最小版本
+	version := 0
+	for v := 1; v <= qrMaxVersion; v++ {
+		if len(data)*8+4+qrCountBits(v) <= qrDataCodewords(v)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("QR Code 內容過長")
+	}
+
+	codewords := qrAddErrorCorrection(qrEncodeData(data, version), version)
+
+	qr := &qrCode{version: version, size: version*4 + 17}
+	qr.modules = make([][]bool, qr.size)
+	qr.function = make([][]bool, qr.size)
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, qr.size)
+		qr.function[i] = make([]bool, qr.size)
+	}
+	qr.drawFunctionPatterns()
+	qr.drawCodewords(codewords)
+
+	// 選擇懲罰分數最低的遮罩
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		penalty := qr.penalty()
+		if bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // 遮罩為 XOR，再套用一次即可還原
+	}
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+	return qr, nil
+}
+
+// qrCountBits 字元數欄位的位元數
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// qrDataCodewords 資料碼總數
+func qrDataCodewords(version int) int {
+	b := qrBlocks[version]
+	return b[1]*b[2] + b[3]*b[4]
+}
+
+// qrEncodeData 以 byte 模式編碼資料並補齊至資料碼總數
+func qrEncodeData(data []byte, version int) []byte {
+	var bits []bool
+	appendBits := func(value, length int) {
+		for i := length - 1; i >= 0; i-- {
+			bits = append(bits, value>>i&1 == 1)
+		}
+	}
+
+	appendBits(0b0100, 4) // byte 模式
+	appendBits(len(data), qrCountBits(version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+
+	capacity := qrDataCodewords(version) * 8
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false) // 結束符號
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+
+	result := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return result
+}
+
+// qrAddErrorCorrection 分割區塊、計算錯誤更正碼並交錯排列
+func qrAddErrorCorrection(data []byte, version int) []byte {
+	b := qrBlocks[version]
+	ecLen := b[0]
+	generator := rsGenerator(ecLen)
+
+	var blocks, ecBlocks [][]byte
+	offset := 0
+	for g := 0; g < 2; g++ {
+		count, length := b[1+g*2], b[2+g*2]
+		for i := 0; i < count; i++ {
+			block := data[offset : offset+length]
+			offset += length
+			blocks = append(blocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, generator))
+		}
+	}
+
+	var result []byte
+	maxLen := b[2]
+	if b[4] > maxLen {
+		maxLen = b[4]
+	}
+	for i := 0; i < maxLen; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < ecLen; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// gfMultiply GF(256) 乘法，多項式 x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z >> 7
+		z = z<<1 ^ carry*0x1D
+		if y>>i&1 == 1 {
+			z ^= x
+		}
+	}
+	return z
+}
+
+// rsGenerator 產生 Reed-Solomon 生成多項式的係數（不含最高次項）
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	var root byte = 1
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder 計算資料除以生成多項式的餘數，即錯誤更正碼
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range generator {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// setFunction 設定功能圖形模組，x 為欄、y 為列
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+// drawFunctionPatterns 繪製定位圖形、時序圖形、校正圖形與版本資訊
+func (qr *qrCode) drawFunctionPatterns() {
+	// 時序圖形
+	for i := 0; i < qr.size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	// 三個角落的定位圖形（含分隔區）
+	for _, c := range [][2]int{{3, 3}, {qr.size - 4, 3}, {3, qr.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= qr.size || y < 0 || y >= qr.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				qr.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// 校正圖形，略過與定位圖形重疊的三個位置
+	positions := qr.alignmentPositions()
+	n := len(positions)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if i == 0 && j == 0 || i == 0 && j == n-1 || i == n-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(positions[i]+dx, positions[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// 先保留格式資訊的位置，實際內容在選定遮罩後寫入
+	qr.drawFormatBits(0)
+
+	// 版本 7 以上需要版本資訊
+	if qr.version >= 7 {
+		rem := qr.version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := qr.version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := qr.size-11+i%3, i/3
+			qr.setFunction(a, b, dark)
+			qr.setFunction(b, a, dark)
+		}
+	}
+}
+
+// alignmentPositions 校正圖形的中心座標
+func (qr *qrCode) alignmentPositions() []int {
+	if qr.version == 1 {
+		return nil
+	}
+	count := qr.version/7 + 2
+	step := (qr.version*4 + count*2 + 1) / (count*2 - 2) * 2
+	result := make([]int, count)
+	result[0] = 6
+	for i, pos := count-1, qr.size-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// drawFormatBits 寫入錯誤更正等級與遮罩編號
+func (qr *qrCode) drawFormatBits(mask int) {
+	data := 0b00<<3 | mask // M 級錯誤更正
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	// 左上角
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	// 右上角與左下角
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.size-8, true) // 固定的深色模組
+}
+
+// drawCodewords 以 Z 字形由右下角開始放置資料
+func (qr *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // 略過垂直時序圖形
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < qr.size; vert++ {
+			y := vert
+			if upward {
+				y = qr.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if qr.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				qr.modules[y][x] = data[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask 對資料模組套用遮罩
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty 依規格計算懲罰分數，分數越低越容易被掃描
+func (qr *qrCode) penalty() int {
+	score := 0
+	get := func(x, y int, vertical bool) bool {
+		if vertical {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < qr.size; y++ {
+			// 連續同色模組
+			run := 1
+			for x := 1; x < qr.size; x++ {
+				if get(x, y, vertical) == get(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				score += run - 2
+			}
+
+			// 類似定位圖形的 1:1:3:1:1 樣式
+			for x := 0; x+10 < qr.size; x++ {
+				var pattern [11]bool
+				for k := range pattern {
+					pattern[k] = get(x+k, y, vertical)
+				}
+				if pattern == [11]bool{true, false, true, true, true, false, true, false, false, false, false} ||
+					pattern == [11]bool{false, false, false, false, true, false, true, true, true, false, true} {
+					score += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 同色區塊
+	dark := 0
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < qr.size && y+1 < qr.size {
+				c := qr.modules[y][x]
+				if c == qr.modules[y][x+1] && c == qr.modules[y+1][x] && c == qr.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+
+	// 深色比例偏離 50%
+	total := qr.size * qr.size
+	score += abs(dark*20-total*10) / total * 10
+	return score
+}
+
+// svg 輸出 QR Code 的 SVG，四周保留 4 個模組的空白
+func (qr *qrCode) svg(pixels int) string {
+	const quiet = 4
+	dim := qr.size + quiet*2
+
+	var path strings.Builder
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#ffffff"/><path d="%s" fill="#000000"/></svg>`,
+		dim, dim, pixels, pixels, path.String())
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+
+	"support/obj"
+)
+
+// 前台文章使用的 Markdown 轉換器，啟用標題自動 ID 以便目錄錨點跳轉
+// 數學公式與 mermaid 圖表在伺服器端轉為 MathML 與 SVG，上傳的圖片加上不同寬度的版本
+var markdown = goldmark.New(
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	goldmark.WithExtensions(mathExtension{}, diagramExtension{}, imageExtension{}),
+)
+
+// 公式與圖表片段的快取上限，超過時整個清空
+const maxFragmentCache = 2000
+
+// 公式與圖表的轉換結果快取，以類型與內容雜湊為鍵
+// 同一段公式在多篇文章或重新渲染時不需要重新轉換
+var fragmentCache = struct {
+	sync.Mutex
+	items map[string]string
+}{items: map[string]string{}}
+
+// cachedFragment 依內容雜湊取得轉換結果，快取未命中時呼叫 render 並保存結果
+func cachedFragment(kind, src string, render func() string) string {
+	sum := sha256.Sum256([]byte(src))
+	key := kind + ":" + hex.EncodeToString(sum[:])
+
+	fragmentCache.Lock()
+	result, ok := fragmentCache.items[key]
+	fragmentCache.Unlock()
+	if ok {
+		return result
+	}
+
+	result = render()
+
+	fragmentCache.Lock()
+	if len(fragmentCache.items) >= maxFragmentCache {
+		fragmentCache.items = map[string]string{}
+	}
+	fragmentCache.items[key] = result
+	fragmentCache.Unlock()
+	return result
+}
+
+// renderedDoc 文章的渲染結果
+type renderedDoc struct {
+	HTML     string
+	TOC      []obj.TOCItem
+	Links    []docLink       // 文章中的站內連結與圖片引用
+	Snippets map[string]bool // 渲染時引用到的內容片段（含巢狀引用）
+	editDate time.Time       // 渲染時文章的最後編輯時間，用於判斷快取是否過期
+}
+
+// 文章渲染結果快取，以文章 ID 為鍵
+var renderCache = struct {
+	sync.RWMutex
+	docs map[uint]*renderedDoc
+}{docs: map[uint]*renderedDoc{}}
+
+// renderDoc 渲染文章內容，文章未修改時直接使用快取
+func renderDoc(doc obj.Doc) (*renderedDoc, error) {
+	renderCache.RLock()
+	cached, ok := renderCache.docs[doc.ID]
+	renderCache.RUnlock()
+	if ok && cached.editDate.Equal(doc.LastEditDate) {
+		return cached, nil
+	}
+
+	// URL 解碼
+	md, err := url.QueryUnescape(doc.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	// 展開內容片段
+	md, snippets := expandSnippets(md, lookupSnippet)
+
+	// 替換內容變數
+	values, err := loadVariables()
+	if err != nil {
+		return nil, err
+	}
+	md, undefined := substituteVariables(md, values)
+	if len(undefined) > 0 {
+		log.Printf("Doc %d uses undefined variables: %s", doc.ID, strings.Join(undefined, ", "))
+	}
+
+	// 文章關閉目錄時不擷取標題
+	tocDepth := 0
+	if doc.ShowTOC {
+		tocDepth = doc.TOCDepth
+		if tocDepth <= 0 {
+			tocDepth = obj.DefaultTOCDepth
+		}
+	}
+
+	rendered, err := renderMarkdown(md, tocDepth)
+	if err != nil {
+		return nil, err
+	}
+	rendered.Snippets = snippets
+	rendered.editDate = doc.LastEditDate
+
+	renderCache.Lock()
+	renderCache.docs[doc.ID] = rendered
+	renderCache.Unlock()
+	return rendered, nil
+}
+
+// invalidateDocRender 清除單篇文章的渲染快取
+func invalidateDocRender(docID uint) {
+	renderCache.Lock()
+	delete(renderCache.docs, docID)
+	renderCache.Unlock()
+}
+
+// invalidateSnippetRenders 清除所有引用了指定內容片段的文章快取
+func invalidateSnippetRenders(name string) {
+	renderCache.Lock()
+	for id, rendered := range renderCache.docs {
+		if rendered.Snippets[name] {
+			delete(renderCache.docs, id)
+		}
+	}
+	renderCache.Unlock()
+}
+
+// invalidateAllRenders 清除所有文章的渲染快取，用於影響全站的變更（例如內容變數）
+func invalidateAllRenders() {
+	renderCache.Lock()
+	renderCache.docs = map[uint]*renderedDoc{}
+	renderCache.Unlock()
+}
+
+// renderMarkdown 將 Markdown 轉為 HTML，並擷取不超過 tocDepth 層級的標題樹作為目錄
+// 以及文章中的站內連結；tocDepth 小於等於 0 時不產生目錄
+func renderMarkdown(md string, tocDepth int) (*renderedDoc, error) {
+	source := []byte(md)
+
+	// 使用自訂的 ID 產生器，讓中文標題也能得到可讀的錨點
+	ctx := parser.NewContext(parser.WithIDs(newHeadingIDs()))
+	root := markdown.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+
+	rendered := &renderedDoc{
+		Links: collectLinks(root),
+	}
+	if tocDepth > 0 {
+		rendered.TOC = buildTOC(root, source, tocDepth)
+	}
+
+	var buf bytes.Buffer
+	if err := markdown.Renderer().Render(&buf, source, root); err != nil {
+		return nil, err
+	}
+	rendered.HTML = buf.String()
+	return rendered, nil
+}
+
+// buildTOC 走訪 AST，將標題依層級組成樹狀目錄
+func buildTOC(root ast.Node, source []byte, maxLevel int) []obj.TOCItem {
+	// 先收集扁平的標題列表
+	var flat []obj.TOCItem
+	_ = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		heading, ok := n.(*ast.Heading)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		if heading.Level > maxLevel {
+			return ast.WalkSkipChildren, nil
+		}
+
+		var id string
+		if v, ok := heading.AttributeString("id"); ok {
+			if b, ok := v.([]byte); ok {
+				id = string(b)
+			}
+		}
+		flat = append(flat, obj.TOCItem{
+			Level: heading.Level,
+			ID:    id,
+			Text:  strings.TrimSpace(nodeText(heading, source)),
+		})
+		return ast.WalkSkipChildren, nil
+	})
+
+	return nestTOC(flat)
+}
+
+// nestTOC 將扁平的標題列表依層級巢狀化
+// 層級跳躍（例如 h2 直接接 h4）時，較深的標題會掛在最近的上層標題下
+func nestTOC(flat []obj.TOCItem) []obj.TOCItem {
+	var result []obj.TOCItem
+	for len(flat) > 0 {
+		item := flat[0]
+		flat = flat[1:]
+
+		// 收集屬於此標題的子標題
+		i := 0
+		for i < len(flat) && flat[i].Level > item.Level {
+			i++
+		}
+		item.Children = nestTOC(flat[:i])
+		flat = flat[i:]
+
+		result = append(result, item)
+	}
+	return result
+}
+
+// nodeText 取得節點內所有文字內容
+func nodeText(n ast.Node, source []byte) string {
+	var sb strings.Builder
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch v := c.(type) {
+		case *ast.Text:
+			sb.Write(v.Segment.Value(source))
+			if v.SoftLineBreak() || v.HardLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(v.Value)
+		case *ast.CodeSpan:
+			sb.WriteString(nodeText(v, source))
+		default:
+			sb.WriteString(nodeText(c, source))
+		}
+	}
+	return sb.String()
+}
+
+// headingIDs 實作 parser.IDs，保留中文等非 ASCII 文字
+// goldmark 預設的產生器會略過非 ASCII 字元，導致中文標題全部變成 "heading"
+type headingIDs struct {
+	values map[string]bool
+}
+
+func newHeadingIDs() parser.IDs {
+	return &headingIDs{values: map[string]bool{}}
+}
+
+func (s *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var sb strings.Builder
+	for _, r := range strings.TrimSpace(string(value)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			sb.WriteRune(unicode.ToLower(r))
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			sb.WriteByte('-')
+		}
+	}
+
+	result := sb.String()
+	if result == "" {
+		if kind == ast.KindHeading {
+			result = "heading"
+		} else {
+			result = "id"
+		}
+	}
+
+	// 重複的 ID 加上序號
+	if !s.values[result] {
+		s.values[result] = true
+		return []byte(result)
+	}
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", result, i)
+		if !s.values[candidate] {
+			s.values[candidate] = true
+			return []byte(candidate)
+		}
+	}
+}
+
+func (s *headingIDs) Put(value []byte) {
+	s.values[string(value)] = true
+}
@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+	"time"
+)
+
+const (
+	sessionIDBytes       = 32          // 會話ID的隨機位元組數（256 位元）
+	sessionTouchInterval = time.Minute // 更新會話最後使用時間的最短間隔，避免每個請求都寫入資料庫
+)
+
+// generateSessionID 使用 crypto/rand 生成無法預測的會話ID
+func generateSessionID() (string, error) {
+	b := make([]byte, sessionIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSessionID 計算會話ID的雜湊值
+// 資料庫只保存雜湊值，即使資料庫外洩也無法用來冒用會話
+func hashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// startAdminSession 為使用者建立新的會話並設置 Cookie
+// 請求中原有的會話會一併作廢，確保登入與修改密碼後都會更換會話ID
+func startAdminSession(w http.ResponseWriter, r *http.Request, username string) (*obj.AdminSession, error) {
+	if cookie, err := r.Cookie(AdminSessionCookieName); err == nil {
+		db.DeleteAdminSession(hashSessionID(cookie.Value))
+	}
+
+	sessionID, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+	expiry := time.Now().Add(AdminSessionTimeout)
+
+	// 保存會話到資料庫，同時記錄登入裝置資訊
+	session := &obj.AdminSession{
+		SessionID: hashSessionID(sessionID),
+		Username:  username,
+		Expiry:    expiry,
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	err = db.SaveAdminSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	// 設置會話Cookie
+	cookie := &http.Cookie{
+		Name:     AdminSessionCookieName,
+		Value:    sessionID,
+		Expires:  expiry,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, cookie)
+	return session, nil
+}
+
+// touchAdminSession 更新會話的最後使用時間
+func touchAdminSession(session *obj.AdminSession) {
+	now := time.Now()
+	if now.Sub(session.LastSeen) < sessionTouchInterval {
+		return
+	}
+	err := db.TouchAdminSession(session.SessionID, now)
+	if err != nil {
+		log.Println("Session touch error:", err)
+	}
+}
+
+// trustedProxies 可信任的反向代理，只有直接連線的位址在其中時才採用轉送標頭中的來源 IP
+var trustedProxies []netip.Prefix
+
+// SetTrustedProxies 設定可信任的反向代理，以逗號分隔的 IP 或 CIDR，例如 "127.0.0.1,10.0.0.0/8"。
+// 未設定時一律使用直接連線的位址，應在伺服器啟動時呼叫
+func SetTrustedProxies(list string) error {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return fmt.Errorf("無效的反向代理位址 %q: %w", item, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return fmt.Errorf("無效的反向代理位址 %q: %w", item, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	trustedProxies = prefixes
+	return nil
+}
+
+// isTrustedProxy 位址是否為可信任的反向代理
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 取得請求來源 IP，用於登入次數限制與各項紀錄。
+// 轉送標頭可由任何用戶端偽造，只有直接連線的位址是可信任的反向代理時才採用：
+// X-Forwarded-For 由右往左略過可信任的代理，取第一個不受信任的位址；沒有時使用 X-Real-IP
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				// 格式錯誤的位址無法判斷來源，使用最後一個確認過的代理
+				return ip
+			}
+			if !isTrustedProxy(hop) {
+				return hop
+			}
+			ip = hop
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		if _, err := netip.ParseAddr(realIP); err == nil {
+			return realIP
+		}
+	}
+	return ip
+}
+
+// describeUserAgent 將 User-Agent 簡化為「瀏覽器 / 作業系統」
+func describeUserAgent(ua string) string {
+	if ua == "" {
+		return "未知裝置"
+	}
+
+	// 順序很重要：Edge 與 Opera 的 User-Agent 也包含 Chrome，Chrome 的也包含 Safari
+	browser := "其他瀏覽器"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := "其他系統"
+	for _, o := range []struct{ token, name string }{
+		{"Windows", "Windows"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"},
+		{"Mac OS X", "macOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			system = o.name
+			break
+		}
+	}
+	return browser + " / " + system
+}
+
+// AdminSessionsHandler 處理登入裝置（會話）管理頁面
+func AdminSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	// 獲取當前的管理員會話
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 從資料庫獲取該用戶的所有會話
+	sessions, err := db.GetUserAdminSessions(session.Username)
+	if err != nil {
+		log.Println("Error fetching sessions:", err)
+	}
+	infos := make([]obj.AdminSessionInfo, len(sessions))
+	for i, s := range sessions {
+		infos[i] = obj.AdminSessionInfo{
+			AdminSession: s,
+			Device:       describeUserAgent(s.UserAgent),
+			Current:      s.SessionID == session.SessionID,
+		}
+	}
+
+	// 檢查是否有訊息要顯示
+	message := r.URL.Query().Get("message")
+	messageType := r.URL.Query().Get("type")
+	if messageType == "" && message != "" {
+		messageType = "success" // 預設訊息類型
+	}
+
+	// 準備模板資料
+	data := map[string]interface{}{
+		"Active":      "sessions",
+		"Sessions":    infos,
+		"Message":     message,
+		"MessageType": messageType,
+		"Username":    session.Username,
+		"CSRFToken":   csrfToken(r),
+	}
+
+	// 解析模板
+	tmpl, err := template.ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/sessions.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminSessionRevokeHandler 處理登出單一裝置
+func AdminSessionRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 獲取會話 ID
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 32)
+	if err != nil {
+		log.Println("Invalid session ID:", err)
+		redirectWithMessage(w, r, "/admin/sessions", "無效的會話ID", "danger")
+		return
+	}
+
+	// 只能刪除自己的會話
+	err = db.DeleteUserAdminSession(session.Username, uint(id))
+	if err != nil {
+		log.Println("Error revoking session:", err)
+		redirectWithMessage(w, r, "/admin/sessions", "找不到該會話", "danger")
+		return
+	}
+	recordAuditAs(r, session.Username, auditEntry{Action: obj.AuditSessionRevoke, TargetType: "session", TargetID: uint(id)})
+
+	// 登出的是目前的裝置時，直接回到登入頁面
+	if uint(id) == session.ID {
+		http.Redirect(w, r, "/admin/logout", http.StatusSeeOther)
+		return
+	}
+	redirectWithMessage(w, r, "/admin/sessions", "已登出該裝置", "success")
+}
+
+// AdminSessionRevokeAllHandler 處理登出所有裝置，scope 為 others 時保留目前的會話
+func AdminSessionRevokeAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	keep := ""
+	if r.FormValue("scope") == "others" {
+		keep = session.SessionID
+	}
+	err = db.DeleteOtherAdminSessions(session.Username, keep)
+	if err != nil {
+		log.Println("Error revoking sessions:", err)
+		redirectWithMessage(w, r, "/admin/sessions", "登出裝置失敗: "+err.Error(), "danger")
+		return
+	}
+	scope := "所有裝置"
+	if keep != "" {
+		scope = "其他裝置"
+	}
+	recordAuditAs(r, session.Username, auditEntry{Action: obj.AuditSessionRevoke, TargetType: "session", TargetName: scope})
+
+	if keep == "" {
+		http.Redirect(w, r, "/admin/logout", http.StatusSeeOther)
+		return
+	}
+	redirectWithMessage(w, r, "/admin/sessions", "已登出其他所有裝置", "success")
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+)
+
+// AdminSettingsHandler 處理系統設定頁面
+func AdminSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 處理儲存設定
+	if r.Method == http.MethodPost {
+		err = r.ParseForm()
+		if err != nil {
+			log.Println("Form parse error:", err)
+			redirectWithMessage(w, r, "/admin/settings", "表單解析錯誤", "danger")
+			return
+		}
+
+		require := r.FormValue("require_2fa") == "true"
+		// 要求兩步驟驗證前，目前的管理員必須先啟用，避免把自己鎖在後台外
+		if require && !session.TwoFactor {
+			redirectWithMessage(w, r, "/admin/settings", "請先為自己的帳號啟用兩步驟驗證", "danger")
+			return
+		}
+
+		values := map[string]string{
+			obj.SettingRequire2FA:          strconv.FormatBool(require),
+			obj.SettingPasswordBlockCommon: strconv.FormatBool(r.FormValue(obj.SettingPasswordBlockCommon) == "true"),
+		}
+
+		// 登入限制與密碼規則，必須是整數，max 為 0 表示沒有上限
+		limits := []struct {
+			key          string
+			label        string
+			min, max     int
+			defaultValue int
+		}{
+			{obj.SettingLoginMaxFailures, "帳號連續失敗次數上限", 1, 0, defaultLoginMaxFailures},
+			{obj.SettingLoginMaxIPFailures, "IP 失敗次數上限", 1, 0, defaultLoginMaxIPFailures},
+			{obj.SettingLoginLockMinutes, "鎖定時間", 1, 0, defaultLoginLockMinutes},
+			{obj.SettingLoginBackoffSecond, "失敗後等待秒數", 0, 0, defaultLoginBackoffSecond},
+			{obj.SettingPasswordMinLength, "密碼最短長度", 8, passwordMaxLength, defaultPasswordMinLength},
+			{obj.SettingPasswordMinClasses, "字元種類數", 1, 4, defaultPasswordMinClasses},
+			{obj.SettingPasswordHistory, "不可重複使用的密碼次數", 0, passwordMaxHistory, defaultPasswordHistory},
+		}
+		for _, limit := range limits {
+			n, err := strconv.Atoi(strings.TrimSpace(r.FormValue(limit.key)))
+			if limit.max > 0 && (err != nil || n < limit.min || n > limit.max) {
+				redirectWithMessage(w, r, "/admin/settings", fmt.Sprintf("%s必須是 %d 到 %d 之間的整數", limit.label, limit.min, limit.max), "danger")
+				return
+			}
+			if err != nil || n < limit.min {
+				redirectWithMessage(w, r, "/admin/settings", fmt.Sprintf("%s必須是不小於 %d 的整數", limit.label, limit.min), "danger")
+				return
+			}
+			values[limit.key] = strconv.Itoa(n)
+		}
+
+		// 依固定順序儲存，並記錄有變更的設定
+		keys := []string{obj.SettingRequire2FA, obj.SettingPasswordBlockCommon}
+		defaults := map[string]string{obj.SettingRequire2FA: "false", obj.SettingPasswordBlockCommon: "true"}
+		for _, limit := range limits {
+			keys = append(keys, limit.key)
+			defaults[limit.key] = strconv.Itoa(limit.defaultValue)
+		}
+		var before, after []string
+		for _, key := range keys {
+			old := db.GetSetting(key, defaults[key])
+			if old == values[key] {
+				continue
+			}
+			err = db.SetSetting(key, values[key])
+			if err != nil {
+				log.Println("Error saving setting:", err)
+				redirectWithMessage(w, r, "/admin/settings", "儲存設定失敗: "+err.Error(), "danger")
+				return
+			}
+			before = append(before, key+"="+old)
+			after = append(after, key+"="+values[key])
+		}
+		if len(after) > 0 {
+			recordAudit(r, auditEntry{
+				Action:     obj.AuditSettingsUpdate,
+				TargetType: "settings",
+				Before:     strings.Join(before, "；"),
+				After:      strings.Join(after, "；"),
+			})
+		}
+		redirectWithMessage(w, r, "/admin/settings", "設定已儲存", "success")
+		return
+	}
+
+	// 檢查是否有訊息要顯示
+	message := r.URL.Query().Get("message")
+	messageType := r.URL.Query().Get("type")
+	if messageType == "" && message != "" {
+		messageType = "success" // 預設訊息類型
+	}
+
+	// 準備模板資料
+	data := map[string]interface{}{
+		"Active":         "settings",
+		"Require2FA":     require2FA(),
+		"LoginLimits":    getLoginLimits(),
+		"PasswordPolicy": getPasswordPolicy(),
+		"Message":        message,
+		"MessageType":    messageType,
+		"Username":       session.Username,
+		"CSRFToken":      csrfToken(r),
+	}
+
+	// 解析模板
+	tmpl, err := template.ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/settings.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+)
+
+// showSetupPage 顯示初始設定頁面
+func showSetupPage(w http.ResponseWriter, r *http.Request, username, errorMessage string) {
+	policy := getPasswordPolicy()
+	tmpl, err := template.ParseFiles("templates/admin/setup.html")
+	if err != nil {
+		log.Println("Setup template parse error:", err)
+		http.Error(w, "模板解析錯誤", http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, map[string]interface{}{
+		"Username":     username,
+		"DefaultUser":  username == db.DefaultAdminUsername,
+		"MinLength":    policy.MinLength,
+		"PolicyText":   policy.Description(),
+		"ErrorMessage": errorMessage,
+		"CSRFToken":    csrfToken(r),
+	})
+	if err != nil {
+		log.Println("Setup template execute error:", err)
+		http.Error(w, "模板執行錯誤", http.StatusInternalServerError)
+	}
+}
+
+// AdminSetupHandler 處理初始設定：仍在使用預設帳號密碼的管理員必須設定新的帳號名稱與強密碼
+func AdminSetupHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	if !session.NeedSetup {
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		showSetupPage(w, r, session.Username, "")
+		return
+	}
+
+	// 解析表單
+	err = r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		showSetupPage(w, r, session.Username, "表單解析錯誤")
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
+	email, ok := normalizeEmail(r.FormValue("email"))
+
+	// 驗證表單
+	if username == "" {
+		showSetupPage(w, r, session.Username, "使用者名稱不能為空")
+		return
+	}
+	if !ok {
+		showSetupPage(w, r, session.Username, "電子郵件格式不正確")
+		return
+	}
+	if username == db.DefaultAdminUsername {
+		showSetupPage(w, r, session.Username, "請使用「"+db.DefaultAdminUsername+"」以外的使用者名稱")
+		return
+	}
+	if password != confirmPassword {
+		showSetupPage(w, r, session.Username, "兩次輸入的密碼不一致")
+		return
+	}
+	if _, err := db.GetUserByUsername(username); err == nil {
+		showSetupPage(w, r, session.Username, "使用者名稱已被使用")
+		return
+	}
+
+	user, err := db.GetUserByUsername(session.Username)
+	if err != nil {
+		log.Println("User query error:", err)
+		showSetupPage(w, r, session.Username, "找不到使用者")
+		return
+	}
+	// 以新的使用者名稱檢查密碼
+	renamed := user
+	renamed.Username = username
+	if reason := checkNewPassword(renamed, password); reason != "" {
+		showSetupPage(w, r, session.Username, reason)
+		return
+	}
+	hashedPassword, err := db.HashPassword(password)
+	if err == nil {
+		err = db.CompleteInitialSetup(user.ID, user.Username, username, email, hashedPassword)
+	}
+	if err != nil {
+		log.Println("Initial setup error:", err)
+		showSetupPage(w, r, session.Username, "儲存失敗: "+err.Error())
+		return
+	}
+	log.Printf("Initial setup completed: %s renamed to %s", user.Username, username)
+	recordAuditAs(r, username, auditEntry{
+		Action:     obj.AuditInitialSetup,
+		TargetType: "user",
+		TargetID:   user.ID,
+		TargetName: username,
+		Before:     "使用者名稱：" + user.Username,
+		After:      "使用者名稱：" + username,
+	})
+
+	// 舊的會話已全部刪除，以新的帳號名稱重新登入
+	_, err = startAdminSession(w, r, username)
+	if err != nil {
+		log.Println("Session save error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	redirectWithMessage(w, r, "/admin/dashboard", "初始設定完成，之後請使用新的帳號密碼登入", "success")
+}
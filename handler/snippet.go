@@ -0,0 +1,403 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+)
+
+// 內容片段引用語法：{{< include "name" >}}
+var snippetIncludePattern = regexp.MustCompile(`\{\{<\s*include\s+"([^"]+)"\s*>\}\}`)
+
+// 內容片段名稱僅允許小寫英數字、連字號與底線
+var snippetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// 巢狀引用的最大深度，避免過深的展開
+const maxSnippetDepth = 10
+
+// snippetLookup 依名稱取得內容片段的 Markdown，找不到時回傳 false
+type snippetLookup func(name string) (string, bool)
+
+// lookupSnippet 從資料庫讀取內容片段
+func lookupSnippet(name string) (string, bool) {
+	snippet, err := db.GetSnippetByName(name)
+	if err != nil {
+		return "", false
+	}
+	content, err := url.QueryUnescape(snippet.Content)
+	if err != nil {
+		log.Println("Snippet URL decode error:", err)
+		return "", false
+	}
+	return content, true
+}
+
+// expandSnippets 展開 Markdown 中的所有內容片段引用，並回傳引用到的片段名稱（含巢狀引用）
+// 找不到的片段與循環引用會以 HTML 註解取代，不影響其餘內容
+func expandSnippets(md string, lookup snippetLookup) (string, map[string]bool) {
+	used := map[string]bool{}
+	return expandSnippetsIn(md, nil, used, lookup), used
+}
+
+func expandSnippetsIn(md string, stack []string, used map[string]bool, lookup snippetLookup) string {
+	return snippetIncludePattern.ReplaceAllStringFunc(md, func(directive string) string {
+		name := snippetIncludePattern.FindStringSubmatch(directive)[1]
+		used[name] = true
+
+		for _, s := range stack {
+			if s == name {
+				log.Printf("Snippet include cycle: %s", strings.Join(append(stack, name), " -> "))
+				return "<!-- 內容片段循環引用: " + strings.Join(append(stack, name), " -> ") + " -->"
+			}
+		}
+		if len(stack) >= maxSnippetDepth {
+			log.Printf("Snippet include too deep: %s", strings.Join(append(stack, name), " -> "))
+			return "<!-- 內容片段引用層數過深: " + name + " -->"
+		}
+
+		content, ok := lookup(name)
+		if !ok {
+			log.Println("Snippet not found:", name)
+			return "<!-- 找不到內容片段: " + name + " -->"
+		}
+
+		// 複製堆疊，避免兄弟節點共用底層陣列
+		next := append(append([]string{}, stack...), name)
+		return expandSnippetsIn(content, next, used, lookup)
+	})
+}
+
+// snippetIncludes 取得 Markdown 中直接引用的片段名稱
+func snippetIncludes(md string) []string {
+	var names []string
+	for _, match := range snippetIncludePattern.FindAllStringSubmatch(md, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// findSnippetCycle 檢查以 content 作為片段 name 的內容時是否會造成循環引用
+// 有循環時回傳循環路徑，否則回傳 nil
+func findSnippetCycle(name, content string) []string {
+	lookup := func(n string) (string, bool) {
+		if n == name {
+			return content, true
+		}
+		return lookupSnippet(n)
+	}
+
+	var visit func(n string, stack []string) []string
+	visit = func(n string, stack []string) []string {
+		for _, s := range stack {
+			if s == n {
+				return append(stack, n)
+			}
+		}
+		md, ok := lookup(n)
+		if !ok {
+			return nil
+		}
+		next := append(append([]string{}, stack...), n)
+		for _, include := range snippetIncludes(md) {
+			if cycle := visit(include, next); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return visit(name, nil)
+}
+
+// buildSnippetUsage 計算每個內容片段被哪些文章使用（含透過其他片段間接引用）
+func buildSnippetUsage() (map[string][]obj.Doc, error) {
+	snippets, err := db.GetSnippetList()
+	if err != nil {
+		return nil, err
+	}
+	docs, err := db.GetAllDocs()
+	if err != nil {
+		return nil, err
+	}
+
+	// 片段之間的直接引用關係
+	deps := make(map[string][]string, len(snippets))
+	for _, snippet := range snippets {
+		content, err := url.QueryUnescape(snippet.Content)
+		if err != nil {
+			continue
+		}
+		deps[snippet.Name] = snippetIncludes(content)
+	}
+
+	usage := make(map[string][]obj.Doc)
+	for _, doc := range docs {
+		content, err := url.QueryUnescape(doc.Content)
+		if err != nil {
+			continue
+		}
+
+		// 走訪文章直接及間接引用的所有片段
+		visited := map[string]bool{}
+		queue := snippetIncludes(content)
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			if visited[name] {
+				continue
+			}
+			visited[name] = true
+			usage[name] = append(usage[name], doc)
+			queue = append(queue, deps[name]...)
+		}
+	}
+	return usage, nil
+}
+
+// AdminSnippetsHandler 處理內容片段管理頁面
+func AdminSnippetsHandler(w http.ResponseWriter, r *http.Request) {
+	// 獲取當前的管理員會話
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 從資料庫獲取片段列表
+	snippets, err := db.GetSnippetList()
+	if err != nil {
+		log.Println("Error fetching snippets:", err)
+	}
+
+	// 計算各片段被引用的文章
+	usage, err := buildSnippetUsage()
+	if err != nil {
+		log.Println("Error building snippet usage:", err)
+	}
+
+	// 檢查是否有訊息要顯示
+	message := r.URL.Query().Get("message")
+	messageType := r.URL.Query().Get("type")
+	if messageType == "" && message != "" {
+		messageType = "success" // 預設訊息類型
+	}
+
+	// 準備模板資料
+	data := map[string]interface{}{
+		"Active":      "snippets",
+		"Snippets":    snippets,
+		"Usage":       usage,
+		"Message":     message,
+		"MessageType": messageType,
+		"Username":    session.Username,
+		"CSRFToken":   csrfToken(r),
+	}
+
+	// 解析模板
+	tmpl, err := template.ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/snippets.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminSnippetEditHandler 處理新增及編輯內容片段
+func AdminSnippetEditHandler(w http.ResponseWriter, r *http.Request) {
+	// 獲取當前的管理員會話
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 從 URL 或表單中獲取片段 ID，0 表示新增
+	var snippet obj.Snippet
+	idStr := r.FormValue("id")
+	if idStr != "" && idStr != "0" {
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			log.Println("Invalid snippet ID:", err)
+			redirectWithMessage(w, r, "/admin/snippets", "無效的片段ID", "danger")
+			return
+		}
+		snippet, err = db.GetSnippet(uint(id))
+		if err != nil {
+			log.Println("Error fetching snippet:", err)
+			redirectWithMessage(w, r, "/admin/snippets", "找不到內容片段", "danger")
+			return
+		}
+	}
+	isNew := snippet.ID == 0
+	oldName := snippet.Name
+
+	// 解碼片段內容，用於編輯
+	content, err := url.QueryUnescape(snippet.Content)
+	if err != nil {
+		log.Println("URL decode error:", err)
+		content = snippet.Content
+	}
+
+	var errorMessage string
+	if r.Method == http.MethodPost {
+		before := ""
+		if !isNew {
+			before = snippetAuditSummary(snippet, content)
+		}
+		snippet.Name = strings.TrimSpace(r.FormValue("name"))
+		snippet.Title = strings.TrimSpace(r.FormValue("title"))
+		content = r.FormValue("content")
+
+		// 驗證表單
+		if !snippetNamePattern.MatchString(snippet.Name) {
+			errorMessage = "片段名稱只能包含小寫英文字母、數字、連字號與底線"
+		} else if cycle := findSnippetCycle(snippet.Name, content); cycle != nil {
+			errorMessage = "內容片段循環引用: " + strings.Join(cycle, " -> ")
+		} else {
+			snippet.Content = url.QueryEscape(content)
+			if isNew {
+				err = db.AddSnippet(&snippet)
+			} else {
+				err = db.UpdateSnippet(&snippet)
+			}
+			if err != nil {
+				log.Println("Error saving snippet:", err)
+				errorMessage = "儲存內容片段失敗: " + err.Error()
+			} else {
+				updateSnippetImageUsage(snippet.ID, content)
+			}
+		}
+
+		if errorMessage == "" {
+			recordAudit(r, auditEntry{
+				Action:     obj.AuditSnippetSave,
+				TargetType: "snippet",
+				TargetID:   snippet.ID,
+				TargetName: snippet.Name,
+				Before:     before,
+				After:      snippetAuditSummary(snippet, content),
+			})
+
+			// 清除引用此片段的文章快取，名稱變更時新舊名稱都需要清除
+			invalidateSnippetRenders(snippet.Name)
+			if oldName != "" && oldName != snippet.Name {
+				invalidateSnippetRenders(oldName)
+			}
+			redirectWithMessage(w, r, "/admin/snippets", "成功儲存內容片段: "+snippet.Name, "success")
+			return
+		}
+	}
+
+	// 準備模板資料
+	data := map[string]interface{}{
+		"Active":         "snippets",
+		"Snippet":        snippet,
+		"SnippetContent": content,
+		"IsNew":          isNew,
+		"Error":          errorMessage,
+		"Username":       session.Username,
+		"CSRFToken":      csrfToken(r),
+		"UndefinedVars":  undefinedVariables(content),
+		"VariableKeys":   variableKeys(),
+	}
+
+	// 解析模板
+	tmpl, err := template.ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/snippet_edit.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminSnippetDeleteHandler 處理刪除內容片段
+func AdminSnippetDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+		return
+	}
+
+	// 解析表單
+	err := r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/snippets", "表單解析錯誤", "danger")
+		return
+	}
+
+	// 獲取片段 ID
+	idStr := r.FormValue("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		log.Println("Invalid snippet ID:", err)
+		redirectWithMessage(w, r, "/admin/snippets", "無效的片段ID", "danger")
+		return
+	}
+
+	snippet, err := db.GetSnippet(uint(id))
+	if err != nil {
+		log.Println("Error fetching snippet:", err)
+		redirectWithMessage(w, r, "/admin/snippets", "找不到內容片段", "danger")
+		return
+	}
+
+	// 仍被文章引用的片段不允許刪除
+	usage, err := buildSnippetUsage()
+	if err != nil {
+		log.Println("Error building snippet usage:", err)
+		redirectWithMessage(w, r, "/admin/snippets", "無法檢查片段使用狀況", "danger")
+		return
+	}
+	if docs := usage[snippet.Name]; len(docs) > 0 {
+		redirectWithMessage(w, r, "/admin/snippets",
+			"內容片段仍被 "+strconv.Itoa(len(docs))+" 篇文章使用，無法刪除", "danger")
+		return
+	}
+
+	err = db.DeleteSnippet(snippet.ID)
+	if err != nil {
+		log.Println("Error deleting snippet:", err)
+		redirectWithMessage(w, r, "/admin/snippets", "刪除內容片段失敗: "+err.Error(), "danger")
+		return
+	}
+	invalidateSnippetRenders(snippet.Name)
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditSnippetDelete,
+		TargetType: "snippet",
+		TargetID:   snippet.ID,
+		TargetName: snippet.Name,
+		Before:     snippetAuditSummary(snippet, decodedSnippetContent(snippet)),
+	})
+
+	// 重定向回片段列表，並帶上成功訊息
+	redirectWithMessage(w, r, "/admin/snippets", "內容片段已成功刪除", "success")
+}
@@ -0,0 +1,538 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"support/db"
+	"support/obj"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ---- 兩步驟驗證（RFC 6238 TOTP）----
+
+const (
+	totpIssuer       = "支援中心"
+	totpPeriod       = 30 // 每組驗證碼的有效秒數
+	totpDigits       = 6
+	totpSkew         = 1  // 允許前後各一個時間步的時鐘誤差
+	totpSecretBytes  = 20 // 160 位元金鑰，與 HMAC-SHA1 的輸出長度相同
+	recoveryCodeNum  = 10
+	twoFactorTimeout = 5 * time.Minute // 輸入密碼後完成第二步驟的期限
+	twoFactorMaxTry  = 5               // 第二步驟允許的錯誤次數
+
+	TwoFactorCookieName = "admin_2fa_pending"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret 產生隨機的 TOTP 金鑰
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode 計算指定時間步的驗證碼
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// RFC 4226 動態截斷
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// verifyTOTP 驗證驗證碼，成功時回傳對應的時間步
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI 產生驗證器 App 使用的 otpauth 網址
+func totpURI(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// generateRecoveryCodes 產生一組備用碼，回傳明碼與雜湊值
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeNum)
+	hashes := make([]string, recoveryCodeNum)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b)) // 8 個字元
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode 計算備用碼的雜湊值，忽略大小寫與連字號
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// require2FA 是否要求所有後台使用者啟用兩步驟驗證
+func require2FA() bool {
+	return db.GetSetting(obj.SettingRequire2FA, "false") == "true"
+}
+
+// verifySecondFactor 驗證 TOTP 驗證碼或備用碼
+func verifySecondFactor(user obj.User, code string) bool {
+	if step, ok := verifyTOTP(user.TOTPSecret, code, time.Now()); ok {
+		// 同一組驗證碼只能使用一次
+		used, err := db.UseTOTPStep(user.ID, step)
+		if err != nil {
+			log.Println("TOTP step update error:", err)
+			return false
+		}
+		return used
+	}
+
+	used, err := db.UseRecoveryCode(user.ID, hashRecoveryCode(code))
+	if err != nil {
+		log.Println("Recovery code error:", err)
+		return false
+	}
+	if used {
+		log.Printf("User %s logged in with a recovery code", user.Username)
+	}
+	return used
+}
+
+// ---- 登入第二步驟 ----
+
+// pendingLogin 已通過密碼驗證、等待輸入驗證碼的登入
+type pendingLogin struct {
+	username string
+	expiry   time.Time
+	attempts int
+}
+
+// 等待第二步驟的登入，以隨機 token 的雜湊值為鍵
+var pendingLogins = struct {
+	sync.Mutex
+	items map[string]*pendingLogin
+}{items: map[string]*pendingLogin{}}
+
+// startPendingLogin 記錄通過密碼驗證的使用者，並設置第二步驟使用的 Cookie
+func startPendingLogin(w http.ResponseWriter, username string) error {
+	token, err := generateSessionID()
+	if err != nil {
+		return err
+	}
+	expiry := time.Now().Add(twoFactorTimeout)
+
+	pendingLogins.Lock()
+	// 順便清除過期的項目
+	for key, p := range pendingLogins.items {
+		if time.Now().After(p.expiry) {
+			delete(pendingLogins.items, key)
+		}
+	}
+	pendingLogins.items[hashSessionID(token)] = &pendingLogin{username: username, expiry: expiry}
+	pendingLogins.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     TwoFactorCookieName,
+		Value:    token,
+		Expires:  expiry,
+		Path:     "/admin/login",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// clearPendingLogin 清除第二步驟的 Cookie
+func clearPendingLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     TwoFactorCookieName,
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		Path:     "/admin/login",
+		HttpOnly: true,
+	})
+}
+
+// showTwoFactorLogin 顯示輸入驗證碼的頁面
+func showTwoFactorLogin(w http.ResponseWriter, errorMessage string) {
+	tmpl, err := template.ParseFiles("templates/admin/login_2fa.html")
+	if err != nil {
+		log.Println("Login template parse error:", err)
+		http.Error(w, "模板解析錯誤", http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, map[string]interface{}{"ErrorMessage": errorMessage})
+	if err != nil {
+		log.Println("Login template execute error:", err)
+		http.Error(w, "模板執行錯誤", http.StatusInternalServerError)
+	}
+}
+
+// AdminLogin2FAHandler 處理登入的第二步驟：驗證 TOTP 驗證碼或備用碼
+func AdminLogin2FAHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(TwoFactorCookieName)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	key := hashSessionID(cookie.Value)
+
+	pendingLogins.Lock()
+	pending, ok := pendingLogins.items[key]
+	if ok && time.Now().After(pending.expiry) {
+		delete(pendingLogins.items, key)
+		ok = false
+	}
+	pendingLogins.Unlock()
+	if !ok {
+		clearPendingLogin(w)
+		showLoginError(w, r, "驗證逾時，請重新登入")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		showTwoFactorLogin(w, "")
+		return
+	}
+
+	user, err := db.GetUserByUsername(pending.username)
+	if err != nil || user.Disabled || !user.TOTPEnabled {
+		clearPendingLogin(w)
+		showLoginError(w, r, "用戶名或密碼錯誤")
+		return
+	}
+
+	// 驗證碼錯誤同樣計入登入失敗次數
+	if err := checkLoginAllowed(user.Username, clientIP(r)); err != nil {
+		// 失敗後的短暫等待不需要重新輸入密碼
+		var throttled *loginThrottledError
+		if errors.As(err, &throttled) && !throttled.Locked {
+			w.Header().Set("Retry-After", throttled.RetryAfter())
+			w.WriteHeader(http.StatusTooManyRequests)
+			showTwoFactorLogin(w, throttled.Message())
+			return
+		}
+		pendingLogins.Lock()
+		delete(pendingLogins.items, key)
+		pendingLogins.Unlock()
+		clearPendingLogin(w)
+		showLoginFailure(w, r, err)
+		return
+	}
+
+	if !verifySecondFactor(user, r.FormValue("code")) {
+		recordLoginAttempt(r, user.Username, false, false, "驗證碼錯誤")
+		pendingLogins.Lock()
+		pending.attempts++
+		tooMany := pending.attempts >= twoFactorMaxTry
+		if tooMany {
+			delete(pendingLogins.items, key)
+		}
+		pendingLogins.Unlock()
+
+		if tooMany {
+			clearPendingLogin(w)
+			showLoginError(w, r, "驗證碼錯誤次數過多，請重新登入")
+			return
+		}
+		showTwoFactorLogin(w, "驗證碼錯誤")
+		return
+	}
+
+	pendingLogins.Lock()
+	delete(pendingLogins.items, key)
+	pendingLogins.Unlock()
+	clearPendingLogin(w)
+
+	// 驗證成功，創建新的會話
+	_, err = startAdminSession(w, r, user.Username)
+	if err != nil {
+		log.Println("Session save error:", err)
+		showLoginError(w, r, "創建會話失敗")
+		return
+	}
+	recordLoginAttempt(r, user.Username, true, false, "")
+	recordAuditAs(r, user.Username, auditEntry{Action: obj.AuditLogin, After: "已通過兩步驟驗證"})
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// ---- 兩步驟驗證設定頁面 ----
+
+// renderTwoFactorPage 渲染兩步驟驗證設定頁面
+func renderTwoFactorPage(w http.ResponseWriter, r *http.Request, session *obj.AdminSession, extra map[string]interface{}) {
+	user, err := db.GetUserByUsername(session.Username)
+	if err != nil {
+		log.Println("User query error:", err)
+		http.Error(w, "找不到使用者", http.StatusInternalServerError)
+		return
+	}
+
+	// 檢查是否有訊息要顯示
+	message := r.URL.Query().Get("message")
+	messageType := r.URL.Query().Get("type")
+	if messageType == "" && message != "" {
+		messageType = "success" // 預設訊息類型
+	}
+
+	data := map[string]interface{}{
+		"Active":      "two_factor",
+		"Enabled":     user.TOTPEnabled,
+		"Required":    require2FA(),
+		"Message":     message,
+		"MessageType": messageType,
+		"Username":    session.Username,
+		"CSRFToken":   csrfToken(r),
+	}
+
+	if user.TOTPEnabled {
+		count, err := db.CountRecoveryCodes(user.ID)
+		if err != nil {
+			log.Println("Error counting recovery codes:", err)
+		}
+		data["RecoveryCodeCount"] = count
+	} else {
+		// 尚未啟用時產生新的金鑰，確認驗證碼後才會啟用
+		if user.TOTPSecret == "" {
+			user.TOTPSecret, err = generateTOTPSecret()
+			if err == nil {
+				err = db.SetUserTOTPSecret(user.ID, user.TOTPSecret)
+			}
+			if err != nil {
+				log.Println("TOTP secret error:", err)
+				http.Error(w, "產生金鑰失敗", http.StatusInternalServerError)
+				return
+			}
+		}
+		qr, err := encodeQR(totpURI(user.Username, user.TOTPSecret))
+		if err != nil {
+			log.Println("QR code error:", err)
+		} else {
+			data["QRCode"] = qr.svg(200)
+		}
+		// 每 4 個字元分組，方便手動輸入
+		var groups []string
+		for i := 0; i < len(user.TOTPSecret); i += 4 {
+			groups = append(groups, user.TOTPSecret[i:min(i+4, len(user.TOTPSecret))])
+		}
+		data["Secret"] = strings.Join(groups, " ")
+	}
+
+	for k, v := range extra {
+		data[k] = v
+	}
+
+	// 解析模板
+	tmpl, err := template.ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/two_factor.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminTwoFactorHandler 處理兩步驟驗證設定頁面
+func AdminTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	renderTwoFactorPage(w, r, session, nil)
+}
+
+// AdminTwoFactorEnableHandler 處理啟用兩步驟驗證，需輸入驗證器 App 顯示的驗證碼
+func AdminTwoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	user, err := db.GetUserByUsername(session.Username)
+	if err != nil {
+		log.Println("User query error:", err)
+		redirectWithMessage(w, r, "/admin/2fa", "找不到使用者", "danger")
+		return
+	}
+	if user.TOTPEnabled {
+		redirectWithMessage(w, r, "/admin/2fa", "已啟用兩步驟驗證", "info")
+		return
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, r.FormValue("code"), time.Now())
+	if !ok {
+		redirectWithMessage(w, r, "/admin/2fa", "驗證碼錯誤，請確認手機時間是否正確", "danger")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err == nil {
+		err = db.EnableUserTOTP(user.ID, step, hashes)
+	}
+	if err != nil {
+		log.Println("Enable TOTP error:", err)
+		redirectWithMessage(w, r, "/admin/2fa", "啟用兩步驟驗證失敗: "+err.Error(), "danger")
+		return
+	}
+	recordAudit(r, auditEntry{Action: obj.AuditTwoFactorOn, TargetType: "user", TargetID: user.ID, TargetName: user.Username})
+
+	// 備用碼只在此時顯示一次
+	renderTwoFactorPage(w, r, session, map[string]interface{}{
+		"RecoveryCodes": codes,
+		"Message":       "已啟用兩步驟驗證，請妥善保存以下備用碼",
+		"MessageType":   "success",
+	})
+}
+
+// AdminTwoFactorDisableHandler 處理停用兩步驟驗證
+func AdminTwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	if require2FA() {
+		redirectWithMessage(w, r, "/admin/2fa", "網站要求所有使用者啟用兩步驟驗證，無法停用", "danger")
+		return
+	}
+
+	user, err := db.GetUserByUsername(session.Username)
+	if err != nil {
+		log.Println("User query error:", err)
+		redirectWithMessage(w, r, "/admin/2fa", "找不到使用者", "danger")
+		return
+	}
+	if !verifySecondFactor(user, r.FormValue("code")) {
+		redirectWithMessage(w, r, "/admin/2fa", "驗證碼錯誤", "danger")
+		return
+	}
+
+	err = db.DisableUserTOTP(user.ID)
+	if err != nil {
+		log.Println("Disable TOTP error:", err)
+		redirectWithMessage(w, r, "/admin/2fa", "停用兩步驟驗證失敗: "+err.Error(), "danger")
+		return
+	}
+	recordAudit(r, auditEntry{Action: obj.AuditTwoFactorOff, TargetType: "user", TargetID: user.ID, TargetName: user.Username})
+	redirectWithMessage(w, r, "/admin/2fa", "已停用兩步驟驗證", "success")
+}
+
+// AdminRecoveryCodesHandler 處理重新產生備用碼，舊的備用碼會全部失效
+func AdminRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	user, err := db.GetUserByUsername(session.Username)
+	if err != nil || !user.TOTPEnabled {
+		redirectWithMessage(w, r, "/admin/2fa", "尚未啟用兩步驟驗證", "danger")
+		return
+	}
+
+	// 只接受 TOTP 驗證碼，避免用備用碼換取新的備用碼
+	step, ok := verifyTOTP(user.TOTPSecret, r.FormValue("code"), time.Now())
+	if ok {
+		ok, err = db.UseTOTPStep(user.ID, step)
+		if err != nil {
+			log.Println("TOTP step update error:", err)
+		}
+	}
+	if !ok {
+		redirectWithMessage(w, r, "/admin/2fa", "驗證碼錯誤", "danger")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err == nil {
+		err = db.ReplaceRecoveryCodes(user.ID, hashes)
+	}
+	if err != nil {
+		log.Println("Recovery codes error:", err)
+		redirectWithMessage(w, r, "/admin/2fa", "產生備用碼失敗: "+err.Error(), "danger")
+		return
+	}
+	recordAudit(r, auditEntry{Action: obj.AuditRecoveryCodes, TargetType: "user", TargetID: user.ID, TargetName: user.Username})
+
+	renderTwoFactorPage(w, r, session, map[string]interface{}{
+		"RecoveryCodes": codes,
+		"Message":       "已產生新的備用碼，舊的備用碼已失效",
+		"MessageType":   "success",
+	})
+}
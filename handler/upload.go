@@ -0,0 +1,308 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // 註冊 GIF 解碼器
+	_ "image/jpeg" // 註冊 JPEG 解碼器
+	_ "image/png"  // 註冊 PNG 解碼器
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"support/db"
+	"support/obj"
+	"time"
+
+	_ "golang.org/x/image/webp" // 註冊 WebP 解碼器
+)
+
+// 圖片上傳的限制
+const (
+	maxImageUploadSize = 10 << 20   // 單張圖片最大 10 MB
+	maxImageDimension  = 8000       // 寬或高最多 8000 像素
+	maxImagePixels     = 40_000_000 // 總像素最多四千萬，避免解碼時耗盡記憶體
+)
+
+// allowedImageTypes 允許上傳的圖片格式與儲存時使用的副檔名。
+// 不接受 SVG，因為 SVG 可以內嵌腳本
+var allowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// imageFormats image 套件回報的格式名稱對應的 MIME 類型
+var imageFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// 上傳失敗的錯誤代碼，供編輯器等程式判斷失敗原因
+const (
+	uploadErrBadRequest   = "bad_request"      // 表單或參數錯誤
+	uploadErrTooLarge     = "too_large"        // 超過檔案或請求的大小限制
+	uploadErrTooManyFiles = "too_many_files"   // 批次上傳的檔案數量過多
+	uploadErrUnsupported  = "unsupported_type" // 不允許的檔案格式
+	uploadErrInvalidFile  = "invalid_file"     // 格式正確但內容損毀或不符合限制
+	uploadErrNotFound     = "upload_not_found" // 分段上傳不存在或已過期
+	uploadErrOffset       = "offset_mismatch"  // 分段的位置與已接收的大小不符
+	uploadErrIncomplete   = "incomplete"       // 分段上傳尚未接收完整
+	uploadErrServer       = "server_error"     // 伺服器處理或保存失敗
+)
+
+// uploadErrorStatus 錯誤代碼對應的 HTTP 狀態碼
+var uploadErrorStatus = map[string]int{
+	uploadErrBadRequest:   http.StatusBadRequest,
+	uploadErrTooLarge:     http.StatusRequestEntityTooLarge,
+	uploadErrTooManyFiles: http.StatusBadRequest,
+	uploadErrUnsupported:  http.StatusUnsupportedMediaType,
+	uploadErrInvalidFile:  http.StatusUnsupportedMediaType,
+	uploadErrNotFound:     http.StatusNotFound,
+	uploadErrOffset:       http.StatusConflict,
+	uploadErrIncomplete:   http.StatusConflict,
+	uploadErrServer:       http.StatusInternalServerError,
+}
+
+// uploadError 上傳失敗的原因，Message 可直接顯示給使用者
+type uploadError struct {
+	Code    string
+	Message string
+}
+
+func (e *uploadError) Error() string { return e.Message }
+
+// Status 錯誤對應的 HTTP 狀態碼
+func (e *uploadError) Status() int {
+	if status, ok := uploadErrorStatus[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// newUploadError 建立上傳錯誤
+func newUploadError(code, message string) *uploadError {
+	return &uploadError{Code: code, Message: message}
+}
+
+// validateImage 依檔案內容判斷圖片格式並完整解碼驗證，不採信用戶端送出的 Content-Type 與檔名。
+// 回傳解碼後的圖片與實際的 MIME 類型
+func validateImage(file io.ReadSeeker) (img image.Image, contentType string, err error) {
+	// 以檔頭的特徵位元組判斷格式
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, "", newUploadError(uploadErrInvalidFile, "無法讀取檔案")
+	}
+	contentType = http.DetectContentType(head[:n])
+	if _, ok := allowedImageTypes[contentType]; !ok {
+		return nil, "", newUploadError(uploadErrUnsupported, "僅支持 JPEG、PNG、GIF、WebP 圖片")
+	}
+
+	// 先只讀取尺寸，過大的圖片不進行解碼
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	config, format, err := image.DecodeConfig(file)
+	if err != nil || imageFormats[format] != contentType {
+		return nil, "", newUploadError(uploadErrInvalidFile, "圖片格式錯誤或已損毀")
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, "", newUploadError(uploadErrInvalidFile, "圖片尺寸錯誤")
+	}
+	if config.Width > maxImageDimension || config.Height > maxImageDimension || config.Width*config.Height > maxImagePixels {
+		return nil, "", newUploadError(uploadErrInvalidFile, fmt.Sprintf("圖片尺寸過大，寬高最多 %d 像素", maxImageDimension))
+	}
+
+	// 完整解碼，確認整個檔案都是有效的圖片
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	img, _, err = image.Decode(file)
+	if err != nil {
+		return nil, "", newUploadError(uploadErrInvalidFile, "圖片格式錯誤或已損毀")
+	}
+	return img, contentType, nil
+}
+
+// imageUploadError 依上傳來源回應錯誤：編輯器使用 JSON，圖片管理頁面導回並顯示訊息
+func imageUploadError(w http.ResponseWriter, r *http.Request, e *uploadError) {
+	if r.FormValue("source") != "editor" {
+		redirectWithMessage(w, r, "/admin/images", e.Message, "danger")
+		return
+	}
+	writeUploadFailure(w, e)
+}
+
+// storeUpload 檢查並保存一個上傳的檔案，圖片重新編碼後保存，其他檔案作為附件保存。
+// 內容相同的檔案已上傳過時回傳既有的記錄，duplicate 為 true
+func storeUpload(r *http.Request, file multipart.File, name string, size int64, alt, caption string) (image obj.Image, duplicate bool, e *uploadError) {
+	if reason := checkImageMeta(alt, caption, ""); reason != "" {
+		return obj.Image{}, false, newUploadError(uploadErrBadRequest, reason)
+	}
+	if !isImageUpload(file) {
+		return storeAttachment(r, file, name, size, alt, caption)
+	}
+	return storeImage(r, file, name, size, alt, caption)
+}
+
+// storeImage 檢查圖片格式後重新編碼並保存
+func storeImage(r *http.Request, file multipart.File, name string, fileSize int64, alt, caption string) (obj.Image, bool, *uploadError) {
+	if fileSize > maxImageUploadSize {
+		return obj.Image{}, false, newUploadError(uploadErrTooLarge, "圖片不能超過 "+sizeLabel(maxImageUploadSize))
+	}
+
+	// 依檔案內容檢查圖片格式
+	decoded, contentType, err := validateImage(file)
+	if err != nil {
+		log.Printf("Rejected image upload %q: %v", name, err)
+		var invalid *uploadError
+		if errors.As(err, &invalid) {
+			return obj.Image{}, false, invalid
+		}
+		return obj.Image{}, false, newUploadError(uploadErrServer, "讀取文件失敗")
+	}
+
+	// 重新編碼並去除 EXIF，副檔名由實際保存的格式決定
+	data, contentType, size, err := normalizeImage(file, decoded, contentType)
+	if err != nil {
+		log.Println("Image processing error:", err)
+		return obj.Image{}, false, newUploadError(uploadErrServer, "圖片處理失敗")
+	}
+
+	// 內容相同的圖片已上傳過時，直接使用既有的圖片
+	hash := contentHash(data)
+	if existing, ok := findDuplicateImage(hash); ok {
+		log.Printf("Image upload %q matches existing image %d", name, existing.ID)
+		return existing, true, nil
+	}
+
+	// 生成唯一文件名，保存到設定的儲存後端
+	filename := generateUniqueFilename() + allowedImageTypes[contentType]
+	err = db.Uploads.Put(filename, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		log.Println("File write error:", err)
+		return obj.Image{}, false, newUploadError(uploadErrServer, "保存文件失敗")
+	}
+
+	// 創建圖片記錄，URL 使用 /uploads/ 作為前綴
+	image := obj.Image{
+		Filename:    name,
+		Path:        db.Uploads.Location(filename),
+		URL:         db.UploadURLPath + filename,
+		Size:        int64(len(data)),
+		ContentType: contentType,
+		Kind:        obj.ImageKindImage,
+		ContentHash: hash,
+		Width:       size.X,
+		Height:      size.Y,
+		Alt:         alt,
+		Caption:     caption,
+		UploadTime:  time.Now(),
+	}
+	if err := db.AddImage(&image); err != nil {
+		log.Println("Image DB save error:", err)
+		db.Uploads.Delete(filename)
+		return obj.Image{}, false, newUploadError(uploadErrServer, "保存圖片記錄失敗")
+	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditImageUpload,
+		TargetType: "image",
+		TargetID:   image.ID,
+		TargetName: image.Filename,
+		After:      imageAuditSummary(image),
+	})
+	return image, false, nil
+}
+
+// UploadsHandler 提供上傳的檔案。只以允許的圖片、影片與附件格式回應，並禁止瀏覽器猜測內容類型，
+// 避免舊資料中偽裝成圖片的 HTML 或 SVG 在網站的網域下執行
+func UploadsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 不提供目錄列表
+		if r.URL.Path == "" || strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/")
+
+		// 以上傳時判斷的格式回應，下載的附件使用原本的檔名
+		if record, err := db.GetImageByURL(db.UploadURLPath + key); err == nil {
+			serveUpload(w, r, key, record.ContentType, record.Filename)
+			return
+		}
+		serveUpload(w, r, key, "", "")
+	})
+}
+
+// serveUpload 從儲存後端提供檔案，支援 Range 與條件式請求，影片可以拖曳播放進度。
+// contentType 為空字串時依副檔名判斷，name 為空字串時使用儲存的檔名
+func serveUpload(w http.ResponseWriter, r *http.Request, key, contentType, name string) {
+	file, info, err := db.Uploads.Open(key)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error opening upload %s: %v", key, err)
+		}
+		w.Header().Del("Cache-Control")
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	if name == "" {
+		name = path.Base(key)
+	}
+	if contentType == "" {
+		contentType = uploadTypeByExt(path.Ext(key))
+	}
+	setUploadHeaders(w, name, contentType)
+	http.ServeContent(w, r, name, info.ModTime, file)
+}
+
+// uploadTypeByExt 依副檔名判斷沒有記錄的檔案格式，無法判斷時視為二進位檔案
+func uploadTypeByExt(ext string) string {
+	for t, e := range allowedImageTypes {
+		if strings.EqualFold(ext, e) {
+			return t
+		}
+	}
+	for t, attachment := range allowedAttachmentTypes {
+		if attachment.Ext != "" && strings.EqualFold(ext, attachment.Ext) {
+			return t
+		}
+	}
+	return "application/octet-stream"
+}
+
+// setUploadHeaders 設定上傳檔案的回應標頭。圖片與影片在瀏覽器中直接顯示，
+// 其他允許的格式以原本的類型下載，未知的格式一律視為二進位檔案下載
+func setUploadHeaders(w http.ResponseWriter, name, contentType string) {
+	disposition := "inline"
+	if _, ok := allowedImageTypes[contentType]; !ok {
+		attachment, ok := allowedAttachmentTypes[contentType]
+		if !ok {
+			contentType = "application/octet-stream"
+		}
+		if !attachment.Inline {
+			disposition = "attachment"
+		}
+	}
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	h.Set("Content-Type", contentType)
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": name}); value != "" {
+		h.Set("Content-Disposition", value)
+	} else {
+		h.Set("Content-Disposition", disposition)
+	}
+}
@@ -0,0 +1,416 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"support/db"
+	"sync"
+	"time"
+)
+
+// 批次與分段上傳的限制
+const (
+	maxBatchUploadSize       = 100 << 20      // 一次批次上傳的合計大小，較大的檔案應使用分段上傳
+	maxBatchUploadFiles      = 20             // 一次批次上傳最多的檔案數量
+	uploadChunkSize          = 4 << 20        // 分段上傳每段的最大大小
+	maxChunkedUploadsPerUser = 5              // 每位使用者同時進行中的分段上傳數量
+	chunkedUploadExpiry      = 24 * time.Hour // 超過此時間沒有新的分段即捨棄
+)
+
+// writeUploadJSON 以 JSON 回應上傳相關的請求
+func writeUploadJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeUploadFailure 以 JSON 回應整個請求失敗的原因
+func writeUploadFailure(w http.ResponseWriter, e *uploadError) {
+	writeUploadJSON(w, e.Status(), failedUploadResult("", e))
+}
+
+// ---- 批次上傳 ----
+
+// batchUploadResponse 批次上傳的結果，Results 與上傳的檔案順序相同
+type batchUploadResponse struct {
+	Success  bool           `json:"success"` // 所有檔案都上傳成功
+	Uploaded int            `json:"uploaded"`
+	Failed   int            `json:"failed"`
+	Results  []uploadResult `json:"results"`
+}
+
+// AdminImageBatchUploadHandler 一次上傳多個檔案（表單欄位 files），以 JSON 回應每個檔案的結果。
+// 個別檔案失敗不影響其他檔案
+func AdminImageBatchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, "僅支持 POST 請求"))
+		return
+	}
+
+	// 限制整個請求的大小，保留一些空間給表單的欄位與分隔
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchUploadSize+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		log.Println("Parse form error:", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeUploadFailure(w, newUploadError(uploadErrTooLarge,
+				"單次上傳合計不能超過 "+sizeLabel(maxBatchUploadSize)+"，較大的檔案請使用分段上傳"))
+			return
+		}
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, "表單解析錯誤"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	headers := r.MultipartForm.File["files"]
+	if len(headers) == 0 {
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, "請選擇要上傳的檔案"))
+		return
+	}
+	if len(headers) > maxBatchUploadFiles {
+		writeUploadFailure(w, newUploadError(uploadErrTooManyFiles,
+			"一次最多上傳 "+strconv.Itoa(maxBatchUploadFiles)+" 個檔案"))
+		return
+	}
+
+	response := batchUploadResponse{Results: make([]uploadResult, 0, len(headers))}
+	for _, header := range headers {
+		result := storeBatchFile(r, header)
+		if result.Success {
+			response.Uploaded++
+		} else {
+			response.Failed++
+		}
+		response.Results = append(response.Results, result)
+	}
+	response.Success = response.Failed == 0
+	writeUploadJSON(w, http.StatusOK, response)
+}
+
+// storeBatchFile 保存批次上傳中的一個檔案
+func storeBatchFile(r *http.Request, header *multipart.FileHeader) uploadResult {
+	file, err := header.Open()
+	if err != nil {
+		log.Println("Getting form file error:", err)
+		return failedUploadResult(header.Filename, newUploadError(uploadErrServer, "讀取文件失敗"))
+	}
+	defer file.Close()
+
+	image, duplicate, uploadErr := storeUpload(r, file, header.Filename, header.Size, "", "")
+	if uploadErr != nil {
+		return failedUploadResult(header.Filename, uploadErr)
+	}
+	return newUploadResult(image, "", "", duplicate)
+}
+
+// ---- 分段上傳 ----
+// 大檔案先以 /admin/images/chunks/start 建立上傳，再依序將每段內容 POST 到
+// /admin/images/chunks?id=…&offset=…，全部送出後以 /admin/images/chunks/complete 完成。
+// 連線中斷時以 GET /admin/images/chunks?id=… 查詢已接收的大小，從該處繼續上傳。
+// 進行中的上傳只保存在記憶體中，伺服器重新啟動後需要重新上傳
+
+// chunkedUpload 進行中的分段上傳
+type chunkedUpload struct {
+	mu       sync.Mutex
+	ID       string
+	Username string // 建立上傳的使用者，其他使用者無法存取
+	Filename string
+	Size     int64
+	Received int64
+	Alt      string
+	Caption  string
+	Updated  time.Time
+	closed   bool // 已完成或取消
+}
+
+// file 暫存檔的路徑
+func (u *chunkedUpload) file() string {
+	return filepath.Join(db.ChunkStoragePath, u.ID+".part")
+}
+
+var (
+	chunkedUploadsMu sync.Mutex
+	chunkedUploads   = map[string]*chunkedUpload{}
+)
+
+// chunkStatus 分段上傳的進度，失敗時附上錯誤代碼與訊息
+type chunkStatus struct {
+	UploadID  string `json:"upload_id"`
+	Offset    int64  `json:"offset"` // 已接收的位元組數，下一段應從此處開始
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Code      string `json:"code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (u *chunkedUpload) status() chunkStatus {
+	return chunkStatus{UploadID: u.ID, Offset: u.Received, Size: u.Size, ChunkSize: uploadChunkSize}
+}
+
+// newChunkedUploadID 產生無法猜測的上傳 ID
+func newChunkedUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// expireChunkedUploads 捨棄過期的分段上傳，並刪除伺服器重新啟動前留下的暫存檔
+func expireChunkedUploads() {
+	chunkedUploadsMu.Lock()
+	defer chunkedUploadsMu.Unlock()
+
+	deadline := time.Now().Add(-chunkedUploadExpiry)
+	for id, u := range chunkedUploads {
+		// 正在處理中的上傳不會過期
+		if !u.mu.TryLock() {
+			continue
+		}
+		if u.Updated.Before(deadline) {
+			u.closed = true
+			os.Remove(u.file())
+			delete(chunkedUploads, id)
+		}
+		u.mu.Unlock()
+	}
+
+	entries, err := os.ReadDir(db.ChunkStoragePath)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if _, ok := chunkedUploads[strings.TrimSuffix(entry.Name(), ".part")]; ok {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(deadline) {
+			os.Remove(filepath.Join(db.ChunkStoragePath, entry.Name()))
+		}
+	}
+}
+
+// getChunkedUpload 取得目前使用者的分段上傳並鎖定，使用完畢後須呼叫 u.mu.Unlock
+func getChunkedUpload(r *http.Request) (*chunkedUpload, *uploadError) {
+	session, err := getAdminSession(r)
+	if err != nil {
+		return nil, newUploadError(uploadErrNotFound, "找不到上傳")
+	}
+	chunkedUploadsMu.Lock()
+	u, ok := chunkedUploads[r.URL.Query().Get("id")]
+	chunkedUploadsMu.Unlock()
+	if !ok || u.Username != session.Username {
+		return nil, newUploadError(uploadErrNotFound, "找不到上傳，可能已過期，請重新上傳")
+	}
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return nil, newUploadError(uploadErrNotFound, "找不到上傳，可能已過期，請重新上傳")
+	}
+	return u, nil
+}
+
+// closeChunkedUpload 結束分段上傳並刪除暫存檔，呼叫時須持有 u.mu
+func closeChunkedUpload(u *chunkedUpload) {
+	u.closed = true
+	os.Remove(u.file())
+	chunkedUploadsMu.Lock()
+	delete(chunkedUploads, u.ID)
+	chunkedUploadsMu.Unlock()
+}
+
+// AdminImageChunkStartHandler 建立分段上傳，表單欄位為 filename、size 以及選填的 alt、caption
+func AdminImageChunkStartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, "僅支持 POST 請求"))
+		return
+	}
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Error(w, "未授權", http.StatusUnauthorized)
+		return
+	}
+
+	filename := path.Base(strings.ReplaceAll(strings.TrimSpace(r.FormValue("filename")), "\\", "/"))
+	if filename == "" || filename == "." || filename == "/" {
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, "缺少檔名"))
+		return
+	}
+	size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+	if err != nil || size <= 0 {
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, "檔案大小錯誤"))
+		return
+	}
+	if size > maxUploadSize {
+		writeUploadFailure(w, newUploadError(uploadErrTooLarge, "檔案不能超過 "+sizeLabel(maxUploadSize)))
+		return
+	}
+	alt := strings.TrimSpace(r.FormValue("alt"))
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	if reason := checkImageMeta(alt, caption, ""); reason != "" {
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, reason))
+		return
+	}
+
+	expireChunkedUploads()
+	chunkedUploadsMu.Lock()
+	defer chunkedUploadsMu.Unlock()
+	active := 0
+	for _, u := range chunkedUploads {
+		if u.Username == session.Username {
+			active++
+		}
+	}
+	if active >= maxChunkedUploadsPerUser {
+		writeUploadFailure(w, newUploadError(uploadErrTooManyFiles, "進行中的上傳過多，請等待其他檔案上傳完成"))
+		return
+	}
+
+	id, err := newChunkedUploadID()
+	if err != nil {
+		log.Println("Error generating upload ID:", err)
+		writeUploadFailure(w, newUploadError(uploadErrServer, "建立上傳失敗"))
+		return
+	}
+	u := &chunkedUpload{
+		ID:       id,
+		Username: session.Username,
+		Filename: filename,
+		Size:     size,
+		Alt:      alt,
+		Caption:  caption,
+		Updated:  time.Now(),
+	}
+	if err := os.MkdirAll(db.ChunkStoragePath, 0755); err != nil {
+		log.Println("Error creating chunk directory:", err)
+		writeUploadFailure(w, newUploadError(uploadErrServer, "建立上傳失敗"))
+		return
+	}
+	f, err := os.OpenFile(u.file(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Println("Error creating chunk file:", err)
+		writeUploadFailure(w, newUploadError(uploadErrServer, "建立上傳失敗"))
+		return
+	}
+	f.Close()
+	chunkedUploads[id] = u
+	writeUploadJSON(w, http.StatusOK, u.status())
+}
+
+// AdminImageChunkHandler 處理分段上傳的內容：GET 查詢進度、POST 接收一段內容、DELETE 取消上傳
+func AdminImageChunkHandler(w http.ResponseWriter, r *http.Request) {
+	u, uploadErr := getChunkedUpload(r)
+	if uploadErr != nil {
+		writeUploadFailure(w, uploadErr)
+		return
+	}
+	defer u.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeUploadJSON(w, http.StatusOK, u.status())
+	case http.MethodDelete:
+		closeChunkedUpload(u)
+		writeUploadJSON(w, http.StatusOK, map[string]bool{"success": true})
+	case http.MethodPost, http.MethodPut:
+		receiveChunk(w, r, u)
+	default:
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, "不支持的HTTP方法"))
+	}
+}
+
+// receiveChunk 將請求內容寫入暫存檔的 offset 位置。offset 必須等於已接收的大小，
+// 不符時回應目前的進度讓用戶端從正確的位置繼續
+func receiveChunk(w http.ResponseWriter, r *http.Request, u *chunkedUpload) {
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset != u.Received {
+		status := u.status()
+		status.Code, status.Error = uploadErrOffset, "分段位置錯誤，請從已接收的位置繼續上傳"
+		writeUploadJSON(w, http.StatusConflict, status)
+		return
+	}
+
+	f, err := os.OpenFile(u.file(), os.O_WRONLY, 0)
+	if err != nil {
+		log.Println("Error opening chunk file:", err)
+		writeUploadFailure(w, newUploadError(uploadErrServer, "保存分段失敗"))
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Println("Error seeking chunk file:", err)
+		writeUploadFailure(w, newUploadError(uploadErrServer, "保存分段失敗"))
+		return
+	}
+
+	// 每段不能超過分段大小，也不能超過宣告的檔案大小
+	limit := min(int64(uploadChunkSize), u.Size-u.Received)
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	n, err := io.Copy(f, r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		// 捨棄這一段已寫入的內容
+		f.Truncate(offset)
+		writeUploadFailure(w, newUploadError(uploadErrTooLarge, "分段超過大小限制"))
+		return
+	}
+	// 連線中斷時保留已寫入的部分，用戶端查詢進度後從該處繼續
+	u.Received += n
+	u.Updated = time.Now()
+	if err != nil {
+		log.Printf("Chunk upload %s interrupted at %d: %v", u.ID, u.Received, err)
+		status := u.status()
+		status.Code, status.Error = uploadErrIncomplete, "分段傳送中斷，請從已接收的位置繼續上傳"
+		writeUploadJSON(w, http.StatusConflict, status)
+		return
+	}
+	writeUploadJSON(w, http.StatusOK, u.status())
+}
+
+// AdminImageChunkCompleteHandler 完成分段上傳，檢查並保存組合後的檔案，回應與單一檔案上傳相同的結果
+func AdminImageChunkCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeUploadFailure(w, newUploadError(uploadErrBadRequest, "僅支持 POST 請求"))
+		return
+	}
+	u, uploadErr := getChunkedUpload(r)
+	if uploadErr != nil {
+		writeUploadFailure(w, uploadErr)
+		return
+	}
+	defer u.mu.Unlock()
+
+	if u.Received != u.Size {
+		status := u.status()
+		status.Code, status.Error = uploadErrIncomplete, "檔案尚未上傳完整"
+		writeUploadJSON(w, http.StatusConflict, status)
+		return
+	}
+
+	// 無論成功與否都結束這次上傳，內容有誤時重新上傳也不會改變結果
+	defer closeChunkedUpload(u)
+	f, err := os.Open(u.file())
+	if err != nil {
+		log.Println("Error opening chunk file:", err)
+		writeUploadFailure(w, newUploadError(uploadErrServer, "讀取文件失敗"))
+		return
+	}
+	defer f.Close()
+
+	image, duplicate, uploadErr := storeUpload(r, f, u.Filename, u.Size, u.Alt, u.Caption)
+	if uploadErr != nil {
+		writeUploadJSON(w, uploadErr.Status(), failedUploadResult(u.Filename, uploadErr))
+		return
+	}
+	writeUploadJSON(w, http.StatusOK, newUploadResult(image, u.Alt, u.Caption, duplicate))
+}
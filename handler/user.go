@@ -0,0 +1,351 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+)
+
+// validRole 檢查角色名稱是否有效
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// ensureOtherAdmin 確認移除此管理員後仍至少有一位可用的管理員
+func ensureOtherAdmin(user obj.User) (bool, error) {
+	if user.Role != obj.RoleAdmin || user.Disabled {
+		return true, nil
+	}
+	count, err := db.CountActiveAdmins()
+	if err != nil {
+		return false, err
+	}
+	return count > 1, nil
+}
+
+// userAuditSummary 使用者的摘要
+func userAuditSummary(role, email string, disabled bool) string {
+	status := "啟用"
+	if disabled {
+		status = "停用"
+	}
+	summary := "角色：" + obj.RoleLabel(role) + "；狀態：" + status
+	if email != "" {
+		summary += "；電子郵件：" + email
+	}
+	return summary
+}
+
+// normalizeEmail 檢查並整理電子郵件地址，空字串視為未設定
+func normalizeEmail(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", true
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s {
+		return "", false
+	}
+	return addr.Address, true
+}
+
+// AdminUsersHandler 處理使用者管理頁面
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	// 獲取當前的管理員會話
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 從資料庫獲取使用者列表
+	users, err := db.GetUserList()
+	if err != nil {
+		log.Println("Error fetching users:", err)
+	}
+
+	// 檢查是否有訊息要顯示
+	message := r.URL.Query().Get("message")
+	messageType := r.URL.Query().Get("type")
+	if messageType == "" && message != "" {
+		messageType = "success" // 預設訊息類型
+	}
+
+	// 準備模板資料
+	policy := getPasswordPolicy()
+	data := map[string]interface{}{
+		"Active":      "users",
+		"Users":       users,
+		"Roles":       obj.Roles,
+		"MinLength":   policy.MinLength,
+		"PolicyText":  policy.Description(),
+		"Message":     message,
+		"MessageType": messageType,
+		"Username":    session.Username,
+		"CSRFToken":   csrfToken(r),
+	}
+
+	// 解析模板
+	tmpl, err := template.New("layout.html").Funcs(template.FuncMap{
+		"roleLabel": obj.RoleLabel,
+	}).ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/users.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminUserAddHandler 處理新增使用者
+func AdminUserAddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	// 解析表單
+	err := r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/users", "表單解析錯誤", "danger")
+		return
+	}
+
+	// 獲取使用者資料
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	role := r.FormValue("role")
+	email, ok := normalizeEmail(r.FormValue("email"))
+
+	// 驗證表單
+	if username == "" {
+		redirectWithMessage(w, r, "/admin/users", "使用者名稱不能為空", "danger")
+		return
+	}
+	if reason := checkNewPassword(obj.User{Username: username}, password); reason != "" {
+		redirectWithMessage(w, r, "/admin/users", reason, "danger")
+		return
+	}
+	if !validRole(role) {
+		redirectWithMessage(w, r, "/admin/users", "無效的角色", "danger")
+		return
+	}
+	if !ok {
+		redirectWithMessage(w, r, "/admin/users", "電子郵件格式不正確", "danger")
+		return
+	}
+
+	hashedPassword, err := db.HashPassword(password)
+	if err != nil {
+		log.Println("Password hash error:", err)
+		redirectWithMessage(w, r, "/admin/users", "新增使用者失敗", "danger")
+		return
+	}
+
+	// 保存到資料庫
+	user := obj.User{
+		Username: username,
+		Password: hashedPassword,
+		Role:     role,
+		Email:    email,
+	}
+	err = db.AddUser(&user)
+	if err != nil {
+		log.Println("Error adding user:", err)
+		redirectWithMessage(w, r, "/admin/users", "新增使用者失敗: "+err.Error(), "danger")
+		return
+	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditUserCreate,
+		TargetType: "user",
+		TargetID:   user.ID,
+		TargetName: username,
+		After:      userAuditSummary(user.Role, user.Email, false),
+	})
+
+	// 重定向回使用者列表，並帶上成功訊息
+	redirectWithMessage(w, r, "/admin/users", "成功新增使用者: "+username, "success")
+}
+
+// AdminUserEditHandler 處理變更使用者角色與停用狀態
+func AdminUserEditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 解析表單
+	err = r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/users", "表單解析錯誤", "danger")
+		return
+	}
+
+	// 獲取使用者 ID 和資料
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 32)
+	if err != nil {
+		log.Println("Invalid ID:", err)
+		redirectWithMessage(w, r, "/admin/users", "無效的ID", "danger")
+		return
+	}
+	role := r.FormValue("role")
+	disabled := r.FormValue("disabled") == "true"
+	if !validRole(role) {
+		redirectWithMessage(w, r, "/admin/users", "無效的角色", "danger")
+		return
+	}
+	email, ok := normalizeEmail(r.FormValue("email"))
+	if !ok {
+		redirectWithMessage(w, r, "/admin/users", "電子郵件格式不正確", "danger")
+		return
+	}
+
+	user, err := db.GetUser(uint(id))
+	if err != nil {
+		log.Println("Error fetching user:", err)
+		redirectWithMessage(w, r, "/admin/users", "找不到使用者", "danger")
+		return
+	}
+
+	// 不能停用自己或變更自己的角色，避免把自己鎖在後台外
+	if user.Username == session.Username && (disabled || role != user.Role) {
+		redirectWithMessage(w, r, "/admin/users", "不能停用自己或變更自己的角色", "danger")
+		return
+	}
+
+	// 至少保留一位可用的管理員
+	if role != obj.RoleAdmin || disabled {
+		ok, err := ensureOtherAdmin(user)
+		if err != nil {
+			log.Println("Error counting admins:", err)
+			redirectWithMessage(w, r, "/admin/users", "更新使用者失敗: "+err.Error(), "danger")
+			return
+		}
+		if !ok {
+			redirectWithMessage(w, r, "/admin/users", "至少需要保留一位可用的管理員", "danger")
+			return
+		}
+	}
+
+	// 更新使用者
+	err = db.UpdateUser(user.ID, role, email, disabled)
+	if err != nil {
+		log.Println("Error updating user:", err)
+		redirectWithMessage(w, r, "/admin/users", "更新使用者失敗: "+err.Error(), "danger")
+		return
+	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditUserUpdate,
+		TargetType: "user",
+		TargetID:   user.ID,
+		TargetName: user.Username,
+		Before:     userAuditSummary(user.Role, user.Email, user.Disabled),
+		After:      userAuditSummary(role, email, disabled),
+	})
+
+	// 停用時立即登出該使用者的所有裝置
+	if disabled {
+		err = db.DeleteOtherAdminSessions(user.Username, "")
+		if err != nil {
+			log.Println("Error revoking sessions:", err)
+		}
+	}
+
+	// 重定向回使用者列表，並帶上成功訊息
+	redirectWithMessage(w, r, "/admin/users", "成功更新使用者: "+user.Username, "success")
+}
+
+// AdminUserDeleteHandler 處理刪除使用者
+func AdminUserDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	session, err := getAdminSession(r)
+	if err != nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 解析表單
+	err = r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/users", "表單解析錯誤", "danger")
+		return
+	}
+
+	// 獲取使用者 ID
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 32)
+	if err != nil {
+		log.Println("Invalid ID:", err)
+		redirectWithMessage(w, r, "/admin/users", "無效的ID", "danger")
+		return
+	}
+
+	user, err := db.GetUser(uint(id))
+	if err != nil {
+		log.Println("Error fetching user:", err)
+		redirectWithMessage(w, r, "/admin/users", "找不到使用者", "danger")
+		return
+	}
+
+	if user.Username == session.Username {
+		redirectWithMessage(w, r, "/admin/users", "不能刪除自己", "danger")
+		return
+	}
+	ok, err := ensureOtherAdmin(user)
+	if err != nil {
+		log.Println("Error counting admins:", err)
+		redirectWithMessage(w, r, "/admin/users", "刪除使用者失敗: "+err.Error(), "danger")
+		return
+	}
+	if !ok {
+		redirectWithMessage(w, r, "/admin/users", "至少需要保留一位可用的管理員", "danger")
+		return
+	}
+
+	// 刪除使用者及其會話
+	err = db.DeleteUser(user.ID)
+	if err != nil {
+		log.Println("Error deleting user:", err)
+		redirectWithMessage(w, r, "/admin/users", "刪除使用者失敗: "+err.Error(), "danger")
+		return
+	}
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditUserDelete,
+		TargetType: "user",
+		TargetID:   user.ID,
+		TargetName: user.Username,
+		Before:     userAuditSummary(user.Role, user.Email, user.Disabled),
+	})
+
+	// 重定向回使用者列表，並帶上成功訊息
+	redirectWithMessage(w, r, "/admin/users", "使用者已成功刪除", "success")
+}
@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"support/db"
+	"support/obj"
+	"text/template"
+)
+
+// 內容變數引用語法：{{var.key}}
+var variablePattern = regexp.MustCompile(`\{\{\s*var\.([A-Za-z0-9_]+)\s*\}\}`)
+
+// 變數名稱僅允許英數字與底線
+var variableKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// loadVariables 從資料庫讀取所有內容變數
+func loadVariables() (map[string]string, error) {
+	variables, err := db.GetVariableList()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(variables))
+	for _, v := range variables {
+		values[v.Key] = v.Value
+	}
+	return values, nil
+}
+
+// substituteVariables 將 Markdown 中的變數引用替換為變數值
+// 未定義的變數保留原樣並回傳其名稱，讓問題在頁面上可見而不是被靜默吞掉
+func substituteVariables(md string, values map[string]string) (string, []string) {
+	var undefined []string
+	seen := map[string]bool{}
+	result := variablePattern.ReplaceAllStringFunc(md, func(ref string) string {
+		key := variablePattern.FindStringSubmatch(ref)[1]
+		if value, ok := values[key]; ok {
+			return value
+		}
+		if !seen[key] {
+			seen[key] = true
+			undefined = append(undefined, key)
+		}
+		return ref
+	})
+	return result, undefined
+}
+
+// undefinedVariables 檢查 Markdown（含引用的內容片段）中未定義的變數，供編輯器提示使用
+func undefinedVariables(md string) []string {
+	values, err := loadVariables()
+	if err != nil {
+		log.Println("Error loading variables:", err)
+		return nil
+	}
+	md, _ = expandSnippets(md, lookupSnippet)
+	_, undefined := substituteVariables(md, values)
+	return undefined
+}
+
+// variableKeys 取得所有已定義的變數名稱，供編輯器即時檢查使用
+func variableKeys() []string {
+	variables, err := db.GetVariableList()
+	if err != nil {
+		log.Println("Error fetching variables:", err)
+		return nil
+	}
+	keys := make([]string, len(variables))
+	for i, v := range variables {
+		keys[i] = v.Key
+	}
+	return keys
+}
+
+// AdminVariablesHandler 處理內容變數管理頁面
+func AdminVariablesHandler(w http.ResponseWriter, r *http.Request) {
+	// 獲取當前的管理員會話
+	session, err := getAdminSession(r)
+	if err != nil {
+		log.Println("Session error:", err)
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	// 從資料庫獲取變數列表
+	variables, err := db.GetVariableList()
+	if err != nil {
+		log.Println("Error fetching variables:", err)
+	}
+
+	// 檢查是否有訊息要顯示
+	message := r.URL.Query().Get("message")
+	messageType := r.URL.Query().Get("type")
+	if messageType == "" && message != "" {
+		messageType = "success" // 預設訊息類型
+	}
+
+	// 準備模板資料
+	data := map[string]interface{}{
+		"Active":      "variables",
+		"Variables":   variables,
+		"Message":     message,
+		"MessageType": messageType,
+		"Username":    session.Username,
+		"CSRFToken":   csrfToken(r),
+	}
+
+	// 解析模板
+	tmpl, err := template.ParseFiles(
+		"templates/admin/layout.html",
+		"templates/admin/variables.html",
+	)
+	if err != nil {
+		log.Println("Template parse error:", err)
+		http.Error(w, "Template parse error", http.StatusInternalServerError)
+		return
+	}
+
+	// 渲染模板
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println("Template execute error:", err)
+		http.Error(w, "Template execute error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AdminVariableAddHandler 處理新增內容變數
+func AdminVariableAddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/variables", http.StatusSeeOther)
+		return
+	}
+
+	// 解析表單
+	err := r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/variables", "表單解析錯誤", "danger")
+		return
+	}
+
+	// 獲取變數資料
+	key := strings.TrimSpace(r.FormValue("key"))
+	if !variableKeyPattern.MatchString(key) {
+		redirectWithMessage(w, r, "/admin/variables", "變數名稱只能包含英文字母、數字與底線", "danger")
+		return
+	}
+
+	variable := obj.Variable{
+		Key:         key,
+		Value:       r.FormValue("value"),
+		Description: r.FormValue("description"),
+	}
+
+	// 保存到資料庫
+	err = db.AddVariable(&variable)
+	if err != nil {
+		log.Println("Error adding variable:", err)
+		redirectWithMessage(w, r, "/admin/variables", "新增變數失敗: "+err.Error(), "danger")
+		return
+	}
+	invalidateAllRenders()
+	updateVariableImageUsage(variable.ID, variable.Value)
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditVariableCreate,
+		TargetType: "variable",
+		TargetID:   variable.ID,
+		TargetName: variable.Key,
+		After:      variableAuditSummary(variable),
+	})
+
+	// 重定向回變數列表，並帶上成功訊息
+	redirectWithMessage(w, r, "/admin/variables", "成功新增變數: "+key, "success")
+}
+
+// AdminVariableEditHandler 處理編輯內容變數
+func AdminVariableEditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/variables", http.StatusSeeOther)
+		return
+	}
+
+	// 解析表單
+	err := r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/variables", "表單解析錯誤", "danger")
+		return
+	}
+
+	// 獲取變數 ID 和資料
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 32)
+	if err != nil {
+		log.Println("Invalid ID:", err)
+		redirectWithMessage(w, r, "/admin/variables", "無效的ID", "danger")
+		return
+	}
+
+	key := strings.TrimSpace(r.FormValue("key"))
+	if !variableKeyPattern.MatchString(key) {
+		redirectWithMessage(w, r, "/admin/variables", "變數名稱只能包含英文字母、數字與底線", "danger")
+		return
+	}
+
+	oldVariable, err := db.GetVariable(uint(id))
+	if err != nil {
+		log.Println("Error fetching variable:", err)
+		redirectWithMessage(w, r, "/admin/variables", "找不到變數", "danger")
+		return
+	}
+
+	// 更新變數
+	err = db.UpdateVariable(uint(id), key, r.FormValue("value"), r.FormValue("description"))
+	if err != nil {
+		log.Println("Error updating variable:", err)
+		redirectWithMessage(w, r, "/admin/variables", "更新變數失敗: "+err.Error(), "danger")
+		return
+	}
+	invalidateAllRenders()
+	updateVariableImageUsage(uint(id), r.FormValue("value"))
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditVariableUpdate,
+		TargetType: "variable",
+		TargetID:   uint(id),
+		TargetName: key,
+		Before:     variableAuditSummary(oldVariable),
+		After: variableAuditSummary(obj.Variable{
+			Key:         key,
+			Value:       r.FormValue("value"),
+			Description: r.FormValue("description"),
+		}),
+	})
+
+	// 重定向回變數列表，並帶上成功訊息
+	redirectWithMessage(w, r, "/admin/variables", "成功更新變數", "success")
+}
+
+// AdminVariableDeleteHandler 處理刪除內容變數
+func AdminVariableDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/variables", http.StatusSeeOther)
+		return
+	}
+
+	// 解析表單
+	err := r.ParseForm()
+	if err != nil {
+		log.Println("Form parse error:", err)
+		redirectWithMessage(w, r, "/admin/variables", "表單解析錯誤", "danger")
+		return
+	}
+
+	// 獲取變數 ID
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 32)
+	if err != nil {
+		log.Println("Invalid ID:", err)
+		redirectWithMessage(w, r, "/admin/variables", "無效的ID", "danger")
+		return
+	}
+
+	oldVariable, err := db.GetVariable(uint(id))
+	if err != nil {
+		log.Println("Error fetching variable:", err)
+		redirectWithMessage(w, r, "/admin/variables", "找不到變數", "danger")
+		return
+	}
+
+	// 刪除變數
+	err = db.DeleteVariable(uint(id))
+	if err != nil {
+		log.Println("Error deleting variable:", err)
+		redirectWithMessage(w, r, "/admin/variables", "刪除變數失敗: "+err.Error(), "danger")
+		return
+	}
+	invalidateAllRenders()
+	recordAudit(r, auditEntry{
+		Action:     obj.AuditVariableDelete,
+		TargetType: "variable",
+		TargetID:   oldVariable.ID,
+		TargetName: oldVariable.Key,
+		Before:     variableAuditSummary(oldVariable),
+	})
+
+	// 重定向回變數列表，並帶上成功訊息
+	redirectWithMessage(w, r, "/admin/variables", "變數已成功刪除", "success")
+}
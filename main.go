@@ -1,12 +1,16 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"support/db"
 	"support/handler"
+	"support/storage"
 )
 
 func removePHP(next http.Handler) http.Handler {
@@ -41,11 +45,11 @@ type CustomNotFoundHandler struct {
 
 func (h *CustomNotFoundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 使用內部 mux 來嘗試處理請求
-	handlerFunc, pattern := h.Mux.Handler(r)
+	_, pattern := h.Mux.Handler(r)
 
 	if pattern != "" {
-		// 有匹配的路由，使用原有處理器
-		handlerFunc.ServeHTTP(w, r)
+		// 有匹配的路由，交由 mux 處理，路由中的 {id} 等參數才會設定到請求上
+		h.Mux.ServeHTTP(w, r)
 		return
 	}
 
@@ -53,9 +57,149 @@ func (h *CustomNotFoundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	handler.NotFoundHandler(w, r)
 }
 
+// envOr 讀取環境變數，未設定時使用預設值
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envInt 讀取整數環境變數，未設定或格式錯誤時使用預設值
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// newStorage 依名稱建立上傳檔案的儲存後端，S3 會先確認 bucket 可以存取
+func newStorage(name string, s3Config storage.S3Config) (storage.Storage, error) {
+	switch name {
+	case "local":
+		return storage.NewLocal(db.UploadStoragePath), nil
+	case "s3":
+		s3, err := storage.NewS3(s3Config)
+		if err != nil {
+			return nil, err
+		}
+		if err := s3.CheckBucket(); err != nil {
+			return nil, err
+		}
+		return s3, nil
+	}
+	return nil, fmt.Errorf("不支援的儲存後端: %s", name)
+}
+
 func main() {
+	// 初始管理員帳號密碼，只在建立新資料庫時使用；未指定時會建立預設帳號並要求登入後立即變更
+	adminUsername := flag.String("admin-user", os.Getenv("ADMIN_USERNAME"), "初始管理員帳號（環境變數 ADMIN_USERNAME）")
+	adminPassword := flag.String("admin-password", os.Getenv("ADMIN_PASSWORD"), "初始管理員密碼（環境變數 ADMIN_PASSWORD）")
+
+	// 單一登入（OpenID Connect），未指定 issuer 時停用
+	oidcIssuer := flag.String("oidc-issuer", os.Getenv("OIDC_ISSUER"), "OIDC 身分提供者的 issuer 網址（環境變數 OIDC_ISSUER）")
+	oidcClientID := flag.String("oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "OIDC client ID（環境變數 OIDC_CLIENT_ID）")
+	oidcClientSecret := flag.String("oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OIDC client secret，公開用戶端可留空（環境變數 OIDC_CLIENT_SECRET）")
+	oidcRedirectURL := flag.String("oidc-redirect-url", os.Getenv("OIDC_REDIRECT_URL"), "OIDC 回呼網址，路徑為 /admin/login/oidc/callback（環境變數 OIDC_REDIRECT_URL）")
+	oidcScopes := flag.String("oidc-scopes", envOr("OIDC_SCOPES", "openid profile email"), "以空白分隔的 OIDC scope（環境變數 OIDC_SCOPES）")
+	oidcGroupsClaim := flag.String("oidc-groups-claim", envOr("OIDC_GROUPS_CLAIM", "groups"), "ID token 中群組的 claim 名稱（環境變數 OIDC_GROUPS_CLAIM）")
+	oidcRoleMap := flag.String("oidc-role-map", os.Getenv("OIDC_ROLE_MAP"), "群組對應角色，例如 support-admins=admin,writers=editor（環境變數 OIDC_ROLE_MAP）")
+	oidcDefaultRole := flag.String("oidc-default-role", os.Getenv("OIDC_DEFAULT_ROLE"), "沒有符合的群組時給予的角色，留空表示拒絕登入（環境變數 OIDC_DEFAULT_ROLE）")
+	oidcName := flag.String("oidc-name", envOr("OIDC_NAME", "SSO"), "登入按鈕上顯示的身分提供者名稱（環境變數 OIDC_NAME）")
+	// 寄信用的 SMTP 設定，用於寄送重設密碼信，未指定主機時停用忘記密碼功能
+	smtpHost := flag.String("smtp-host", os.Getenv("SMTP_HOST"), "SMTP 主機（環境變數 SMTP_HOST）")
+	smtpPort := flag.Int("smtp-port", envInt("SMTP_PORT", 587), "SMTP 連接埠，465 使用 TLS，其他在伺服器支援時使用 STARTTLS（環境變數 SMTP_PORT）")
+	smtpUsername := flag.String("smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP 帳號，留空表示不需登入（環境變數 SMTP_USERNAME）")
+	smtpPassword := flag.String("smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP 密碼（環境變數 SMTP_PASSWORD）")
+	smtpFrom := flag.String("smtp-from", os.Getenv("SMTP_FROM"), "寄件者，例如 \"支援中心 <noreply@example.com>\"（環境變數 SMTP_FROM）")
+	baseURL := flag.String("base-url", os.Getenv("BASE_URL"), "網站網址，用於產生信件中的連結（環境變數 BASE_URL）")
+	passwordLogin := flag.Bool("password-login", os.Getenv("PASSWORD_LOGIN") != "false", "允許以帳號密碼登入（環境變數 PASSWORD_LOGIN=false 可停用）")
+	dedupeImages := flag.Bool("dedupe-images", false, "合併內容相同的圖片並更新文章中的引用後結束，建議在停止伺服器時執行")
+	trustedProxies := flag.String("trusted-proxies", os.Getenv("TRUSTED_PROXIES"), "可信任的反向代理，以逗號分隔的 IP 或 CIDR，只採用這些位址轉送的 X-Forwarded-For 與 X-Real-IP（環境變數 TRUSTED_PROXIES）")
+	passwordHash := flag.String("password-hash", envOr("PASSWORD_HASH", "argon2id"), "新密碼使用的雜湊演算法：argon2id 或 bcrypt（環境變數 PASSWORD_HASH）")
+	// 上傳檔案的儲存後端
+	storageName := flag.String("storage", envOr("STORAGE", "local"), "上傳檔案的儲存後端：local（data/uploads）或 s3（環境變數 STORAGE）")
+	s3Endpoint := flag.String("s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3 相容服務的網址，例如 http://localhost:9000（環境變數 S3_ENDPOINT）")
+	s3Region := flag.String("s3-region", envOr("S3_REGION", "us-east-1"), "S3 區域（環境變數 S3_REGION）")
+	s3Bucket := flag.String("s3-bucket", os.Getenv("S3_BUCKET"), "S3 bucket 名稱（環境變數 S3_BUCKET）")
+	s3AccessKey := flag.String("s3-access-key", os.Getenv("S3_ACCESS_KEY"), "S3 access key（環境變數 S3_ACCESS_KEY）")
+	s3SecretKey := flag.String("s3-secret-key", os.Getenv("S3_SECRET_KEY"), "S3 secret key（環境變數 S3_SECRET_KEY）")
+	s3Prefix := flag.String("s3-prefix", os.Getenv("S3_PREFIX"), "檔案在 bucket 中的路徑前綴，例如 uploads/（環境變數 S3_PREFIX）")
+	s3PathStyle := flag.Bool("s3-path-style", os.Getenv("S3_PATH_STYLE") != "false", "使用 {endpoint}/{bucket}/{key} 形式的網址，MinIO 需要開啟（環境變數 S3_PATH_STYLE=false 可停用）")
+	migrateStorage := flag.String("migrate-storage", "", "將既有的上傳檔案從 -storage 指定的後端搬移到此後端（local 或 s3）後結束")
+	flag.Parse()
+	if err := db.SetPasswordHasher(*passwordHash); err != nil {
+		log.Fatal(err)
+	}
+	if err := handler.SetTrustedProxies(*trustedProxies); err != nil {
+		log.Fatal(err)
+	}
+	s3Config := storage.S3Config{
+		Endpoint:  *s3Endpoint,
+		Region:    *s3Region,
+		Bucket:    *s3Bucket,
+		AccessKey: *s3AccessKey,
+		SecretKey: *s3SecretKey,
+		Prefix:    *s3Prefix,
+		PathStyle: *s3PathStyle,
+	}
+	uploads, err := newStorage(*storageName, s3Config)
+	if err != nil {
+		log.Fatalf("儲存後端設定錯誤: %v", err)
+	}
+	db.SetUploadStorage(uploads)
+	if *adminUsername != "" || *adminPassword != "" {
+		if *adminUsername == "" || *adminPassword == "" {
+			log.Fatal("初始管理員帳號與密碼必須同時指定")
+		}
+		if reason := handler.CheckPasswordStrength(*adminUsername, *adminPassword); reason != "" {
+			log.Fatalf("初始管理員密碼強度不足: %s", reason)
+		}
+		db.SetInitialAdmin(*adminUsername, *adminPassword)
+	}
+
+	if *oidcIssuer != "" {
+		roleMap, err := handler.ParseOIDCRoleMap(*oidcRoleMap)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = handler.ConfigureOIDC(handler.OIDCConfig{
+			Issuer:       *oidcIssuer,
+			ClientID:     *oidcClientID,
+			ClientSecret: *oidcClientSecret,
+			RedirectURL:  *oidcRedirectURL,
+			Scopes:       strings.Fields(*oidcScopes),
+			GroupsClaim:  *oidcGroupsClaim,
+			RoleMap:      roleMap,
+			DefaultRole:  *oidcDefaultRole,
+			DisplayName:  *oidcName,
+		})
+		if err != nil {
+			log.Fatalf("單一登入設定錯誤: %v", err)
+		}
+	}
+	if *smtpHost != "" {
+		err := handler.ConfigureMail(handler.MailConfig{
+			Host:     *smtpHost,
+			Port:     *smtpPort,
+			Username: *smtpUsername,
+			Password: *smtpPassword,
+			From:     *smtpFrom,
+			BaseURL:  *baseURL,
+		})
+		if err != nil {
+			log.Fatalf("SMTP 設定錯誤: %v", err)
+		}
+	}
+	if !*passwordLogin {
+		if *oidcIssuer == "" {
+			log.Fatal("停用帳號密碼登入前必須先設定單一登入，否則沒有人能登入後台")
+		}
+		handler.SetPasswordLogin(false)
+	}
+
 	// 初始化資料庫連接
-	_, err := db.DB()
+	_, err = db.DB()
 	if err != nil {
 		log.Fatalf("資料庫初始化失敗: %v", err)
 	}
@@ -67,12 +211,50 @@ func main() {
 		log.Fatalf("創建上傳目錄失敗: %v", err)
 	}
 
+	// 一次性工作：將上傳檔案搬移到另一個儲存後端
+	if *migrateStorage != "" {
+		target, err := newStorage(*migrateStorage, s3Config)
+		if err != nil {
+			log.Fatalf("儲存後端設定錯誤: %v", err)
+		}
+		if target.Name() == uploads.Name() {
+			log.Fatalf("來源與目標的儲存後端相同: %s", target.Name())
+		}
+		moved, err := db.MigrateUploads(uploads, target)
+		log.Printf("已將 %d 個檔案從 %s 搬移到 %s", moved, uploads.Name(), target.Name())
+		if err != nil {
+			log.Fatalf("搬移上傳檔案失敗: %v", err)
+		}
+		log.Printf("請以 -storage %s 重新啟動伺服器", target.Name())
+		return
+	}
+
+	// 一次性工作：合併重複的圖片
+	if *dedupeImages {
+		merged, err := handler.DedupeImages()
+		if err != nil {
+			log.Fatalf("合併重複圖片失敗: %v", err)
+		}
+		log.Printf("已合併 %d 張重複的圖片", merged)
+		return
+	}
+
+	// 重新建立圖片引用索引，涵蓋升級前的文章與直接修改資料庫的內容
+	if err := handler.RebuildImageUsage(); err != nil {
+		log.Printf("建立圖片引用索引失敗: %v", err)
+	}
+
+	// 在背景定期檢查文章中的失效連結
+	handler.StartLinkChecker()
+
 	// 設定路由
 	mux := http.NewServeMux()
 
 	// 靜態文件服務 - 提供上傳的圖片
-	// 修改靜態文件服務配置，將 /uploads/ 路徑映射到 data/uploads/ 目錄
-	mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir(db.UploadStoragePath))))
+	// 從設定的儲存後端（本機的 data/uploads/ 或 S3）提供檔案，只以允許的圖片、影片與附件格式回應
+	mux.Handle("/uploads/", http.StripPrefix("/uploads/", handler.UploadsHandler()))
+	// 圖片的縮圖與不同寬度的版本，第一次請求時產生
+	mux.HandleFunc("/uploads/{id}/{width}", handler.ImageVariantHandler)
 
 	// 前台路由
 	mux.HandleFunc("/", ExactPathIndexHandler)
@@ -90,7 +272,13 @@ func main() {
 
 	// 後台登入/登出路由 (不需要驗證)
 	mux.HandleFunc("/admin/login", handler.AdminLoginHandler)
+	mux.HandleFunc("/admin/login/2fa", handler.AdminLogin2FAHandler)
+	mux.HandleFunc("/admin/login/oidc", handler.AdminOIDCLoginHandler)
+	mux.HandleFunc("/admin/login/oidc/callback", handler.AdminOIDCCallbackHandler)
+	mux.HandleFunc("/admin/forgot-password", handler.AdminForgotPasswordHandler)
+	mux.HandleFunc("/admin/reset-password", handler.AdminResetPasswordHandler)
 	mux.HandleFunc("/admin/logout", handler.AdminLogoutHandler)
+	mux.HandleFunc("/admin/setup", handler.AuthMiddleware(handler.AdminSetupHandler))
 
 	// 後台管理路由 (需要身份驗證)
 	mux.HandleFunc("/admin", handler.AuthMiddleware(handler.AdminDashboardHandler))
@@ -104,13 +292,64 @@ func main() {
 	mux.HandleFunc("/admin/docs/edit", handler.AuthMiddleware(handler.AdminDocEditHandler))
 	mux.HandleFunc("/admin/docs/update", handler.AuthMiddleware(handler.AdminDocUpdateHandler))
 	mux.HandleFunc("/admin/docs/delete", handler.AuthMiddleware(handler.AdminDocDeleteHandler))
+	mux.HandleFunc("/admin/docs/preview", handler.AuthMiddleware(handler.AdminDocPreviewHandler))
 	// 添加密碼修改路由
 	mux.HandleFunc("/admin/change-password", handler.AuthMiddleware(handler.AdminChangePasswordHandler))
 
+	// 添加登入裝置管理路由
+	mux.HandleFunc("/admin/sessions", handler.AuthMiddleware(handler.AdminSessionsHandler))
+	mux.HandleFunc("/admin/sessions/revoke", handler.AuthMiddleware(handler.AdminSessionRevokeHandler))
+	mux.HandleFunc("/admin/sessions/revoke-all", handler.AuthMiddleware(handler.AdminSessionRevokeAllHandler))
+	mux.HandleFunc("/admin/tokens", handler.AuthMiddleware(handler.AdminAPITokensHandler))
+	mux.HandleFunc("/admin/tokens/create", handler.AuthMiddleware(handler.AdminAPITokenCreateHandler))
+	mux.HandleFunc("/admin/tokens/revoke", handler.AuthMiddleware(handler.AdminAPITokenRevokeHandler))
+
+	// 添加兩步驟驗證路由
+	mux.HandleFunc("/admin/2fa", handler.AuthMiddleware(handler.AdminTwoFactorHandler))
+	mux.HandleFunc("/admin/2fa/enable", handler.AuthMiddleware(handler.AdminTwoFactorEnableHandler))
+	mux.HandleFunc("/admin/2fa/disable", handler.AuthMiddleware(handler.AdminTwoFactorDisableHandler))
+	mux.HandleFunc("/admin/2fa/recovery-codes", handler.AuthMiddleware(handler.AdminRecoveryCodesHandler))
+
 	// 添加圖片相關路由
 	mux.HandleFunc("/admin/images", handler.AuthMiddleware(handler.AdminImagesHandler))
 	mux.HandleFunc("/admin/images/upload", handler.AuthMiddleware(handler.AdminImageUploadHandler))
 	mux.HandleFunc("/admin/images/delete", handler.AuthMiddleware(handler.AdminImageDeleteHandler))
+	mux.HandleFunc("/admin/images/cleanup", handler.AuthMiddleware(handler.AdminImageCleanupHandler))
+	mux.HandleFunc("/admin/images/meta", handler.AuthMiddleware(handler.AdminImageMetaHandler))
+	mux.HandleFunc("/admin/images/library", handler.AuthMiddleware(handler.AdminImageLibraryHandler))
+	mux.HandleFunc("/admin/images/batch", handler.AuthMiddleware(handler.AdminImageBatchUploadHandler))
+	mux.HandleFunc("/admin/images/chunks", handler.AuthMiddleware(handler.AdminImageChunkHandler))
+	mux.HandleFunc("/admin/images/chunks/start", handler.AuthMiddleware(handler.AdminImageChunkStartHandler))
+	mux.HandleFunc("/admin/images/chunks/complete", handler.AuthMiddleware(handler.AdminImageChunkCompleteHandler))
+
+	// 添加內容片段相關路由
+	mux.HandleFunc("/admin/snippets", handler.AuthMiddleware(handler.AdminSnippetsHandler))
+	mux.HandleFunc("/admin/snippets/edit", handler.AuthMiddleware(handler.AdminSnippetEditHandler))
+	mux.HandleFunc("/admin/snippets/delete", handler.AuthMiddleware(handler.AdminSnippetDeleteHandler))
+
+	// 添加內容變數相關路由
+	mux.HandleFunc("/admin/variables", handler.AuthMiddleware(handler.AdminVariablesHandler))
+	mux.HandleFunc("/admin/variables/add", handler.AuthMiddleware(handler.AdminVariableAddHandler))
+	mux.HandleFunc("/admin/variables/edit", handler.AuthMiddleware(handler.AdminVariableEditHandler))
+	mux.HandleFunc("/admin/variables/delete", handler.AuthMiddleware(handler.AdminVariableDeleteHandler))
+
+	// 添加連結檢查相關路由
+	mux.HandleFunc("/admin/link-health", handler.AuthMiddleware(handler.AdminLinkHealthHandler))
+	mux.HandleFunc("/admin/link-health/check", handler.AuthMiddleware(handler.AdminLinkCheckHandler))
+
+	// 添加使用者管理相關路由
+	mux.HandleFunc("/admin/users", handler.AuthMiddleware(handler.AdminUsersHandler))
+	mux.HandleFunc("/admin/users/add", handler.AuthMiddleware(handler.AdminUserAddHandler))
+	mux.HandleFunc("/admin/users/edit", handler.AuthMiddleware(handler.AdminUserEditHandler))
+	mux.HandleFunc("/admin/users/delete", handler.AuthMiddleware(handler.AdminUserDeleteHandler))
+
+	// 添加系統設定路由
+	mux.HandleFunc("/admin/settings", handler.AuthMiddleware(handler.AdminSettingsHandler))
+	mux.HandleFunc("/admin/login-attempts", handler.AuthMiddleware(handler.AdminLoginAttemptsHandler))
+
+	// 添加操作紀錄路由
+	mux.HandleFunc("/admin/audit", handler.AuthMiddleware(handler.AdminAuditHandler))
+	mux.HandleFunc("/admin/audit/export", handler.AuthMiddleware(handler.AdminAuditExportHandler))
 
 	// 創建一個自定義的 NotFound 處理器
 	notFoundWrapper := &CustomNotFoundHandler{Mux: mux}
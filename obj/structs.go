@@ -108,18 +108,305 @@ type Category struct {
 
 // User 管理員使用者
 type User struct {
-	ID       uint   `json:"id" gorm:"primaryKey"`
-	Username string `json:"username" gorm:"unique"`
-	Password string `json:"password"`
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Username   string    `json:"username" gorm:"unique"`
+	Password   string    `json:"password"`
+	Role       string    `json:"role" gorm:"default:viewer"` // 未指定時給予最低權限，升級前的既有使用者由 DB() 設為管理員
+	Disabled   bool      `json:"disabled" gorm:"default:false"`
+	Email      string    `json:"email" gorm:"index"` // 用於寄送重設密碼信
+	CreateTime time.Time `json:"create_time" gorm:"autoCreateTime"`
+
+	// 仍在使用預設帳號密碼，登入後必須先完成初始設定
+	MustChangePassword bool `json:"must_change_password" gorm:"default:false"`
+
+	// 兩步驟驗證（TOTP）
+	TOTPSecret   string `json:"-"` // Base32 編碼的金鑰，啟用前為尚未確認的金鑰
+	TOTPEnabled  bool   `json:"totp_enabled" gorm:"default:false"`
+	TOTPLastStep int64  `json:"-"` // 最近一次成功驗證的時間步，避免同一組驗證碼被重複使用
+
+	// 單一登入（OIDC）帳號對應的身分提供者與使用者識別碼，本機帳號為空值
+	OIDCIssuer  string `json:"oidc_issuer" gorm:"column:oidc_issuer;index:idx_users_oidc"`
+	OIDCSubject string `json:"oidc_subject" gorm:"column:oidc_subject;index:idx_users_oidc"`
+}
+
+// IsSSO 是否為透過單一登入建立的帳號，這類帳號沒有本機密碼
+func (u User) IsSSO() bool {
+	return u.OIDCSubject != ""
+}
+
+// PasswordResetToken 重設密碼的連結，限時且只能使用一次
+type PasswordResetToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index"`
+	TokenHash  string     `json:"-" gorm:"unique"` // 連結中 token 的 SHA-256 雜湊值
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at"`
+	IPAddress  string     `json:"ip_address"` // 申請重設的來源 IP
+	CreateTime time.Time  `json:"create_time" gorm:"autoCreateTime"`
+}
+
+// PasswordHistory 使用者用過的密碼雜湊值，用於避免重複使用最近的密碼
+type PasswordHistory struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"index"`
+	Password   string    `json:"-"` // 變更前的密碼雜湊值
+	CreateTime time.Time `json:"create_time" gorm:"autoCreateTime"`
+}
+
+// RecoveryCode 兩步驟驗證的備用碼，每組只能使用一次
+type RecoveryCode struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"index"`
+	CodeHash   string    `json:"-"` // 備用碼的 SHA-256 雜湊值
+	CreateTime time.Time `json:"create_time" gorm:"autoCreateTime"`
+}
+
+// Setting 全站設定，以鍵值方式儲存
+type Setting struct {
+	Key   string `json:"key" gorm:"primaryKey"`
+	Value string `json:"value"`
+}
+
+// 全站設定的鍵
+const (
+	SettingRequire2FA = "require_2fa" // 所有後台使用者都必須啟用兩步驟驗證
+
+	SettingLoginMaxFailures   = "login_max_failures"    // 同一帳號連續失敗幾次後鎖定
+	SettingLoginMaxIPFailures = "login_max_ip_failures" // 同一 IP 在鎖定時間內失敗幾次後鎖定
+	SettingLoginLockMinutes   = "login_lock_minutes"    // 鎖定時間（分鐘），也是計算連續失敗的時間範圍
+	SettingLoginBackoffSecond = "login_backoff_seconds" // 失敗後的基本等待秒數，每次失敗加倍
+
+	SettingPasswordMinLength   = "password_min_length"   // 密碼最短長度
+	SettingPasswordMinClasses  = "password_min_classes"  // 大寫、小寫、數字、符號至少需要包含幾種
+	SettingPasswordHistory     = "password_history"      // 不可重複使用最近幾次的密碼，0 表示不限制
+	SettingPasswordBlockCommon = "password_block_common" // 禁止使用常見或已外洩的密碼
+)
+
+// 使用者角色
+const (
+	RoleAdmin    = "admin"    // 管理員：所有權限，包含使用者管理
+	RoleEditor   = "editor"   // 編輯：管理文章、內容片段、變數與圖片，不能刪除分類或管理使用者
+	RoleReviewer = "reviewer" // 審稿：可編輯及發布既有文章，不能新增或刪除
+	RoleViewer   = "viewer"   // 檢視：只能瀏覽後台與預覽草稿
+)
+
+// RoleInfo 角色的顯示資訊
+type RoleInfo struct {
+	Role        string
+	Label       string
+	Description string
+}
+
+// Roles 所有角色，依權限由高到低排列
+var Roles = []RoleInfo{
+	{RoleAdmin, "管理員", "所有權限，包含使用者管理與刪除分類"},
+	{RoleEditor, "編輯", "新增、編輯、刪除文章，管理內容片段、變數與圖片"},
+	{RoleReviewer, "審稿", "編輯及發布既有文章"},
+	{RoleViewer, "檢視", "瀏覽後台與預覽草稿"},
+}
+
+// RoleLabel 取得角色的顯示名稱
+func RoleLabel(role string) string {
+	for _, info := range Roles {
+		if info.Role == role {
+			return info.Label
+		}
+	}
+	return role
 }
 
 // AdminSession 管理員會話
 type AdminSession struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
-	SessionID string    `json:"session_id" gorm:"unique"`
-	Username  string    `json:"username"`
+	SessionID string    `json:"session_id" gorm:"unique"` // 會話ID的 SHA-256 雜湊值，原始值只存在於 Cookie
+	Username  string    `json:"username" gorm:"index"`
 	Expiry    time.Time `json:"expiry"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	LastSeen  time.Time `json:"last_seen"`
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	Role      string    `json:"role" gorm:"-"`       // 由使用者資料帶入，不存入資料庫
+	TwoFactor bool      `json:"two_factor" gorm:"-"` // 使用者是否已啟用兩步驟驗證
+	NeedSetup bool      `json:"need_setup" gorm:"-"` // 使用者仍在使用預設帳號密碼
+	SSO       bool      `json:"sso" gorm:"-"`        // 單一登入帳號，沒有本機密碼
+	TokenID   uint      `json:"token_id" gorm:"-"`   // 以 API token 驗證時為 token 的 ID，瀏覽器會話為 0
+	Scopes    []string  `json:"scopes" gorm:"-"`     // API token 的授權範圍
+}
+
+// LoginAttempt 登入嘗試紀錄，用於限制暴力破解與顯示登入失敗事件
+type LoginAttempt struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Username   string    `json:"username" gorm:"index"` // 使用者輸入的名稱，不一定是存在的帳號
+	IPAddress  string    `json:"ip_address" gorm:"index"`
+	UserAgent  string    `json:"user_agent"`
+	Success    bool      `json:"success"`
+	Blocked    bool      `json:"blocked"` // 因鎖定而未驗證密碼，不計入連續失敗次數
+	Reason     string    `json:"reason"`
+	CreateTime time.Time `json:"create_time" gorm:"autoCreateTime;index"`
+}
+
+// AuditLog 後台操作紀錄，只會新增不會修改或刪除
+type AuditLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Username   string    `json:"username" gorm:"index"`
+	Action     string    `json:"action" gorm:"index"`
+	TargetType string    `json:"target_type"` // 操作對象的類型，例如 doc、category
+	TargetID   uint      `json:"target_id"`
+	TargetName string    `json:"target_name"` // 操作當下對象的名稱，對象刪除後仍可辨識
+	Before     string    `json:"before"`      // 變更前的摘要
+	After      string    `json:"after"`       // 變更後的摘要
+	IPAddress  string    `json:"ip_address"`
+	CreateTime time.Time `json:"create_time" gorm:"autoCreateTime;index"`
+}
+
+// AuditFilter 查詢操作紀錄的條件，空值表示不限制
+type AuditFilter struct {
+	Username string
+	Action   string
+	From     time.Time
+	To       time.Time // 不含此時間
+}
+
+// 操作紀錄的動作
+const (
+	AuditLogin          = "auth.login"
+	AuditLoginSSO       = "auth.login_sso"
+	AuditLogout         = "auth.logout"
+	AuditPasswordChange = "auth.password_change"
+	AuditPasswordForgot = "auth.password_forgot"
+	AuditPasswordReset  = "auth.password_reset"
+	AuditInitialSetup   = "auth.setup"
+	AuditTwoFactorOn    = "auth.2fa_enable"
+	AuditTwoFactorOff   = "auth.2fa_disable"
+	AuditRecoveryCodes  = "auth.recovery_codes"
+	AuditSessionRevoke  = "auth.session_revoke"
+	AuditTokenCreate    = "auth.token_create"
+	AuditTokenRevoke    = "auth.token_revoke"
+
+	AuditDocCreate    = "doc.create"
+	AuditDocUpdate    = "doc.update"
+	AuditDocPublish   = "doc.publish"
+	AuditDocUnpublish = "doc.unpublish"
+	AuditDocDelete    = "doc.delete"
+
+	AuditCategoryCreate = "category.create"
+	AuditCategoryUpdate = "category.update"
+	AuditCategoryDelete = "category.delete"
+
+	AuditImageUpload = "image.upload"
+	AuditImageDelete = "image.delete"
+	AuditImageMerge  = "image.merge"
+	AuditImageClean  = "image.cleanup"
+	AuditImageUpdate = "image.update"
+
+	AuditSnippetSave    = "snippet.save"
+	AuditSnippetDelete  = "snippet.delete"
+	AuditVariableCreate = "variable.create"
+	AuditVariableUpdate = "variable.update"
+	AuditVariableDelete = "variable.delete"
+
+	AuditUserCreate     = "user.create"
+	AuditUserUpdate     = "user.update"
+	AuditUserDelete     = "user.delete"
+	AuditSettingsUpdate = "settings.update"
+)
+
+// AuditActionInfo 操作紀錄動作的顯示資訊
+type AuditActionInfo struct {
+	Action string
+	Label  string
+}
+
+// AuditActions 所有操作紀錄的動作，用於篩選與顯示
+var AuditActions = []AuditActionInfo{
+	{AuditLogin, "登入"},
+	{AuditLoginSSO, "單一登入"},
+	{AuditLogout, "登出"},
+	{AuditPasswordChange, "修改密碼"},
+	{AuditPasswordForgot, "申請重設密碼"},
+	{AuditPasswordReset, "重設密碼"},
+	{AuditInitialSetup, "初始設定"},
+	{AuditTwoFactorOn, "啟用兩步驟驗證"},
+	{AuditTwoFactorOff, "停用兩步驟驗證"},
+	{AuditRecoveryCodes, "重新產生備用碼"},
+	{AuditSessionRevoke, "登出裝置"},
+	{AuditTokenCreate, "建立 API token"},
+	{AuditTokenRevoke, "撤銷 API token"},
+	{AuditDocCreate, "新增文件"},
+	{AuditDocUpdate, "編輯文件"},
+	{AuditDocPublish, "發布文件"},
+	{AuditDocUnpublish, "取消發布文件"},
+	{AuditDocDelete, "刪除文件"},
+	{AuditCategoryCreate, "新增分類"},
+	{AuditCategoryUpdate, "編輯分類"},
+	{AuditCategoryDelete, "刪除分類"},
+	{AuditImageUpload, "上傳圖片"},
+	{AuditImageDelete, "刪除圖片"},
+	{AuditImageMerge, "合併重複圖片"},
+	{AuditImageClean, "清除未使用的圖片"},
+	{AuditImageUpdate, "編輯圖片資訊"},
+	{AuditSnippetSave, "儲存內容片段"},
+	{AuditSnippetDelete, "刪除內容片段"},
+	{AuditVariableCreate, "新增內容變數"},
+	{AuditVariableUpdate, "編輯內容變數"},
+	{AuditVariableDelete, "刪除內容變數"},
+	{AuditUserCreate, "新增使用者"},
+	{AuditUserUpdate, "變更使用者"},
+	{AuditUserDelete, "刪除使用者"},
+	{AuditSettingsUpdate, "變更系統設定"},
+}
+
+// AuditActionLabel 取得動作的顯示名稱
+func AuditActionLabel(action string) string {
+	for _, info := range AuditActions {
+		if info.Action == action {
+			return info.Label
+		}
+	}
+	return action
+}
+
+// APIToken 個人 API token，供腳本與 CI 以 Authorization: Bearer 標頭存取後台
+type APIToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Username   string     `json:"username" gorm:"index"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-" gorm:"unique"` // token 的 SHA-256 雜湊值，原始值只在建立時顯示一次
+	Prefix     string     `json:"prefix"`          // token 開頭的幾個字元，方便辨識
+	Scopes     string     `json:"scopes"`          // 以空白分隔的授權範圍
+	ExpiresAt  *time.Time `json:"expires_at"`      // 空值表示永不過期
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	CreateTime time.Time  `json:"create_time" gorm:"autoCreateTime"`
+}
+
+// API token 的授權範圍
+const (
+	ScopeDocsRead    = "docs:read"
+	ScopeDocsWrite   = "docs:write"
+	ScopeImagesRead  = "images:read"
+	ScopeImagesWrite = "images:write"
+)
+
+// ScopeInfo 授權範圍的顯示資訊
+type ScopeInfo struct {
+	Scope       string
+	Description string
+}
+
+// Scopes 所有授權範圍
+var Scopes = []ScopeInfo{
+	{ScopeDocsRead, "讀取文件與分類"},
+	{ScopeDocsWrite, "新增、編輯、發布及刪除文件"},
+	{ScopeImagesRead, "讀取圖片列表"},
+	{ScopeImagesWrite, "上傳及刪除圖片"},
+}
+
+// AdminSessionInfo 會話管理頁面顯示的會話資訊
+type AdminSessionInfo struct {
+	AdminSession
+	Device  string // 由 User-Agent 判斷的瀏覽器與作業系統
+	Current bool   // 是否為目前使用中的會話
 }
 
 type IndexData struct {
@@ -138,6 +425,19 @@ type Doc struct {
 	LastEditDate time.Time `json:"last_edit_date" gorm:"autoUpdateTime"`
 	CategoryID   uint      `json:"category_id"`
 	IsDraft      bool      `json:"is_draft" gorm:"default:false"` // 新增草稿標記
+	ShowTOC      bool      `json:"show_toc" gorm:"default:true"`  // 是否顯示文章目錄
+	TOCDepth     int       `json:"toc_depth" gorm:"default:3"`    // 目錄包含的最深標題層級 (1-6)
+}
+
+// DefaultTOCDepth 目錄預設包含到 h3
+const DefaultTOCDepth = 3
+
+// TOCItem 文章目錄中的一個標題節點
+type TOCItem struct {
+	Level    int
+	ID       string // 標題錨點
+	Text     string
+	Children []TOCItem
 }
 
 // 為了渲染模板，我們再做一個結構把需要的全部資料包起來
@@ -151,24 +451,164 @@ type DocPageData struct {
 	CurrentCategory   string // 給前端JS用
 	CurrentCategoryID string
 	Categories        []Category
+	TOC               []TOCItem // 文章目錄，未啟用時為空
+}
+
+// Snippet 可重複使用的內容片段，文章中以 {{< include "name" >}} 引用
+type Snippet struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Name       string    `json:"name" gorm:"unique"` // 引用時使用的名稱
+	Title      string    `json:"title"`              // 管理介面顯示用的說明
+	Content    string    `json:"content"`            // URL 編碼後的 Markdown，與 Doc.Content 相同
+	CreateTime time.Time `json:"create_time" gorm:"autoCreateTime"`
+	UpdateTime time.Time `json:"update_time" gorm:"autoUpdateTime"`
+}
+
+// Variable 全站內容變數，文章中以 {{var.key}} 引用
+type Variable struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Key         string    `json:"key" gorm:"unique"`
+	Value       string    `json:"value"`
+	Description string    `json:"description"`
+	CreateTime  time.Time `json:"create_time" gorm:"autoCreateTime"`
+	UpdateTime  time.Time `json:"update_time" gorm:"autoUpdateTime"`
+}
+
+// 連結檢查發現的問題類型
+const (
+	LinkIssueBrokenDoc    = "broken_doc"    // 指向不存在的文章
+	LinkIssueDraftDoc     = "draft_doc"     // 指向尚未發布的草稿
+	LinkIssueMissingImage = "missing_image" // 指向不存在的圖片或上傳檔案
+)
+
+// LinkIssue 已發布文章中的失效站內連結或圖片
+type LinkIssue struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	DocID     uint      `json:"doc_id" gorm:"index"`
+	DocTitle  string    `json:"doc_title"`
+	Target    string    `json:"target"` // 連結的原始網址
+	IsImage   bool      `json:"is_image"`
+	Kind      string    `json:"kind"`
+	CheckedAt time.Time `json:"checked_at"`
 }
 
 // Image 圖片資料結構
 type Image struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
 	Filename    string    `json:"filename"`
-	Path        string    `json:"path"`
+	Path        string    `json:"path"` // 檔案在儲存後端中的位置，僅供查找，讀寫檔案一律使用 db.UploadKey
 	URL         string    `json:"url"`
 	Size        int64     `json:"size"`
 	ContentType string    `json:"content_type"`
+	Kind        string    `json:"kind" gorm:"index;default:image"` // 檔案種類，ImageKind 常數之一，舊資料為圖片
+	ContentHash string    `json:"content_hash" gorm:"index"`       // 檔案內容的 SHA-256，用於避免重複保存相同的圖片
+	Width       int       `json:"width"`                           // 像素寬度，舊資料為 0 時於使用時補上
+	Height      int       `json:"height"`                          // 像素高度
+	Alt         string    `json:"alt"`                             // 替代文字，編輯器插入圖片時使用
+	Caption     string    `json:"caption"`                         // 說明文字，插入為 Markdown 圖片的標題
+	Tags        string    `json:"tags"`                            // 以逗號分隔的標籤，用於搜尋
 	UploadTime  time.Time `json:"upload_time" gorm:"autoCreateTime"`
 }
 
+// IsImage 是否為圖片，只有圖片有縮圖與不同寬度的版本
+func (i Image) IsImage() bool {
+	return i.Kind == "" || i.Kind == ImageKindImage
+}
+
+// 上傳檔案的種類
+const (
+	ImageKindImage    = "image"    // JPEG、PNG、GIF、WebP 圖片
+	ImageKindDocument = "document" // PDF 文件
+	ImageKindArchive  = "archive"  // ZIP、gzip 壓縮檔
+	ImageKindVideo    = "video"    // MP4、WebM 影片，文章中以播放器顯示
+	ImageKindFile     = "file"     // 韌體等其他二進位檔案
+)
+
+// ImageKindOption 檔案種類的顯示資訊
+type ImageKindOption struct {
+	Value string
+	Label string
+}
+
+// ImageKinds 圖片列表可篩選的檔案種類
+var ImageKinds = []ImageKindOption{
+	{ImageKindImage, "圖片"},
+	{ImageKindDocument, "文件"},
+	{ImageKindArchive, "壓縮檔"},
+	{ImageKindVideo, "影片"},
+	{ImageKindFile, "其他檔案"},
+}
+
+// ImageFilter 查詢圖片的條件
+type ImageFilter struct {
+	Keyword string // 比對檔名、替代文字、說明與標籤，空值表示不限制
+	Sort    string // ImageSort 常數之一，空值為最新上傳的排在前面
+	Kind    string // ImageKind 常數之一，空值表示不限制
+	Offset  int
+	Limit   int // 0 表示不限制筆數
+}
+
+// 圖片列表的排序方式
+const (
+	ImageSortNewest   = "newest"
+	ImageSortOldest   = "oldest"
+	ImageSortLargest  = "largest"
+	ImageSortSmallest = "smallest"
+)
+
+// ImageSortOption 圖片列表排序方式的顯示資訊
+type ImageSortOption struct {
+	Value string
+	Label string
+}
+
+// ImageSorts 圖片列表可選的排序方式
+var ImageSorts = []ImageSortOption{
+	{ImageSortNewest, "最新上傳"},
+	{ImageSortOldest, "最早上傳"},
+	{ImageSortLargest, "檔案最大"},
+	{ImageSortSmallest, "檔案最小"},
+}
+
+// ImageUsage 圖片被文章、內容片段或內容變數引用的索引，儲存時更新。
+// DocID、SnippetID 與 VariableID 只有其中一個不為 0
+type ImageUsage struct {
+	ID         uint `json:"id" gorm:"primaryKey"`
+	ImageID    uint `json:"image_id" gorm:"index"`
+	DocID      uint `json:"doc_id" gorm:"index"`      // 引用圖片的文章
+	SnippetID  uint `json:"snippet_id" gorm:"index"`  // 引用圖片的內容片段
+	VariableID uint `json:"variable_id" gorm:"index"` // 值中引用圖片的內容變數
+}
+
+// ImageUsageCount 圖片被引用的次數
+type ImageUsageCount struct {
+	Docs      int
+	Snippets  int
+	Variables int
+}
+
+// InUse 圖片是否仍被引用
+func (c ImageUsageCount) InUse() bool {
+	return c.Docs > 0 || c.Snippets > 0 || c.Variables > 0
+}
+
 // ImageListData 圖片列表頁面資料
 type ImageListData struct {
-	Images   []Image
-	Message  string
-	MsgType  string
-	Username string
-	Active   string // 添加 Active 字段，用於控制側邊欄選中狀態
+	Images     []Image
+	Usage      map[uint]ImageUsageCount // 以圖片 ID 為鍵，未被引用的圖片不在其中
+	Orphans    int                      // 可以清除的未使用圖片數量
+	Query      string                   // 搜尋關鍵字
+	Sort       string                   // 排序方式
+	Sorts      []ImageSortOption
+	Kind       string // 篩選的檔案種類
+	Kinds      []ImageKindOption
+	Total      int64 // 符合搜尋條件的圖片數量
+	Page       int
+	TotalPages int
+	ReturnURL  string // 目前頁面的網址，編輯或刪除後回到同一頁
+	Message    string
+	MsgType    string
+	Username   string
+	Active     string // 添加 Active 字段，用於控制側邊欄選中狀態
+	CSRFToken  string
 }
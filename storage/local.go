@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local 將檔案保存在本機目錄
+type Local struct {
+	Dir string
+}
+
+// NewLocal 建立使用本機目錄的後端，目錄會在第一次寫入時建立
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+func (l *Local) Name() string { return "local" }
+
+// path 將 key 轉換為本機路徑
+func (l *Local) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(key string, r io.Reader, size int64, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// 先寫入暫存檔再改名，避免讀到寫到一半的檔案
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Open(key string) (io.ReadSeekCloser, Info, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, Info{}, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	return f, Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (l *Local) Stat(key string) (Info, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		return Info{}, err
+	}
+	if stat.IsDir() {
+		return Info{}, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	return Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (l *Local) Delete(key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) Location(key string) string {
+	name, err := l.path(key)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(name)
+}
@@ -0,0 +1,382 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config S3 相容物件儲存的連線設定，可用於 AWS S3、MinIO 等服務
+type S3Config struct {
+	Endpoint  string // 服務網址，例如 https://s3.ap-northeast-1.amazonaws.com 或 http://localhost:9000
+	Region    string // 簽章使用的區域，MinIO 預設為 us-east-1
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // 所有檔案放在 bucket 中的這個路徑之下，例如 "uploads/"
+	PathStyle bool   // 使用 {endpoint}/{bucket}/{key} 形式的網址，MinIO 需要開啟
+}
+
+// S3 將檔案保存在 S3 相容的物件儲存，以 AWS Signature Version 4 簽署請求
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// unsignedPayload 不計算內容的雜湊，上傳時可以直接串流檔案
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyPayloadHash 空內容的 SHA-256
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// NewS3 建立 S3 相容物件儲存的後端
+func NewS3(config S3Config) (*S3, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3 必須設定 endpoint 與 bucket")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3 必須設定 access key 與 secret key")
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("無效的 S3 endpoint: %s", config.Endpoint)
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Prefix != "" && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client: &http.Client{
+			// 下載大檔案時不限制總時間，只限制連線與等待回應的時間
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: 30 * time.Second,
+				MaxIdleConnsPerHost:   16,
+			},
+		},
+		now: time.Now,
+	}, nil
+}
+
+func (s *S3) Name() string { return "s3" }
+
+// CheckBucket 確認 bucket 存在且憑證有效，於伺服器啟動時呼叫
+func (s *S3) CheckBucket() error {
+	resp, err := s.do(http.MethodHead, "", nil, nil, -1)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("無法存取 S3 bucket %s: %s", s.config.Bucket, resp.Status)
+	}
+	return nil
+}
+
+func (s *S3) Put(key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(http.MethodPut, key, header, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, key)
+	}
+	return nil
+}
+
+func (s *S3) Open(key string) (io.ReadSeekCloser, Info, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return &s3Object{s3: s, key: key, size: info.Size}, info, nil
+}
+
+func (s *S3) Stat(key string) (Info, error) {
+	if err := validKey(key); err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(http.MethodHead, key, nil, nil, -1)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, s3Error(resp, key)
+	}
+	info := Info{Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+func (s *S3) Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, key, nil, nil, -1)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp, key)
+	}
+	return nil
+}
+
+func (s *S3) Location(key string) string {
+	return "s3://" + s.config.Bucket + "/" + s.config.Prefix + key
+}
+
+// objectURL 物件的網址，key 為空字串時為 bucket 本身
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	objectPath := ""
+	if key != "" {
+		objectPath = s.config.Prefix + key
+	}
+	if s.config.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket + "/" + objectPath
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + objectPath
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	return &u
+}
+
+// do 簽署並送出請求，size 為 -1 表示沒有內容
+func (s *S3) do(method, key string, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := s.objectURL(key)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+		payloadHash = unsignedPayload
+	}
+	s.sign(req, payloadHash)
+	return s.client.Do(req)
+}
+
+// sign 以 AWS Signature Version 4 簽署請求
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// 簽署 Host、Content-Type、Range 與所有 x-amz-* 標頭
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lower := strings.ToLower(k)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// uriEncode 依 AWS 的規則編碼，只保留 A-Z a-z 0-9 - _ . ~，路徑中的斜線不編碼
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery 依名稱排序並編碼查詢參數
+func canonicalQuery(values url.Values) string {
+	var pairs []string
+	for k, vs := range values {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Error 將錯誤回應轉換為 error，找不到物件時可用 errors.Is(err, fs.ErrNotExist) 判斷
+func s3Error(resp *http.Response, key string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: "s3", Path: key, Err: fs.ErrNotExist}
+	}
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return fmt.Errorf("S3 %s: %s: %s (%s)", key, resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("S3 %s: %s", key, resp.Status)
+}
+
+// s3Object 可 Seek 的物件內容，讀取時才以 Range 請求下載目前位置之後的內容
+type s3Object struct {
+	s3     *S3
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		header := http.Header{}
+		header.Set("Range", "bytes="+strconv.FormatInt(o.offset, 10)+"-")
+		resp, err := o.s3.do(http.MethodGet, o.key, header, nil, -1)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return 0, s3Error(resp, o.key)
+		}
+		if resp.StatusCode == http.StatusOK && o.offset > 0 {
+			// 不支援 Range 的服務會回傳整個檔案，略過前面的內容
+			if _, err := io.CopyN(io.Discard, resp.Body, o.offset); err != nil {
+				resp.Body.Close()
+				return 0, err
+			}
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = o.offset + offset
+	case io.SeekEnd:
+		target = o.size + offset
+	default:
+		return 0, errors.New("無效的 whence")
+	}
+	if target < 0 {
+		return 0, errors.New("無效的位置")
+	}
+	if target != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = target
+	return target, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
@@ -0,0 +1,60 @@
+// Package storage 上傳檔案的儲存後端，可使用本機目錄或 S3 相容的物件儲存
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// Info 檔案的大小與最後修改時間
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage 保存上傳檔案的後端。key 為以斜線分隔的相對路徑，例如 "1712345678_42.png"。
+// 檔案不存在時回傳的錯誤可用 errors.Is(err, fs.ErrNotExist) 判斷
+type Storage interface {
+	// Name 後端名稱，用於設定與記錄
+	Name() string
+	// Put 保存檔案，已存在時覆寫。size 為內容的位元組數
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Open 開啟檔案，可 Seek 以讀取部分內容
+	Open(key string) (io.ReadSeekCloser, Info, error)
+	// Stat 取得檔案資訊
+	Stat(key string) (Info, error)
+	// Delete 刪除檔案，檔案不存在時不視為錯誤
+	Delete(key string) error
+	// Location 檔案在後端中的位置，記錄在圖片的 Path 欄位，方便管理者查找
+	Location(key string) string
+}
+
+// validKey 檢查 key 是否為安全的相對路徑，避免讀寫後端以外的位置
+func validKey(key string) error {
+	if !fs.ValidPath(key) || key == "." {
+		return fmt.Errorf("無效的檔案路徑: %q", key)
+	}
+	return nil
+}
+
+// Copy 將檔案從一個後端複製到另一個後端，並確認大小一致
+func Copy(dst, src Storage, key, contentType string) error {
+	file, info, err := src.Open(key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := dst.Put(key, file, info.Size, contentType); err != nil {
+		return err
+	}
+	copied, err := dst.Stat(key)
+	if err != nil {
+		return err
+	}
+	if copied.Size != info.Size {
+		return fmt.Errorf("%s 複製後大小不符: %d != %d", key, copied.Size, info.Size)
+	}
+	return nil
+}